@@ -0,0 +1,62 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// parseAssets reads the space separated Assets key, naming the
+// auxiliary files, relative to the instance file's directory unless
+// absolute, whose content affects the component's behaviour. Each is
+// mapped to a hash of its content so that editing an asset counts as
+// a change to the component while editing other files next to it
+// doesn't.
+func parseAssets(instanceFile, s string) (map[string]string, error) {
+	assets := make(map[string]string)
+	for _, name := range strings.Fields(s) {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(instanceFile), path)
+		}
+		sum, err := hashFile(path)
+		if err != nil {
+			return nil, errors.New("asset " + name + ": " + err.Error())
+		}
+		assets[path] = sum
+	}
+	return assets, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func equalAssets(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, sum := range a {
+		if b[path] != sum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danos/mgmterror"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultFailureCooldown  = 30 * time.Second
+)
+
+// CircuitState describes whether a component's scripts are being
+// run normally or short-circuited after repeated failures.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	// CircuitHalfOpen is when the cooldown has passed and a single
+	// trial invocation has been let through, whose result decides
+	// whether the circuit closes or opens again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// FailurePolicy says what should happen to a component once its
+// circuit opens.
+type FailurePolicy int
+
+const (
+	FailureIgnore FailurePolicy = iota
+	FailureDeactivate
+	FailureRestart
+)
+
+func (p FailurePolicy) String() string {
+	switch p {
+	case FailureIgnore:
+		return "ignore"
+	case FailureDeactivate:
+		return "deactivate"
+	case FailureRestart:
+		return "restart"
+	default:
+		return "unknown"
+	}
+}
+
+func parseFailurePolicy(s string) (FailurePolicy, error) {
+	switch s {
+	case "", "ignore":
+		return FailureIgnore, nil
+	case "deactivate":
+		return FailureDeactivate, nil
+	case "restart":
+		return FailureRestart, nil
+	default:
+		return FailureIgnore, errors.New(
+			"unknown OnRepeatedFailure policy " + s)
+	}
+}
+
+// breaker tracks consecutive script failures for a component. Once
+// threshold failures have been seen in a row the circuit opens and
+// further invocations fail immediately until cooldown has passed,
+// after which a single trial invocation decides whether the circuit
+// closes again. A threshold of zero disables the breaker.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	onChange  func(CircuitState)
+
+	mu       sync.Mutex
+	failures int
+	state    CircuitState
+	openedAt time.Time
+}
+
+func (b *breaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a script may be run. Once the cooldown has
+// passed the circuit is half-open and only the first caller is let
+// through, as the trial; the others fail until its result is recorded.
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	prev := b.state
+	switch {
+	case b.state == CircuitClosed:
+		b.mu.Unlock()
+		return nil
+	case b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown:
+		b.state = CircuitHalfOpen
+		b.mu.Unlock()
+		b.changed(prev, CircuitHalfOpen)
+		return nil
+	}
+	err := mgmterror.NewOperationFailedApplicationError()
+	err.Message = "circuit " + b.state.String() + " after " +
+		strconv.Itoa(b.failures) + " consecutive failures"
+	b.mu.Unlock()
+	return err
+}
+
+// release gives up a trial that was let through but never ran its
+// script, so that the next caller makes the trial instead.
+func (b *breaker) release() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	prev := b.state
+	if b.state == CircuitHalfOpen {
+		b.state = CircuitOpen
+	}
+	state := b.state
+	b.mu.Unlock()
+	b.changed(prev, state)
+}
+
+// reset closes the circuit, forgetting the failures counted so far.
+func (b *breaker) reset() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	prev := b.state
+	b.failures = 0
+	b.state = CircuitClosed
+	b.mu.Unlock()
+	b.changed(prev, CircuitClosed)
+}
+
+func (b *breaker) record(success bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	prev := b.state
+	switch {
+	case success:
+		b.failures = 0
+		b.state = CircuitClosed
+	case b.state == CircuitHalfOpen:
+		// The trial failed, so wait out another cooldown.
+		b.failures++
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state = CircuitOpen
+			b.openedAt = time.Now()
+		}
+	}
+	state := b.state
+	b.mu.Unlock()
+	b.changed(prev, state)
+}
+
+func (b *breaker) changed(prev, state CircuitState) {
+	if state != prev && b.onChange != nil {
+		b.onChange(state)
+	}
+}
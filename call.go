@@ -0,0 +1,90 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import "errors"
+
+// CallRPC runs the RPC module/name of whichever of the component's
+// enabled models provides it, with in as its RFC 7951 encoded input,
+// as if it had been called over the bus, and returns its output.
+func (c *Component) CallRPC(module, name string, in []byte) ([]byte, error) {
+	for _, modelName := range c.ModelNames() {
+		m := c.models[modelName]
+		if !m.enabled || m.rpc == nil {
+			continue
+		}
+		script, ok := m.rpc.modules[module][name]
+		if !ok {
+			continue
+		}
+		return m.rpc.call(module, name, script)(nil, in)
+	}
+	return nil, errors.New(c.name + " has no RPC " + module + "/" + name)
+}
+
+// model returns the named model, if it is enabled, as disabled
+// models aren't registered on the bus.
+func (c *Component) model(modelName string) (*Model, error) {
+	m, ok := c.models[modelName]
+	if !ok {
+		return nil, errors.New(c.name + " has no model " + modelName)
+	}
+	if !m.enabled {
+		return nil, errors.New(modelName + " is disabled")
+	}
+	return m, nil
+}
+
+// GetConfig returns the RFC 7951 encoded configuration of the named
+// model, as its Config/Get returns it, or the error Config/Get
+// failed with.
+func (c *Component) GetConfig(modelName string) ([]byte, error) {
+	m, err := c.model(modelName)
+	if err != nil {
+		return nil, err
+	}
+	if m.config == nil {
+		return nil, errors.New(modelName + " has no configuration")
+	}
+	return m.config.getConfig()
+}
+
+// SetConfig applies the RFC 7951 encoded configuration to the named
+// model with its Config/Set.
+func (c *Component) SetConfig(modelName string, in []byte) error {
+	m, err := c.model(modelName)
+	if err != nil {
+		return err
+	}
+	if m.config == nil {
+		return errors.New(modelName + " has no configuration")
+	}
+	return m.config.Set(in)
+}
+
+// CheckConfig validates the RFC 7951 encoded configuration with the
+// named model's Config/Check.
+func (c *Component) CheckConfig(modelName string, in []byte) error {
+	m, err := c.model(modelName)
+	if err != nil {
+		return err
+	}
+	if m.config == nil {
+		return errors.New(modelName + " has no configuration")
+	}
+	return m.config.Check(in)
+}
+
+// GetState returns the RFC 7951 encoded state of the named model, as
+// its State/Get returns it, or the error State/Get failed with.
+func (c *Component) GetState(modelName string) ([]byte, error) {
+	m, err := c.model(modelName)
+	if err != nil {
+		return nil, err
+	}
+	if m.state == nil {
+		return nil, errors.New(modelName + " has no state")
+	}
+	return m.state.getState()
+}
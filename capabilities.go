@@ -0,0 +1,94 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var capabilities = map[string]uintptr{
+	"AUDIT_CONTROL":    unix.CAP_AUDIT_CONTROL,
+	"AUDIT_READ":       unix.CAP_AUDIT_READ,
+	"AUDIT_WRITE":      unix.CAP_AUDIT_WRITE,
+	"BLOCK_SUSPEND":    unix.CAP_BLOCK_SUSPEND,
+	"CHOWN":            unix.CAP_CHOWN,
+	"DAC_OVERRIDE":     unix.CAP_DAC_OVERRIDE,
+	"DAC_READ_SEARCH":  unix.CAP_DAC_READ_SEARCH,
+	"FOWNER":           unix.CAP_FOWNER,
+	"FSETID":           unix.CAP_FSETID,
+	"IPC_LOCK":         unix.CAP_IPC_LOCK,
+	"IPC_OWNER":        unix.CAP_IPC_OWNER,
+	"KILL":             unix.CAP_KILL,
+	"LEASE":            unix.CAP_LEASE,
+	"LINUX_IMMUTABLE":  unix.CAP_LINUX_IMMUTABLE,
+	"MAC_ADMIN":        unix.CAP_MAC_ADMIN,
+	"MAC_OVERRIDE":     unix.CAP_MAC_OVERRIDE,
+	"MKNOD":            unix.CAP_MKNOD,
+	"NET_ADMIN":        unix.CAP_NET_ADMIN,
+	"NET_BIND_SERVICE": unix.CAP_NET_BIND_SERVICE,
+	"NET_BROADCAST":    unix.CAP_NET_BROADCAST,
+	"NET_RAW":          unix.CAP_NET_RAW,
+	"SETFCAP":          unix.CAP_SETFCAP,
+	"SETGID":           unix.CAP_SETGID,
+	"SETPCAP":          unix.CAP_SETPCAP,
+	"SETUID":           unix.CAP_SETUID,
+	"SYSLOG":           unix.CAP_SYSLOG,
+	"SYS_ADMIN":        unix.CAP_SYS_ADMIN,
+	"SYS_BOOT":         unix.CAP_SYS_BOOT,
+	"SYS_CHROOT":       unix.CAP_SYS_CHROOT,
+	"SYS_MODULE":       unix.CAP_SYS_MODULE,
+	"SYS_NICE":         unix.CAP_SYS_NICE,
+	"SYS_PACCT":        unix.CAP_SYS_PACCT,
+	"SYS_PTRACE":       unix.CAP_SYS_PTRACE,
+	"SYS_RAWIO":        unix.CAP_SYS_RAWIO,
+	"SYS_RESOURCE":     unix.CAP_SYS_RESOURCE,
+	"SYS_TIME":         unix.CAP_SYS_TIME,
+	"SYS_TTY_CONFIG":   unix.CAP_SYS_TTY_CONFIG,
+	"WAKE_ALARM":       unix.CAP_WAKE_ALARM,
+}
+
+// parseCapabilities parses a space separated list of capability
+// names, with or without the CAP_ prefix, as used by systemd's
+// AmbientCapabilities= setting.
+func parseCapabilities(in string) ([]uintptr, error) {
+	var out []uintptr
+	for _, name := range strings.Fields(in) {
+		capability, ok := capabilities[strings.TrimPrefix(
+			strings.ToUpper(name), "CAP_")]
+		if !ok {
+			return nil, errors.New("unknown capability " + name)
+		}
+		out = append(out, capability)
+	}
+	return out, nil
+}
+
+func equalCapabilities(a, b []uintptr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// capabilityNames returns the names of caps, in the order given.
+func capabilityNames(caps []uintptr) []string {
+	var out []string
+	for _, capability := range caps {
+		for name, value := range capabilities {
+			if value == capability {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	return out
+}
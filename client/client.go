@@ -0,0 +1,287 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+
+// Package client controls the components ephemerad manages through
+// its ephemerad-v1 RPCs on the VCI bus, so that other daemons needn't
+// build the RPCs' RFC 7951 input themselves.
+package client
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"github.com/danos/vci"
+)
+
+const module = "ephemerad-v1"
+
+// Client calls ephemerad's RPCs.
+type Client struct {
+	bus bus
+}
+
+// bus is the connection a Client makes its calls over.
+type bus interface {
+	call(module, rpc string, in, out interface{}) error
+	Close() error
+}
+
+// vciBus is a connection to the VCI bus.
+type vciBus struct {
+	*vci.Client
+}
+
+func (b vciBus) call(module, rpc string, in, out interface{}) error {
+	return b.Call(module, rpc, in).StoreOutputInto(out)
+}
+
+// dial connects to the bus. It is a variable so that tests can
+// connect to a fake one.
+var dial = func() (bus, error) {
+	c, err := vci.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return vciBus{c}, nil
+}
+
+// Dial connects to the bus.
+func Dial() (*Client, error) {
+	b, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{bus: b}, nil
+}
+
+// New returns a Client making its calls over c, such as the client of
+// the caller's own VCI component.
+func New(c *vci.Client) *Client {
+	return &Client{bus: vciBus{c}}
+}
+
+// Close closes the connection to the bus.
+func (c *Client) Close() error {
+	return c.bus.Close()
+}
+
+// call calls rpc with in, storing its output in out. If ctx is done
+// first it returns ctx's error, leaving the call to finish unheeded.
+func (c *Client) call(
+	ctx context.Context,
+	rpc string,
+	in, out interface{},
+) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.bus.call(module, rpc, in, out)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type parameter struct {
+	Name  string `rfc7951:"name"`
+	Value string `rfc7951:"value"`
+}
+
+func parameterList(params map[string]string) []parameter {
+	out := make([]parameter, 0, len(params))
+	for name, value := range params {
+		out = append(out, parameter{Name: name, Value: value})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// ActivateOptions qualify an activation.
+type ActivateOptions struct {
+	// Parameters are given to the Start script, or to the new
+	// session's Start for components with sessions.
+	Parameters map[string]string
+	// Lease is how long a new session lasts unless renewed, instead
+	// of the component's SessionLease.
+	Lease time.Duration
+	// WaitForInstall waits, up to ephemerad's install timeout, for
+	// the component to be installed if it isn't already.
+	WaitForInstall bool
+}
+
+type activateInput struct {
+	Component      string      `rfc7951:"ephemerad-v1:component"`
+	Parameters     []parameter `rfc7951:"ephemerad-v1:parameter,omitempty"`
+	Lease          uint32      `rfc7951:"ephemerad-v1:lease,omitempty"`
+	WaitForInstall bool        `rfc7951:"ephemerad-v1:wait-for-install,omitempty"`
+}
+
+type sessionOutput struct {
+	Session string `rfc7951:"ephemerad-v1:session"`
+	Expires string `rfc7951:"ephemerad-v1:expires"`
+}
+
+// Session is a session started by activating a component with
+// sessions.
+type Session struct {
+	ID      string
+	Expires time.Time
+}
+
+// Activate activates the named component. For components with
+// sessions it returns the session it started, and otherwise nil.
+func (c *Client) Activate(
+	ctx context.Context,
+	component string,
+	opts *ActivateOptions,
+) (*Session, error) {
+	in := &activateInput{Component: component}
+	if opts != nil {
+		in.Parameters = parameterList(opts.Parameters)
+		in.Lease = uint32(opts.Lease / time.Second)
+		in.WaitForInstall = opts.WaitForInstall
+	}
+	var out sessionOutput
+	err := c.call(ctx, "activate", in, &out)
+	if err != nil {
+		return nil, err
+	}
+	if out.Session == "" {
+		return nil, nil
+	}
+	expires, _ := time.Parse(time.RFC3339, out.Expires)
+	return &Session{ID: out.Session, Expires: expires}, nil
+}
+
+// DeactivateOptions qualify a deactivation.
+type DeactivateOptions struct {
+	// Parameters are given to the Stop script.
+	Parameters map[string]string
+	// Session tears down only this session, leaving the component
+	// and its other sessions running.
+	Session string
+}
+
+type deactivateInput struct {
+	Component  string      `rfc7951:"ephemerad-v1:component"`
+	Parameters []parameter `rfc7951:"ephemerad-v1:parameter,omitempty"`
+	Session    string      `rfc7951:"ephemerad-v1:session,omitempty"`
+}
+
+// Deactivate deactivates the named component.
+func (c *Client) Deactivate(
+	ctx context.Context,
+	component string,
+	opts *DeactivateOptions,
+) error {
+	in := &deactivateInput{Component: component}
+	if opts != nil {
+		in.Parameters = parameterList(opts.Parameters)
+		in.Session = opts.Session
+	}
+	return c.call(ctx, "deactivate", in, rfc7951.TreeNew())
+}
+
+// Component describes a component ephemerad manages.
+type Component struct {
+	Name         string   `rfc7951:"name"`
+	Type         string   `rfc7951:"type"`
+	Owner        string   `rfc7951:"owner"`
+	Description  string   `rfc7951:"description"`
+	DocURL       string   `rfc7951:"doc-url"`
+	Enabled      bool     `rfc7951:"enabled"`
+	Running      bool     `rfc7951:"running"`
+	OnDemand     bool     `rfc7951:"on-demand"`
+	Bus          string   `rfc7951:"bus"`
+	CircuitState string   `rfc7951:"circuit-state"`
+	Reason       string   `rfc7951:"reason"`
+	LastResult   string   `rfc7951:"last-result"`
+	Models       []string `rfc7951:"model"`
+}
+
+// ListComponents returns the components ephemerad manages, or only
+// those belonging to owner if it is set.
+func (c *Client) ListComponents(
+	ctx context.Context,
+	owner string,
+) ([]Component, error) {
+	in := rfc7951.TreeNew()
+	if owner != "" {
+		in = in.Assoc("/ephemerad-v1:owner", owner)
+	}
+	var out struct {
+		Components []Component `rfc7951:"ephemerad-v1:component"`
+	}
+	err := c.call(ctx, "list-components", in, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Components, nil
+}
+
+type componentStatus struct {
+	Name            string `rfc7951:"name"`
+	Running         bool   `rfc7951:"running"`
+	LastActivated   string `rfc7951:"last-activated"`
+	LastDeactivated string `rfc7951:"last-deactivated"`
+	LastUsed        string `rfc7951:"last-used"`
+	StartError      string `rfc7951:"start-error"`
+	StopError       string `rfc7951:"stop-error"`
+}
+
+// Status is when a component was last activated and deactivated, and
+// how its Start and Stop scripts fared. Times it hasn't reached are
+// zero.
+type Status struct {
+	Name            string
+	Running         bool
+	LastActivated   time.Time
+	LastDeactivated time.Time
+	LastUsed        time.Time
+	StartError      string
+	StopError       string
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// Status returns the status of each component, or only of the named
+// component if component is set.
+func (c *Client) Status(
+	ctx context.Context,
+	component string,
+) ([]Status, error) {
+	in := rfc7951.TreeNew()
+	if component != "" {
+		in = in.Assoc("/ephemerad-v1:component", component)
+	}
+	var out struct {
+		Components []componentStatus `rfc7951:"ephemerad-v1:component"`
+	}
+	err := c.call(ctx, "status", in, &out)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(out.Components))
+	for _, s := range out.Components {
+		statuses = append(statuses, Status{
+			Name:            s.Name,
+			Running:         s.Running,
+			LastActivated:   parseTime(s.LastActivated),
+			LastDeactivated: parseTime(s.LastDeactivated),
+			LastUsed:        parseTime(s.LastUsed),
+			StartError:      s.StartError,
+			StopError:       s.StopError,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeBus answers calls with handle, recording the RPCs called.
+type fakeBus struct {
+	handle func(rpc string, in, out interface{}) error
+	calls  []string
+	closed bool
+}
+
+func (b *fakeBus) call(module, rpc string, in, out interface{}) error {
+	b.calls = append(b.calls, module+":"+rpc)
+	return b.handle(rpc, in, out)
+}
+
+func (b *fakeBus) Close() error {
+	b.closed = true
+	return nil
+}
+
+func withFakeBus(t *testing.T, b *fakeBus) *Client {
+	prev := dial
+	dial = func() (bus, error) { return b, nil }
+	defer func() { dial = prev }()
+	c, err := Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDial(t *testing.T) {
+	prev := dial
+	defer func() { dial = prev }()
+	dial = func() (bus, error) { return nil, errors.New("no bus") }
+	_, err := Dial()
+	if err == nil || err.Error() != "no bus" {
+		t.Fatalf("expected the dial error, got %v", err)
+	}
+
+	b := &fakeBus{}
+	c := withFakeBus(t, b)
+	err = c.Close()
+	if err != nil || !b.closed {
+		t.Fatal("Close didn't close the bus")
+	}
+}
+
+func TestActivate(t *testing.T) {
+	expires := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	b := &fakeBus{handle: func(rpc string, in, out interface{}) error {
+		got := in.(*activateInput)
+		want := &activateInput{
+			Component:      "net.vyatta.vci.toaster",
+			Parameters:     []parameter{{"a", "1"}, {"b", "2"}},
+			Lease:          60,
+			WaitForInstall: true,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected input %+v, got %+v", want, got)
+		}
+		*out.(*sessionOutput) = sessionOutput{
+			Session: "abc",
+			Expires: expires.Format(time.RFC3339),
+		}
+		return nil
+	}}
+	c := withFakeBus(t, b)
+	s, err := c.Activate(context.Background(), "net.vyatta.vci.toaster",
+		&ActivateOptions{
+			Parameters:     map[string]string{"b": "2", "a": "1"},
+			Lease:          time.Minute,
+			WaitForInstall: true,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || s.ID != "abc" || !s.Expires.Equal(expires) {
+		t.Fatalf("unexpected session %+v", s)
+	}
+	if !reflect.DeepEqual(b.calls, []string{"ephemerad-v1:activate"}) {
+		t.Fatalf("unexpected calls %v", b.calls)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	b := &fakeBus{handle: func(rpc string, in, out interface{}) error {
+		if rpc == "deactivate" {
+			return errors.New("stop failed")
+		}
+		<-unblock
+		return nil
+	}}
+	c := withFakeBus(t, b)
+	err := c.Deactivate(context.Background(), "net.vyatta.vci.toaster",
+		nil)
+	if err == nil || err.Error() != "stop failed" {
+		t.Fatalf("expected the RPC's error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.ListComponents(ctx, "")
+	if err != context.Canceled {
+		t.Fatalf("expected the context's error, got %v", err)
+	}
+}
@@ -5,14 +5,23 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/coreos/go-systemd/daemon"
 	rfc7951 "github.com/danos/encoding/rfc7951/data"
 	"github.com/danos/vci"
+	"github.com/godbus/dbus"
 )
 
-var component string
+var (
+	component     string
+	logLines      uint
+	timeout       time.Duration
+	retryInterval time.Duration
+)
 
 func init() {
 	flag.StringVar(
@@ -21,14 +30,82 @@ func init() {
 		"",
 		"component name",
 	)
+	flag.UintVar(
+		&logLines,
+		"log-lines",
+		20,
+		"lines of the component's log to show if activation fails",
+	)
+	flag.DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"how long to keep retrying if ephemerad isn't on the bus yet",
+	)
+	flag.DurationVar(
+		&retryInterval,
+		"retry-interval",
+		time.Second,
+		"how long to wait between attempts",
+	)
 }
 
-func main() {
-	flag.Parse()
+// showLogs prints the tail of the component's captured script output
+// so that the operator can see why activation failed.
+func showLogs(client *vci.Client) {
+	if logLines == 0 {
+		return
+	}
+	var out struct {
+		Lines []string `rfc7951:"ephemerad-v1:line"`
+	}
+	err := client.Call("ephemerad-v1", "get-logs",
+		rfc7951.TreeNew().
+			Assoc("/ephemerad-v1:component", component).
+			Assoc("/ephemerad-v1:lines", uint32(logLines))).
+		StoreOutputInto(&out)
+	if err != nil || len(out.Lines) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Last %d lines of %s's log:\n",
+		len(out.Lines), component)
+	for _, line := range out.Lines {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// notReadyError wraps a failure to reach ephemerad, either to connect
+// to the bus or because ephemerad isn't on it yet. Only these are
+// worth retrying; a reply from ephemerad refusing the activation won't
+// change by asking again.
+type notReadyError struct {
+	err error
+}
 
+func (e *notReadyError) Error() string {
+	return e.err.Error()
+}
+
+// notOnBus reports whether err is the bus saying that no one owns
+// ephemerad's name.
+func notOnBus(err error) bool {
+	var name string
+	switch e := err.(type) {
+	case dbus.Error:
+		name = e.Name
+	case *dbus.Error:
+		name = e.Name
+	}
+	return name == "org.freedesktop.DBus.Error.ServiceUnknown" ||
+		name == "org.freedesktop.DBus.Error.NameHasNoOwner"
+}
+
+// activate asks ephemerad to activate the component. If ephemerad
+// refuses, the tail of the component's log is shown.
+func activate() error {
 	client, err := vci.Dial()
 	if err != nil {
-		log.Fatal(err)
+		return &notReadyError{err}
 	}
 	defer client.Close()
 
@@ -37,13 +114,39 @@ func main() {
 		rfc7951.TreeNew().
 			Assoc("/ephemerad-v1:component", component)).
 		StoreOutputInto(out)
+	if notOnBus(err) {
+		return &notReadyError{err}
+	}
 	if err != nil {
-		log.Fatal(err)
+		showLogs(client)
+		return err
 	}
 
 	log.Println("activated", component, out)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	// During boot ephemerad may not be on the bus yet, so keep trying
+	// until the timeout passes. Any other failure is final.
+	deadline := time.Now().Add(timeout)
+	for {
+		err := activate()
+		if err == nil {
+			break
+		}
+		_, retry := err.(*notReadyError)
+		if !retry || !time.Now().Add(retryInterval).Before(deadline) {
+			log.Fatal(err)
+		}
+		log.Printf("activating %s: %s, retrying in %s\n",
+			component, err, retryInterval)
+		time.Sleep(retryInterval)
+	}
 
-	_, err = daemon.SdNotify(false, "READY=1")
+	_, err := daemon.SdNotify(false, "READY=1")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,49 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/danos/ephemera"
+)
+
+var printSchema bool
+
+func init() {
+	flag.BoolVar(
+		&printSchema,
+		"schema",
+		false,
+		"print the JSON Schema for instance files and exit",
+	)
+}
+
+func main() {
+	flag.Parse()
+
+	if printSchema {
+		doc, err := ephemera.InstanceSchema.JSONSchema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(doc))
+		return
+	}
+
+	status := 0
+	for _, file := range flag.Args() {
+		_, err := ephemera.New(ephemera.From(file), ephemera.Strict())
+		if err != nil {
+			fmt.Printf("%s: %s\n", file, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: ok\n", file)
+	}
+	os.Exit(status)
+}
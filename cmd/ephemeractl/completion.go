@@ -0,0 +1,72 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The completion scripts ask ephemeractl itself, and so ephemerad's
+// list-components RPC, for component names as they are needed.
+const bashCompletion = `_ephemeractl() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%[1]s" -- "$cur"))
+		return
+	fi
+	case ${COMP_WORDS[1]} in
+	%[2]s)
+		COMPREPLY=($(compgen -W "$(ephemeractl list 2>/dev/null)" -- "$cur"));;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh" -- "$cur"));;
+	esac
+}
+complete -F _ephemeractl ephemeractl
+`
+
+const zshCompletion = `#compdef ephemeractl
+_ephemeractl() {
+	if (( CURRENT == 2 )); then
+		compadd %[1]s
+		return
+	fi
+	case $words[2] in
+	%[2]s)
+		compadd -- ${(f)"$(ephemeractl list 2>/dev/null)"};;
+	completion)
+		compadd bash zsh;;
+	esac
+}
+compdef _ephemeractl ephemeractl
+`
+
+func completion(args []string) error {
+	if len(args) != 1 {
+		return usageError("completion")
+	}
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	default:
+		return errors.New("unsupported shell " + args[0])
+	}
+	names, compCmds := joinCommands()
+	fmt.Printf(script, names, compCmds)
+	return nil
+}
+
+// componentCommands are the commands that take a component name.
+var componentCommands = []string{
+	"activate", "deactivate", "restart", "status",
+}
+
+func joinCommands() (string, string) {
+	return strings.Join(commandNames(), " "),
+		strings.Join(componentCommands, "|")
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danos/ephemera"
+)
+
+// defaultConformanceTimeout bounds each script the conformance checks
+// run whose instance doesn't set its own timeout.
+const defaultConformanceTimeout = 30 * time.Second
+
+// timeoutOption removes a leading "-timeout <duration>" from args.
+func timeoutOption(args []string) (time.Duration, []string, error) {
+	if len(args) >= 2 && args[0] == "-timeout" {
+		timeout, err := time.ParseDuration(args[1])
+		return timeout, args[2:], err
+	}
+	return defaultConformanceTimeout, args, nil
+}
+
+// conformance runs each operation declared by an instance file's
+// component, outside of ephemerad, and reports whether its scripts
+// behave as ephemerad expects.
+func conformance(args []string) error {
+	timeout, args, err := timeoutOption(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageError("conformance")
+	}
+	comp, err := ephemera.New(ephemera.From(args[0]))
+	if err != nil {
+		return err
+	}
+	ephemera.SetDefaultTimeouts(ephemera.Timeouts{
+		Start: timeout,
+		Stop:  timeout,
+		Set:   timeout,
+		Get:   timeout,
+		RPC:   timeout,
+	})
+
+	checks := comp.Conformance()
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Model\tOperation\tCheck\tResult")
+	for _, chk := range checks {
+		model := chk.Model
+		if model == "" {
+			model = "-"
+		}
+		result := "pass"
+		if !chk.Passed() {
+			result = "FAIL: " + chk.Err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", model, chk.Operation,
+			chk.Check, result)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return errors.New(strconv.Itoa(failed) + " of " +
+			strconv.Itoa(len(checks)) + " checks failed")
+	}
+	fmt.Printf("%d checks passed\n", len(checks))
+	return nil
+}
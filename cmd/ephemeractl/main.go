@@ -0,0 +1,505 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	encoding "github.com/danos/encoding/rfc7951"
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"github.com/danos/vci"
+)
+
+type command struct {
+	args string
+	help string
+	run  func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"list": {
+			args: "[-owner <owner>]",
+			help: "list the components ephemerad manages",
+			run:  list,
+		},
+		"activate": {
+			args: "<component> [<name>=<value>...]",
+			help: "activate a component, with Start parameters",
+			run:  callWithParameters("activate"),
+		},
+		"deactivate": {
+			args: "<component> [<name>=<value>...]",
+			help: "deactivate a component, with Stop parameters",
+			run:  callWithParameters("deactivate"),
+		},
+		"end-session": {
+			args: "<component> <session>",
+			help: "tear down one session of a component",
+			run:  endSession,
+		},
+		"status": {
+			args: "[-owner <owner>] [<component>]",
+			help: "show the status of all components or just one",
+			run:  status,
+		},
+		"restart": {
+			args: "<component>",
+			help: "deactivate and then activate a component",
+			run:  restart,
+		},
+		"rescan": {
+			help: "reread the instance directories",
+			run:  rescan,
+		},
+		"refresh": {
+			args: "[-populate] <component>",
+			help: "drop the results a component has cached",
+			run:  refresh,
+		},
+		"log-level": {
+			args: "error|info|debug|default [<component>]",
+			help: "change ephemerad's log level, or a component's",
+			run:  logLevel,
+		},
+		"call": {
+			args: "<rpc> [<input>]",
+			help: "call an ephemerad-v1 RPC with JSON input",
+			run:  rawCall,
+		},
+		"conformance": {
+			args: "[-timeout <duration>] <instance-file>",
+			help: "check that a component's scripts behave as expected",
+			run:  conformance,
+		},
+		"completion": {
+			args: "bash|zsh",
+			help: "print a shell completion script",
+			run:  completion,
+		},
+	}
+	flag.Usage = usage
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, name := range commandNames() {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-24s %s\n",
+			name+" "+commands[name].args, commands[name].help)
+	}
+}
+
+func usageError(name string) error {
+	return errors.New("usage: " + os.Args[0] + " " + name + " " +
+		commands[name].args)
+}
+
+type componentStatus struct {
+	Name         string `rfc7951:"name"`
+	Type         string `rfc7951:"type"`
+	Owner        string `rfc7951:"owner"`
+	Description  string `rfc7951:"description"`
+	DocURL       string `rfc7951:"doc-url"`
+	Enabled      bool   `rfc7951:"enabled"`
+	Running      bool   `rfc7951:"running"`
+	CircuitState string `rfc7951:"circuit-state"`
+	Reason       string `rfc7951:"reason"`
+	LastResult   string `rfc7951:"last-result"`
+	InFlight     uint32 `rfc7951:"in-flight"`
+	Queued       uint32 `rfc7951:"queued"`
+	Overloaded   bool   `rfc7951:"overloaded"`
+	PeakRSS      uint64 `rfc7951:"peak-rss"`
+	TotalRSS     uint64 `rfc7951:"total-rss"`
+	UserTime     uint64 `rfc7951:"user-time"`
+	SystemTime   uint64 `rfc7951:"system-time"`
+
+	Models   []string `rfc7951:"model"`
+	Sessions []struct {
+		ID      string `rfc7951:"id"`
+		Expires string `rfc7951:"expires"`
+	} `rfc7951:"session"`
+}
+
+// listComponents returns the status of the components ephemerad
+// manages, or only of those belonging to owner if it is set.
+func listComponents(owner string) ([]componentStatus, error) {
+	client, err := vci.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	in := rfc7951.TreeNew()
+	if owner != "" {
+		in = in.Assoc("/ephemerad-v1:owner", owner)
+	}
+	var out struct {
+		Components []componentStatus `rfc7951:"ephemerad-v1:component"`
+	}
+	err = client.Call("ephemerad-v1", "list-components", in).
+		StoreOutputInto(&out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Components, nil
+}
+
+// ownerOption removes a leading "-owner <owner>" from args.
+func ownerOption(args []string) (string, []string) {
+	if len(args) >= 2 && args[0] == "-owner" {
+		return args[1], args[2:]
+	}
+	return "", args
+}
+
+func list(args []string) error {
+	owner, args := ownerOption(args)
+	if len(args) != 0 {
+		return usageError("list")
+	}
+	comps, err := listComponents(owner)
+	if err != nil {
+		return err
+	}
+	for _, comp := range comps {
+		fmt.Println(comp.Name)
+	}
+	return nil
+}
+
+func status(args []string) error {
+	owner, args := ownerOption(args)
+	if len(args) > 1 {
+		return usageError("status")
+	}
+	comps, err := listComponents(owner)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "Component\tType\tOwner\tEnabled\tRunning"+
+			"\tCircuit\tIn flight\tQueued")
+		for _, comp := range comps {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%s\t%d\t%d\n",
+				comp.Name, comp.Type, comp.Owner, comp.Enabled,
+				comp.Running, comp.CircuitState, comp.InFlight,
+				comp.Queued)
+		}
+		return w.Flush()
+	}
+	for _, comp := range comps {
+		if comp.Name != args[0] {
+			continue
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "Component:\t%s\n", comp.Name)
+		fmt.Fprintf(w, "Type:\t%s\n", comp.Type)
+		fmt.Fprintf(w, "Owner:\t%s\n", comp.Owner)
+		if comp.Description != "" {
+			fmt.Fprintf(w, "Description:\t%s\n", comp.Description)
+		}
+		if comp.DocURL != "" {
+			fmt.Fprintf(w, "Documentation:\t%s\n", comp.DocURL)
+		}
+		for _, model := range comp.Models {
+			fmt.Fprintf(w, "Model:\t%s\n", model)
+		}
+		fmt.Fprintf(w, "Enabled:\t%t\n", comp.Enabled)
+		fmt.Fprintf(w, "Running:\t%t\n", comp.Running)
+		fmt.Fprintf(w, "Circuit:\t%s\n", comp.CircuitState)
+		fmt.Fprintf(w, "In flight:\t%d\n", comp.InFlight)
+		fmt.Fprintf(w, "Queued:\t%d\n", comp.Queued)
+		fmt.Fprintf(w, "Overloaded:\t%t\n", comp.Overloaded)
+		fmt.Fprintf(w, "Peak RSS:\t%d bytes\n", comp.PeakRSS)
+		fmt.Fprintf(w, "Total RSS:\t%d bytes\n", comp.TotalRSS)
+		fmt.Fprintf(w, "CPU time:\t%dms user, %dms system\n",
+			comp.UserTime, comp.SystemTime)
+		for _, s := range comp.Sessions {
+			fmt.Fprintf(w, "Session:\t%s, expires %s\n", s.ID,
+				s.Expires)
+		}
+		if comp.Reason != "" {
+			fmt.Fprintf(w, "Reason:\t%s\n", comp.Reason)
+		}
+		if comp.LastResult != "" {
+			fmt.Fprintf(w, "Last result:\t%s\n", comp.LastResult)
+		}
+		return w.Flush()
+	}
+	return errors.New("no component by the name " + args[0] + " found")
+}
+
+func call(rpc, component string) error {
+	client, err := vci.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call("ephemerad-v1", rpc,
+		rfc7951.TreeNew().
+			Assoc("/ephemerad-v1:component", component)).
+		StoreOutputInto(rfc7951.TreeNew())
+}
+
+type scriptParameter struct {
+	Name  string `rfc7951:"name"`
+	Value string `rfc7951:"value"`
+}
+
+type componentInput struct {
+	Component  string            `rfc7951:"ephemerad-v1:component"`
+	Parameters []scriptParameter `rfc7951:"ephemerad-v1:parameter,omitempty"`
+	Session    string            `rfc7951:"ephemerad-v1:session,omitempty"`
+}
+
+type sessionOutput struct {
+	Session string `rfc7951:"ephemerad-v1:session"`
+	Expires string `rfc7951:"ephemerad-v1:expires"`
+}
+
+// callWithParameters calls rpc for the component named by the first
+// argument, passing the remaining name=value arguments as the
+// script's parameters.
+func callWithParameters(rpc string) func([]string) error {
+	return func(args []string) error {
+		if len(args) < 1 {
+			return usageError(rpc)
+		}
+		in := &componentInput{Component: args[0]}
+		for _, arg := range args[1:] {
+			eq := strings.Index(arg, "=")
+			if eq < 1 {
+				return usageError(rpc)
+			}
+			in.Parameters = append(in.Parameters, scriptParameter{
+				Name:  arg[:eq],
+				Value: arg[eq+1:],
+			})
+		}
+		client, err := vci.Dial()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		var out sessionOutput
+		err = client.Call("ephemerad-v1", rpc, in).StoreOutputInto(&out)
+		if err != nil {
+			return err
+		}
+		if out.Session != "" {
+			fmt.Printf("Session %s, expires %s\n", out.Session,
+				out.Expires)
+		}
+		return nil
+	}
+}
+
+func endSession(args []string) error {
+	if len(args) != 2 {
+		return usageError("end-session")
+	}
+	client, err := vci.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call("ephemerad-v1", "deactivate", &componentInput{
+		Component: args[0],
+		Session:   args[1],
+	}).StoreOutputInto(rfc7951.TreeNew())
+}
+
+func restart(args []string) error {
+	if len(args) != 1 {
+		return usageError("restart")
+	}
+	err := call("deactivate", args[0])
+	if err != nil {
+		return err
+	}
+	return call("activate", args[0])
+}
+
+type rescanOutput struct {
+	Loaded   []string `rfc7951:"ephemerad-v1:loaded"`
+	Rejected []struct {
+		File   string `rfc7951:"file"`
+		Reason string `rfc7951:"reason"`
+	} `rfc7951:"ephemerad-v1:rejected"`
+	Duplicates []struct {
+		Component string `rfc7951:"component"`
+		File      string `rfc7951:"file"`
+		UsedFile  string `rfc7951:"used-file"`
+	} `rfc7951:"ephemerad-v1:duplicate"`
+}
+
+func rescan(args []string) error {
+	if len(args) != 0 {
+		return usageError("rescan")
+	}
+	client, err := vci.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	var out rescanOutput
+	err = client.Call("ephemerad-v1", "rescan", rfc7951.TreeNew()).
+		StoreOutputInto(&out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d components\n", len(out.Loaded))
+	for _, rej := range out.Rejected {
+		fmt.Printf("Rejected %s: %s\n", rej.File, rej.Reason)
+	}
+	for _, dup := range out.Duplicates {
+		fmt.Printf("Ignored %s, %s is loaded from %s\n", dup.File,
+			dup.Component, dup.UsedFile)
+	}
+	return nil
+}
+
+type logLevelInput struct {
+	Level     string `rfc7951:"ephemerad-v1:level"`
+	Component string `rfc7951:"ephemerad-v1:component,omitempty"`
+}
+
+func logLevel(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("log-level")
+	}
+	in := &logLevelInput{Level: args[0]}
+	if len(args) == 2 {
+		in.Component = args[1]
+	}
+	client, err := vci.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call("ephemerad-v1", "set-log-level", in).
+		StoreOutputInto(rfc7951.TreeNew())
+}
+
+type refreshStateInput struct {
+	Component string `rfc7951:"ephemerad-v1:component"`
+	Populate  bool   `rfc7951:"ephemerad-v1:populate"`
+}
+
+func refresh(args []string) error {
+	in := &refreshStateInput{}
+	if len(args) >= 1 && args[0] == "-populate" {
+		in.Populate = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return usageError("refresh")
+	}
+	in.Component = args[0]
+	client, err := vci.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call("ephemerad-v1", "refresh-state", in).
+		StoreOutputInto(rfc7951.TreeNew())
+}
+
+// rawCall calls an ephemerad-v1 RPC with the RFC 7951 JSON input given
+// as an argument, or otherwise read from stdin, and prints its output
+// as JSON, for tooling written in other languages.
+func rawCall(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("call")
+	}
+	var input []byte
+	if len(args) == 2 {
+		input = []byte(args[1])
+	} else {
+		var err error
+		input, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+	}
+	in, err := decodeInput(input)
+	if err != nil {
+		return err
+	}
+	client, err := vci.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	out := rfc7951.TreeNew()
+	err = client.Call("ephemerad-v1", args[0], in).StoreOutputInto(out)
+	if err != nil {
+		return err
+	}
+	buf, err := encodeOutput(out)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
+
+// decodeInput decodes the RFC 7951 JSON input of a call, where empty
+// input is an empty tree.
+func decodeInput(input []byte) (*rfc7951.Tree, error) {
+	in := rfc7951.TreeNew()
+	if len(bytes.TrimSpace(input)) == 0 {
+		return in, nil
+	}
+	err := encoding.Unmarshal(input, in)
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// encodeOutput encodes the output of a call as RFC 7951 JSON.
+func encodeOutput(out *rfc7951.Tree) ([]byte, error) {
+	return encoding.Marshal(out)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
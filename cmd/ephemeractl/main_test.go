@@ -0,0 +1,54 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCallRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		`{}`,
+		`{"ephemerad-v1:component":"net.vyatta.vci.toaster",` +
+			`"ephemerad-v1:parameter":[{"name":"a","value":"1"}],` +
+			`"ephemerad-v1:wait-for-install":true}`,
+	} {
+		in, err := decodeInput([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := encodeOutput(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, got interface{}
+		err = json.Unmarshal([]byte(input), &want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.Unmarshal(out, &got)
+		if err != nil {
+			t.Fatalf("output %s is not JSON: %s", out, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: round trip gave %s", input, out)
+		}
+	}
+
+	in, err := decodeInput([]byte(" \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encodeOutput(in)
+	if err != nil || string(out) != "{}" {
+		t.Fatalf("empty input should be an empty tree, got %s, %v",
+			out, err)
+	}
+	_, err = decodeInput([]byte("{"))
+	if err == nil {
+		t.Fatal("expected malformed input to be refused")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/danos/ephemera"
+	"github.com/danos/vci"
+)
+
+// Components are registered on the system bus unless their Bus key
+// names another given with -bus, such as a bus in a network namespace
+// or a private one used for testing. Components on other buses are
+// registered by relays, one for each, so components on different
+// buses can be registered at the same time.
+
+const (
+	systemBus      = "system"
+	busFlagExample = "name=unix:path=/run/test/bus"
+)
+
+// busList is the -bus flag. It may be given more than once, each time
+// naming a bus and its D-Bus address.
+type busList struct {
+	addrs map[string]string
+}
+
+var buses = &busList{addrs: make(map[string]string)}
+
+func init() {
+	flag.Var(buses, "bus", "additional bus components may name with "+
+		"their Bus key, as "+busFlagExample+"; may be repeated")
+}
+
+func (l *busList) String() string {
+	if l == nil {
+		return ""
+	}
+	var out []string
+	for name, addr := range l.addrs {
+		out = append(out, name+"="+addr)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func (l *busList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return errors.New("bus must be given as " + busFlagExample)
+	}
+	name, addr := s[:i], s[i+1:]
+	if name == systemBus {
+		return errors.New("the " + systemBus + " bus can't be redefined")
+	}
+	if _, ok := l.addrs[name]; ok {
+		return errors.New("bus " + name + " given more than once")
+	}
+	l.addrs[name] = addr
+	return nil
+}
+
+// address returns the D-Bus address of the bus named by name, which
+// is empty for the system bus.
+func (l *busList) address(name string) (string, bool) {
+	if name == "" || name == systemBus {
+		return "", true
+	}
+	addr, ok := l.addrs[name]
+	return addr, ok
+}
+
+// checkBus returns an error if the component's Bus key names a bus
+// ephemerad wasn't given.
+func checkBus(meta *ephemera.Component) error {
+	name := meta.Bus()
+	if _, ok := buses.address(name); !ok {
+		return errors.New("Bus " + name + " is not known to ephemerad")
+	}
+	return nil
+}
+
+// newVCIComponent creates the VCI component name, which is registered
+// on the bus at the D-Bus address addr by a relay when it is run, or
+// on the system bus if addr is empty.
+var newVCIComponent = func(name, addr string) vci.Component {
+	if addr == "" {
+		return vci.NewComponent(name)
+	}
+	return newRelayComponent(name, addr)
+}
+
+// busComponent creates the VCI component name on the bus named by
+// bus, which must be known to ephemerad.
+func busComponent(name, bus string) vci.Component {
+	addr, _ := buses.address(bus)
+	return newVCIComponent(name, addr)
+}
+
+// busOf returns the name of the bus comp is registered on.
+func busOf(comp *component) string {
+	if comp.meta.Bus() == "" {
+		return systemBus
+	}
+	return comp.meta.Bus()
+}
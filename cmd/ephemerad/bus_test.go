@@ -0,0 +1,224 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/danos/ephemera"
+	"github.com/danos/mgmterror"
+	"github.com/danos/vci"
+)
+
+func TestBusComponent(t *testing.T) {
+	dir := t.TempDir()
+	prevBuses, prevNew := buses, newVCIComponent
+	defer func() { buses, newVCIComponent = prevBuses, prevNew }()
+	buses = &busList{addrs: make(map[string]string)}
+	err := buses.Set("test=unix:path=/run/test/bus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialled := make(map[string]string)
+	newVCIComponent = func(name, addr string) vci.Component {
+		dialled[name] = addr
+		return prevNew(name, addr)
+	}
+
+	tests := []struct {
+		name, bus, addr string
+	}{
+		{"net.vyatta.eng.vci.ephemeral.system", "", ""},
+		{"net.vyatta.eng.vci.ephemeral.named", "system", ""},
+		{"net.vyatta.eng.vci.ephemeral.test", "test",
+			"unix:path=/run/test/bus"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(dir, test.name+".instance")
+		inst := "[Component]\nName=" + test.name + "\n"
+		if test.bus != "" {
+			inst += "Bus=" + test.bus + "\n"
+		}
+		err := ioutil.WriteFile(file, []byte(inst), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ephemera.New(ephemera.From(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkBus(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		createVCIComponent(meta)
+		addr, ok := dialled[test.name]
+		if !ok || addr != test.addr {
+			t.Errorf("%s: expected bus address %q, got %q",
+				test.name, test.addr, addr)
+		}
+	}
+}
+
+type testData []byte
+
+type testConfig struct {
+	data testData
+}
+
+func (c *testConfig) Get() testData {
+	return c.data
+}
+
+func (c *testConfig) Set(in testData) error {
+	c.data = in
+	return nil
+}
+
+func (c *testConfig) Check(in testData) error {
+	if string(in) == "{}" {
+		return nil
+	}
+	err := mgmterror.NewInvalidValueApplicationError()
+	err.AppTag = "bad-config"
+	err.Message = "bad config"
+	return err
+}
+
+// fakeVCIComponent records the models the relay registers, standing in
+// for its VCI component until it is stopped.
+type fakeVCIComponent struct {
+	*relayComponent
+	once    sync.Once
+	stopped chan struct{}
+}
+
+func (c *fakeVCIComponent) Run() error {
+	return nil
+}
+
+func (c *fakeVCIComponent) Wait() error {
+	<-c.stopped
+	return nil
+}
+
+func (c *fakeVCIComponent) Stop() error {
+	c.once.Do(func() { close(c.stopped) })
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	prevNew := newVCIComponent
+	defer func() { newVCIComponent = prevNew }()
+	registered := make(chan *fakeVCIComponent, 1)
+	newVCIComponent = func(name, addr string) vci.Component {
+		c := &fakeVCIComponent{
+			relayComponent: newRelayComponent(name, addr),
+			stopped:        make(chan struct{}),
+		}
+		registered <- c
+		return c
+	}
+
+	conf := &testConfig{}
+	c := newRelayComponent("net.vyatta.eng.vci.ephemeral.test",
+		"unix:path=/run/test/bus")
+	c.Model("net.vyatta.eng.vci.ephemeral.test.v1").
+		Config(conf).
+		RPC("test-v1", map[string]interface{}{
+			"echo": func(meta, in testData) (testData, error) {
+				return append(meta, in...), nil
+			},
+			"fail": func(in testData) (testData, error) {
+				return nil, errors.New("failed")
+			},
+		})
+
+	toRelay, fromParent := io.Pipe()
+	toParent, fromRelay := io.Pipe()
+	exited := make(chan error, 1)
+	go func() {
+		err := runRelay(toRelay, fromRelay)
+		fromRelay.Close()
+		exited <- err
+	}()
+	err := c.attach(toParent, fromParent, func() error {
+		return <-exited
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayed := <-registered
+	if len(relayed.models) != 1 {
+		t.Fatalf("expected 1 model registered, got %d",
+			len(relayed.models))
+	}
+	m := relayed.models[0]
+	if m.state != nil {
+		t.Error("expected no state to be registered")
+	}
+
+	rc := m.config.(*relayConfig)
+	err = rc.Set(relayData(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(rc.Get()); got != `{"a":1}` {
+		t.Errorf("expected the config set, got %s", got)
+	}
+	err = rc.Check(relayData(`{"a":2}`))
+	merr, ok := err.(*mgmterror.MgmtError)
+	if !ok || merr.AppTag != "bad-config" || merr.Message != "bad config" {
+		t.Errorf("expected the bad-config error, got %#v", err)
+	}
+
+	type rpcFunc = func(meta, in relayData) (relayData, error)
+	echo := m.rpcs["test-v1"]["echo"].(rpcFunc)
+	out, err := echo(relayData("meta"), relayData("in"))
+	if err != nil || string(out) != "metain" {
+		t.Errorf("expected metain, got %q, %v", out, err)
+	}
+	fail := m.rpcs["test-v1"]["fail"].(rpcFunc)
+	_, err = fail(nil, relayData("{}"))
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("expected the RPC's error, got %v", err)
+	}
+
+	err = c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = echo(relayData("meta"), relayData("in"))
+	if err == nil {
+		t.Error("expected calls after stopping to fail")
+	}
+}
+
+func TestRelayEnv(t *testing.T) {
+	env := relayEnv([]string{
+		"PATH=/bin",
+		"DBUS_SYSTEM_BUS_ADDRESS=unix:path=/run/dbus/system_bus_socket",
+	}, "unix:path=/run/test/bus")
+	expected := []string{
+		"PATH=/bin",
+		"DBUS_SYSTEM_BUS_ADDRESS=unix:path=/run/test/bus",
+	}
+	if len(env) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+	for i := range env {
+		if env[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, env)
+		}
+	}
+}
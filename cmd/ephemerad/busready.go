@@ -0,0 +1,31 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	busReadyTimeout  = 30 * time.Second
+	busReadyInterval = 500 * time.Millisecond
+)
+
+// waitForBus waits up to timeout for ephemerad to be ready to serve
+// requests over the bus.
+func waitForBus(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := health.readiness()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("bus not ready after " +
+				timeout.String() + ": " + err.Error())
+		}
+		time.Sleep(busReadyInterval)
+	}
+}
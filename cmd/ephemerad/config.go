@@ -0,0 +1,158 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/danos/ephemera"
+	"github.com/go-ini/ini"
+	"jsouthworth.net/go/etm/atom"
+)
+
+var (
+	configFile     string
+	defaultTimeout time.Duration
+)
+
+func init() {
+	flag.StringVar(
+		&configFile,
+		"config",
+		"/etc/ephemerad/ephemerad.conf",
+		"ephemerad configuration file",
+	)
+	flag.DurationVar(
+		&defaultTimeout,
+		"default-timeout",
+		0,
+		"longest any script may run when neither its instance file "+
+			"nor the configuration sets a timeout, 0 for no limit",
+	)
+}
+
+// daemonConfig holds ephemerad's own settings. They are read from an
+// INI file, where a missing file is equivalent to an empty one, and
+// may then be overridden through ephemerad's configuration model.
+type daemonConfig struct {
+	logLevel          string
+	unitWaitTimeout   time.Duration
+	installTimeout    time.Duration
+	autoActivate      bool
+	limits            ephemera.ResourceLimits
+	timeouts          ephemera.Timeouts
+	telemetryInterval time.Duration
+	hooks             hooksConfig
+	features          map[string]bool
+	eventRetention    retention
+	syncPlanRetention retention
+}
+
+type hooksConfig struct {
+	exec string
+	url  string
+}
+
+func defaultDaemonConfig() *daemonConfig {
+	return &daemonConfig{
+		logLevel:        flagLogLevel,
+		unitWaitTimeout: unitWaitTimeout,
+		installTimeout:  installTimeout,
+		autoActivate:    true,
+		features:        defaultFeatures,
+		timeouts: ephemera.Timeouts{
+			Start: defaultTimeout,
+			Stop:  defaultTimeout,
+			Set:   defaultTimeout,
+			Get:   defaultTimeout,
+			RPC:   defaultTimeout,
+		},
+		eventRetention: retention{maxEntries: defaultEventLogSize},
+		syncPlanRetention: retention{
+			maxEntries: defaultSyncHistorySize,
+		},
+	}
+}
+
+func loadDaemonConfig(file string) (*daemonConfig, error) {
+	conf := defaultDaemonConfig()
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		features, err := loadFeatures(ini.Empty().Section("Features"))
+		if err != nil {
+			return nil, err
+		}
+		conf.features = features
+		return conf, nil
+	}
+	cfg, err := ini.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	conf.logLevel = cfg.Section("Logging").Key("Level").
+		MustString(conf.logLevel)
+	err = checkLogLevel(conf.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	conf.unitWaitTimeout = cfg.Section("Activation").
+		Key("UnitWaitTimeout").MustDuration(conf.unitWaitTimeout)
+	conf.installTimeout = cfg.Section("Activation").
+		Key("InstallTimeout").MustDuration(conf.installTimeout)
+	conf.autoActivate = cfg.Section("Activation").
+		Key("AutoActivate").MustBool(conf.autoActivate)
+	conf.limits.MaxProcesses = cfg.Section("Limits").
+		Key("MaxProcesses").MustInt(0)
+	conf.limits.MaxComponentProcesses = cfg.Section("Limits").
+		Key("MaxComponentProcesses").MustInt(0)
+	timeouts := cfg.Section("Timeouts")
+	conf.timeouts = ephemera.Timeouts{
+		Start: timeouts.Key("Start").MustDuration(conf.timeouts.Start),
+		Stop:  timeouts.Key("Stop").MustDuration(conf.timeouts.Stop),
+		Set:   timeouts.Key("Set").MustDuration(conf.timeouts.Set),
+		Get:   timeouts.Key("Get").MustDuration(conf.timeouts.Get),
+		RPC:   timeouts.Key("RPC").MustDuration(conf.timeouts.RPC),
+	}
+	conf.telemetryInterval = cfg.Section("Telemetry").
+		Key("Interval").MustDuration(0)
+	conf.hooks.exec = cfg.Section("Hooks").Key("Exec").MustString("")
+	if conf.hooks.exec != "" {
+		_, err = ephemera.SplitCommand(conf.hooks.exec)
+		if err != nil {
+			return nil, errors.New("Hooks/Exec: " + err.Error())
+		}
+	}
+	conf.hooks.url = cfg.Section("Hooks").Key("URL").MustString("")
+	conf.features, err = loadFeatures(cfg.Section("Features"))
+	if err != nil {
+		return nil, err
+	}
+	conf.eventRetention = parseRetention(cfg.Section("EventHistory"),
+		conf.eventRetention)
+	conf.syncPlanRetention = parseRetention(cfg.Section("SyncHistory"),
+		conf.syncPlanRetention)
+	return conf, nil
+}
+
+var (
+	// fileSettings are the settings from the configuration file,
+	// on which those from the configuration model are layered.
+	fileSettings   = atom.New(defaultDaemonConfig())
+	daemonSettings = atom.New(defaultDaemonConfig())
+)
+
+func settings() *daemonConfig {
+	return daemonSettings.Deref().(*daemonConfig)
+}
+
+// applySettings makes conf ephemerad's current settings.
+func applySettings(conf *daemonConfig) {
+	daemonSettings.Reset(conf)
+	setLogLevel(conf.logLevel)
+	ephemera.SetResourceLimits(conf.limits)
+	ephemera.SetDefaultTimeouts(conf.timeouts)
+	applyFeatures(conf.features)
+}
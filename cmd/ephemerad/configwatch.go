@@ -0,0 +1,68 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configSettleDelay is how long the configuration file must go
+// unchanged before it is reread, so that an editor saving it in
+// several steps causes a single reload.
+const configSettleDelay = 500 * time.Millisecond
+
+// watchConfigFile rereads the configuration file whenever it changes,
+// applying its settings live just as SIGHUP does, so that tuning
+// ephemerad doesn't interrupt the components it manages. The file's
+// directory is watched, so that the file being replaced, created or
+// removed is noticed. A file that can't be loaded leaves the current
+// settings in place.
+func watchConfigFile(cfg *config) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		elog.Println("watch configuration:", err)
+		return
+	}
+	file := filepath.Clean(configFile)
+	err = watcher.Add(filepath.Dir(file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			dlog.Println("Not watching the configuration file:", err)
+		} else {
+			elog.Println("watch configuration:", err)
+		}
+		watcher.Close()
+		return
+	}
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != file ||
+					event.Op == fsnotify.Chmod {
+					continue
+				}
+				settled = time.After(configSettleDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				elog.Println("watch configuration:", err)
+			case <-settled:
+				settled = nil
+				ilog.Println("Configuration file changed, " +
+					"rereading settings")
+				reloadSettings(cfg)
+			}
+		}
+	}()
+}
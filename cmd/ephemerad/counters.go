@@ -0,0 +1,160 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const counterSaveInterval = time.Minute
+
+// componentCounters are the cumulative counts kept for a component
+// across restarts of ephemerad and reloads of its instance.
+type componentCounters struct {
+	Activations        uint64 `json:"activations"`
+	ActivationFailures uint64 `json:"activation-failures"`
+	Invocations        uint64 `json:"invocations"`
+	InvocationFailures uint64 `json:"invocation-failures"`
+}
+
+// counterStore keeps each component's cumulative counters, saving them
+// to a file every counterSaveInterval that they changed so that
+// trends can be followed over the long term.
+type counterStore struct {
+	mu       sync.Mutex
+	file     string
+	dirty    bool
+	counters map[string]*componentCounters
+}
+
+var totals = &counterStore{counters: make(map[string]*componentCounters)}
+
+func (s *counterStore) open(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = file
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			elog.Println("counters:", err)
+		}
+	} else {
+		err = json.Unmarshal(buf, &s.counters)
+		if err != nil {
+			elog.Println("counters:", err)
+		}
+		if s.counters == nil {
+			s.counters = make(map[string]*componentCounters)
+		}
+	}
+	go func() {
+		for range time.Tick(counterSaveInterval) {
+			s.flush()
+		}
+	}()
+}
+
+func (s *counterStore) update(name string, fn func(*componentCounters)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[name]
+	if !ok {
+		c = &componentCounters{}
+		s.counters[name] = c
+	}
+	fn(c)
+	s.dirty = true
+}
+
+// activated counts an activation of the component that failed with
+// err, if it did.
+func (s *counterStore) activated(name string, err error) {
+	s.update(name, func(c *componentCounters) {
+		c.Activations++
+		if err != nil {
+			c.ActivationFailures++
+		}
+	})
+}
+
+// scriptFinished counts an invocation of one of the component's
+// scripts that failed with err, if it did.
+func (s *counterStore) scriptFinished(name string, err error) {
+	s.update(name, func(c *componentCounters) {
+		c.Invocations++
+		if err != nil {
+			c.InvocationFailures++
+		}
+	})
+}
+
+func (s *counterStore) get(name string) componentCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[name]
+	if !ok {
+		return componentCounters{}
+	}
+	return *c
+}
+
+// all returns every component's counters, by component name.
+func (s *counterStore) all() map[string]componentCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]componentCounters, len(s.counters))
+	for name, c := range s.counters {
+		out[name] = *c
+	}
+	return out
+}
+
+// flush saves the counters if they changed since they were last
+// saved.
+func (s *counterStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty || s.file == "" {
+		return
+	}
+	err := s.save()
+	if err != nil {
+		elog.Println("counters:", err)
+		return
+	}
+	s.dirty = false
+}
+
+func (s *counterStore) save() error {
+	buf, err := json.Marshal(s.counters)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.file), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+type countersState struct {
+	Activations        uint64 `rfc7951:"activations"`
+	ActivationFailures uint64 `rfc7951:"activation-failures"`
+	Invocations        uint64 `rfc7951:"invocations"`
+	InvocationFailures uint64 `rfc7951:"invocation-failures"`
+}
+
+func (s *counterStore) state(name string) countersState {
+	return countersState(s.get(name))
+}
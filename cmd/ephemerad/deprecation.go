@@ -0,0 +1,45 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// warnDeprecated logs that a deprecated component is being activated,
+// so that its users notice before it is removed.
+func warnDeprecated(comp *component) {
+	if !comp.meta.Deprecated() {
+		return
+	}
+	msg := "Activating deprecated component " + comp.meta.Name()
+	if since := comp.meta.DeprecatedSince(); since != "" {
+		msg += ", deprecated since " + since
+	}
+	if replacement := comp.meta.ReplacedBy(); replacement != "" {
+		msg += ", use " + replacement + " instead"
+	}
+	componentLog(comp.meta.Name(), logLevelError).Println(msg)
+}
+
+// redirect returns the component that a request to activate comp
+// should activate: its replacement if it has RedirectActivation set
+// and the replacement is installed, otherwise comp itself. Only one
+// redirection is followed.
+func redirect(cs *hashmap.Map, comp *component) *component {
+	if !comp.meta.RedirectActivation() {
+		return comp
+	}
+	replacement, ok := cs.Find(comp.meta.ReplacedBy())
+	if !ok {
+		componentLog(comp.meta.Name(), logLevelError).
+			Println("Replacement", comp.meta.ReplacedBy(),
+				"is not installed, activating", comp.meta.Name())
+		return comp
+	}
+	componentLog(comp.meta.Name(), logLevelInfo).
+		Println("Redirecting activation of", comp.meta.Name(), "to",
+			comp.meta.ReplacedBy())
+	return replacement.(*component)
+}
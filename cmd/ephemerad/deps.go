@@ -0,0 +1,264 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// Components may depend on one another. Those a component Requires are
+// activated before it, and it waits for the activation of those it
+// comes After, if one is under way. Stopping a component stops those
+// that Require it first. Sync plans and shutdown start components in
+// dependency order and stop them in the reverse order.
+
+// dependencies returns the names of the components comp is ordered
+// after.
+func dependencies(comp *component) []string {
+	deps := append([]string(nil), comp.meta.Requires()...)
+	return append(deps, comp.meta.AfterComponents()...)
+}
+
+// activating reports whether the component is being activated.
+func (c *component) activating() bool {
+	return atomic.LoadInt32(&c.starting) != 0
+}
+
+// activateRequired activates the components comp Requires that aren't
+// running. chain holds the components whose activation required
+// comp's, so that a cycle is refused rather than followed.
+func activateRequired(
+	comp *component,
+	ha *haMonitor,
+	chain []string,
+) error {
+	name := comp.meta.Name()
+	chain = append(chain, name)
+	cs := ha.managedComponents.Deref().(*hashmap.Map)
+	for _, req := range comp.meta.Requires() {
+		for i, prev := range chain {
+			if prev == req {
+				return dependencyCycle(append(chain[i:], req))
+			}
+		}
+		val, ok := cs.Find(req)
+		if !ok {
+			return newOperatorError(msgRequiredMissing,
+				"component", name, "required", req)
+		}
+		dep := val.(*component)
+		if dep.Running() {
+			continue
+		}
+		err := activateChain(dep, ha, nil, chain)
+		if oerr, ok := err.(*operatorError); ok &&
+			oerr.code == msgDependencyCycle {
+			return err
+		}
+		if err != nil {
+			return newOperatorError(msgRequiredFailed,
+				"component", name, "required", req,
+				"error", err.Error())
+		}
+	}
+	return nil
+}
+
+// waitForComponents waits for the activation of each component comp
+// comes After, if one is under way, to finish. It fails if they don't
+// within the configured unit wait timeout.
+func waitForComponents(comp *component, cs *hashmap.Map) error {
+	name := comp.meta.Name()
+	deadline := time.Now().Add(settings().unitWaitTimeout)
+	for _, after := range comp.meta.AfterComponents() {
+		val, ok := cs.Find(after)
+		if !ok {
+			continue
+		}
+		dep := val.(*component)
+		for dep.activating() {
+			if time.Now().After(deadline) {
+				return newOperatorError(msgDependencyTimeout,
+					"component", name, "dependency", after)
+			}
+			componentLog(name, logLevelDebug).
+				Printf("%s waiting for %s\n", name, after)
+			time.Sleep(unitPollInterval)
+		}
+	}
+	return nil
+}
+
+func dependencyCycle(cycle []string) error {
+	return newOperatorError(msgDependencyCycle,
+		"component", cycle[0], "cycle", strings.Join(cycle, " -> "))
+}
+
+// orderByDependencies orders names, the components in cs, so that each
+// comes after those among them that it depends on, keeping their order
+// otherwise. If they depend on one another in a cycle it fails,
+// returning names as they were.
+func orderByDependencies(cs *hashmap.Map, names []string) ([]string, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	state := make(map[string]int, len(names))
+	out := make([]string, 0, len(names))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, prev := range path {
+				if prev == name {
+					return dependencyCycle(append(path[i:], name))
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		if val, ok := cs.Find(name); ok {
+			for _, dep := range dependencies(val.(*component)) {
+				if !wanted[dep] {
+					continue
+				}
+				err := visit(dep, path)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = visited
+		out = append(out, name)
+		return nil
+	}
+	for _, name := range names {
+		err := visit(name, nil)
+		if err != nil {
+			return names, err
+		}
+	}
+	return out, nil
+}
+
+// checkDependencies logs any cycle among the dependencies of the
+// components in cs, whose activation would fail.
+func checkDependencies(cs *hashmap.Map) {
+	var names []string
+	cs.Range(func(name string, _ *component) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	_, err := orderByDependencies(cs, names)
+	if err != nil {
+		elog.Println("Dependencies:", err)
+	}
+}
+
+// dependsOn reports whether the component in cs named name Requires,
+// or comes After, the one named dep.
+func dependsOn(cs *hashmap.Map, name, dep string) bool {
+	val, ok := cs.Find(name)
+	if !ok {
+		return false
+	}
+	for _, d := range dependencies(val.(*component)) {
+		if d == dep {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredBy returns the names of the components in cs that Require
+// name, in order.
+func requiredBy(cs *hashmap.Map, name string) []string {
+	var out []string
+	cs.Range(func(other string, comp *component) {
+		for _, req := range comp.meta.Requires() {
+			if req == name {
+				out = append(out, other)
+				return
+			}
+		}
+	})
+	sort.Strings(out)
+	return out
+}
+
+// stopWithDependents stops comp, giving params to its Stop script,
+// after stopping the running components in cs that Require it, and in
+// turn those that Require them, so that none is left running without
+// a component it requires. If a dependent can't be taken off the bus
+// comp is left running, failing with why.
+func stopWithDependents(
+	comp *component,
+	cs *hashmap.Map,
+	params map[string]string,
+) error {
+	return stopChain(comp, cs, params, nil)
+}
+
+// stopChain stops comp as stopWithDependents does, with chain holding
+// the components whose stopping required comp's, so that a cycle is
+// followed only once.
+func stopChain(
+	comp *component,
+	cs *hashmap.Map,
+	params map[string]string,
+	chain []string,
+) error {
+	name := comp.meta.Name()
+	chain = append(chain, name)
+	for _, dependent := range requiredBy(cs, name) {
+		if inChain(chain, dependent) {
+			continue
+		}
+		val, _ := cs.Find(dependent)
+		dep := val.(*component)
+		if !dep.Running() {
+			continue
+		}
+		componentLog(dependent, logLevelInfo).Printf(
+			"Stopping %s as it requires %s\n", dependent, name)
+		err := stopChain(dep, cs, nil, chain)
+		if dep.Running() {
+			return err
+		}
+		if err != nil {
+			elog.Printf("Error stopping %s: %s\n", dependent, err)
+		}
+		dep.reason.Reset("deactivated as " + name + " was stopped")
+	}
+	return comp.StopWithParameters(params)
+}
+
+func inChain(chain []string, name string) bool {
+	for _, prev := range chain {
+		if prev == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reversed returns names in the reverse order.
+func reversed(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[len(names)-1-i] = name
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+type rpcDescription struct {
+	Module      string `rfc7951:"module"`
+	Name        string `rfc7951:"name"`
+	Description string `rfc7951:"description,omitempty"`
+	DocURL      string `rfc7951:"doc-url,omitempty"`
+}
+
+type modelDescription struct {
+	Name    string           `rfc7951:"name"`
+	Enabled bool             `rfc7951:"enabled"`
+	RPCs    []rpcDescription `rfc7951:"rpc"`
+}
+
+type describeComponentOutput struct {
+	Name        string             `rfc7951:"ephemerad-v1:name"`
+	Description string             `rfc7951:"ephemerad-v1:description,omitempty"`
+	DocURL      string             `rfc7951:"ephemerad-v1:doc-url,omitempty"`
+	Models      []modelDescription `rfc7951:"ephemerad-v1:model"`
+}
+
+// DescribeComponent returns the documentation a component's instance
+// gives for it and for each of its RPCs, so that operators can tell
+// what it does.
+func (r *rpc) DescribeComponent(
+	in *rfc7951.Tree,
+) (*describeComponentOutput, error) {
+	name := in.At("/ephemerad-v1:component").ToString()
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	val, found := cs.Find(name)
+	if !found {
+		return nil, unknownComponent(name)
+	}
+	meta := val.(*component).docs()
+	out := &describeComponentOutput{
+		Name:        name,
+		Description: meta.Description(),
+		DocURL:      meta.DocURL(),
+	}
+	models := meta.Models()
+	for _, modelName := range meta.ModelNames() {
+		model := models[modelName]
+		md := modelDescription{
+			Name:    modelName,
+			Enabled: model.Enabled(),
+		}
+		for _, module := range model.RPCModules() {
+			for _, rpcName := range model.RPCNames(module) {
+				rd := rpcDescription{Module: module, Name: rpcName}
+				rd.Description = model.RPCDescription(module, rpcName)
+				rd.DocURL = model.RPCDocURL(module, rpcName)
+				md.RPCs = append(md.RPCs, rd)
+			}
+		}
+		out.Models = append(out.Models, md)
+	}
+	return out, nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+)
+
+type flagValue struct {
+	Name  string `rfc7951:"name"`
+	Value string `rfc7951:"value"`
+}
+
+type effectiveTimeouts struct {
+	Start string `rfc7951:"start"`
+	Stop  string `rfc7951:"stop"`
+	Set   string `rfc7951:"set"`
+	Get   string `rfc7951:"get"`
+	RPC   string `rfc7951:"rpc"`
+}
+
+type effectiveHooks struct {
+	Exec string `rfc7951:"exec,omitempty"`
+	URL  string `rfc7951:"url,omitempty"`
+}
+
+type effectiveRetention struct {
+	MaxEntries uint32 `rfc7951:"max-entries"`
+	MaxAge     string `rfc7951:"max-age"`
+	MaxSize    uint64 `rfc7951:"max-size"`
+}
+
+type effectiveSettings struct {
+	LogLevel              string             `rfc7951:"log-level"`
+	UnitWaitTimeout       string             `rfc7951:"unit-wait-timeout"`
+	InstallTimeout        string             `rfc7951:"install-timeout"`
+	AutoActivate          bool               `rfc7951:"auto-activate"`
+	MaxProcesses          uint32             `rfc7951:"max-processes"`
+	MaxComponentProcesses uint32             `rfc7951:"max-component-processes"`
+	Timeouts              effectiveTimeouts  `rfc7951:"timeouts"`
+	TelemetryInterval     string             `rfc7951:"telemetry-interval"`
+	Hooks                 effectiveHooks     `rfc7951:"hooks"`
+	EventHistory          effectiveRetention `rfc7951:"event-history"`
+	SyncHistory           effectiveRetention `rfc7951:"sync-history"`
+}
+
+type getEffectiveConfigOutput struct {
+	Flags    []flagValue       `rfc7951:"ephemerad-v1:flag"`
+	Settings effectiveSettings `rfc7951:"ephemerad-v1:settings"`
+	Features featuresState     `rfc7951:"ephemerad-v1:features"`
+}
+
+// effectiveRetentionOf describes r, with zero bounds meaning no limit.
+func effectiveRetentionOf(r retention) effectiveRetention {
+	return effectiveRetention{
+		MaxEntries: uint32(r.maxEntries),
+		MaxAge:     r.maxAge.String(),
+		MaxSize:    uint64(r.maxSize),
+	}
+}
+
+// GetEffectiveConfig reports how ephemerad is configured once its
+// command line flags, configuration file, configuration model and
+// defaults have been merged, along with which features are currently
+// enabled, so that a unit's configuration can be seen in one place.
+func (r *rpc) GetEffectiveConfig(
+	in *rfc7951.Tree,
+) (*getEffectiveConfigOutput, error) {
+	out := &getEffectiveConfigOutput{Features: featureStates()}
+	flag.VisitAll(func(f *flag.Flag) {
+		out.Flags = append(out.Flags, flagValue{
+			Name:  f.Name,
+			Value: f.Value.String(),
+		})
+	})
+	conf := settings()
+	out.Settings = effectiveSettings{
+		LogLevel:              conf.logLevel,
+		UnitWaitTimeout:       conf.unitWaitTimeout.String(),
+		InstallTimeout:        conf.installTimeout.String(),
+		AutoActivate:          conf.autoActivate,
+		MaxProcesses:          uint32(conf.limits.MaxProcesses),
+		MaxComponentProcesses: uint32(conf.limits.MaxComponentProcesses),
+		Timeouts: effectiveTimeouts{
+			Start: conf.timeouts.Start.String(),
+			Stop:  conf.timeouts.Stop.String(),
+			Set:   conf.timeouts.Set.String(),
+			Get:   conf.timeouts.Get.String(),
+			RPC:   conf.timeouts.RPC.String(),
+		},
+		TelemetryInterval: conf.telemetryInterval.String(),
+		Hooks: effectiveHooks{
+			Exec: conf.hooks.exec,
+			URL:  conf.hooks.url,
+		},
+		EventHistory: effectiveRetentionOf(conf.eventRetention),
+		SyncHistory:  effectiveRetentionOf(conf.syncPlanRetention),
+	}
+	return out, nil
+}
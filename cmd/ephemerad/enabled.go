@@ -0,0 +1,43 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sync"
+)
+
+// adminState records components that have been administratively
+// enabled or disabled with the set-enabled RPC. These override the
+// Enabled key of the instance file and survive the instance file
+// being reloaded.
+type adminState struct {
+	mu        sync.Mutex
+	overrides map[string]bool
+}
+
+var admin = &adminState{overrides: make(map[string]bool)}
+
+func (a *adminState) set(name string, enabled bool) {
+	a.mu.Lock()
+	a.overrides[name] = enabled
+	a.mu.Unlock()
+}
+
+func (a *adminState) enabled(comp *component) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	enabled, ok := a.overrides[comp.meta.Name()]
+	if !ok {
+		return comp.meta.Enabled()
+	}
+	return enabled
+}
+
+func (a *adminState) checkActivation(comp *component) error {
+	if a.enabled(comp) {
+		return nil
+	}
+	return newOperatorError(msgAdminDisabled,
+		"component", comp.meta.Name())
+}
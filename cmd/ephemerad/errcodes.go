@@ -0,0 +1,127 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"github.com/danos/ephemera"
+	"github.com/danos/mgmterror"
+)
+
+// Error codes are given as the error-app-tag of the errors of
+// ephemerad's RPCs, so that callers can branch on them rather than on
+// messages, which may be translated. Unlike messages they never
+// change.
+const (
+	codeComponentNotFound = "component-not-found"
+	codeBrokenInstance    = "broken-instance"
+	codePolicyDenied      = ephemera.AppTagPolicyDenied
+	codeTimeout           = ephemera.AppTagTimeout
+	codeStartFailed       = "start-failed"
+	codeStopFailed        = "stop-failed"
+	codeInvalidInput      = "invalid-input"
+	codeOperationFailed   = "operation-failed"
+)
+
+var errorCodes = map[messageCode]string{
+	msgUnknownComponent:  codeComponentNotFound,
+	msgNotInstalled:      codeComponentNotFound,
+	msgBrokenInstance:    codeBrokenInstance,
+	msgAdminDisabled:     codePolicyDenied,
+	msgHAStandby:         codePolicyDenied,
+	msgAutoActivationOff: codePolicyDenied,
+	msgUnitFailed:        codeStartFailed,
+	msgUnitTimeout:       codeTimeout,
+	msgRequiredMissing:   codeStartFailed,
+	msgRequiredFailed:    codeStartFailed,
+	msgDependencyCycle:   codeStartFailed,
+	msgDependencyTimeout: codeTimeout,
+	msgNotInstanceFile:   codeInvalidInput,
+	msgShuttingDown:      codePolicyDenied,
+	msgParametersChanged: codeInvalidInput,
+}
+
+// mgmtErrorOf returns the management error err is, if it is one.
+func mgmtErrorOf(err error) *mgmterror.MgmtError {
+	switch e := err.(type) {
+	case *mgmterror.MgmtError:
+		return e
+	case *mgmterror.ExecError:
+		return &e.MgmtError
+	case *mgmterror.OperationFailedApplicationError:
+		return &e.MgmtError
+	case *mgmterror.ResourceDeniedApplicationError:
+		return &e.MgmtError
+	case *mgmterror.AccessDeniedApplicationError:
+		return &e.MgmtError
+	case *mgmterror.InvalidValueApplicationError:
+		return &e.MgmtError
+	case *mgmterror.InUseApplicationError:
+		return &e.MgmtError
+	}
+	return nil
+}
+
+// errorCode returns the error code of err, or code if it doesn't
+// have one of its own. The app-tag of a script's management error is
+// its own, as the script's callers may branch on it.
+func errorCode(err error, code string) string {
+	if oerr, ok := err.(*operatorError); ok {
+		return errorCodes[oerr.code]
+	}
+	if merr := mgmtErrorOf(err); merr != nil && merr.AppTag != "" {
+		return merr.AppTag
+	}
+	return code
+}
+
+// rpcError returns err for an RPC's caller as a management error
+// whose error-app-tag is its error code, or code if it doesn't have
+// one of its own.
+func rpcError(err error, code string) error {
+	if err == nil {
+		return nil
+	}
+	code = errorCode(err, code)
+	if merr := mgmtErrorOf(err); merr != nil {
+		merr.AppTag = code
+		return err
+	}
+	return operationFailed(code, err.Error())
+}
+
+// operationFailed is the error for an RPC that failed with msg, whose
+// error-app-tag is code.
+func operationFailed(code, msg string) error {
+	merr := mgmterror.NewOperationFailedApplicationError()
+	merr.AppTag = code
+	merr.Message = msg
+	return merr
+}
+
+// unknownComponent is the error for an RPC naming a component that
+// isn't installed, or whose instance file can't be loaded.
+func unknownComponent(name string) error {
+	merr := mgmterror.NewInvalidValueApplicationError()
+	merr.Path = "/ephemerad-v1:component"
+	file, reason, ok := broken.named(name)
+	if ok {
+		merr.AppTag = codeBrokenInstance
+		merr.Message = message(msgBrokenInstance,
+			"component", name, "file", file, "error", reason)
+		return merr
+	}
+	merr.AppTag = codeComponentNotFound
+	merr.Message = message(msgUnknownComponent, "component", name)
+	return merr
+}
+
+// notInstanceFile is the error for an RPC naming a file outside the
+// instance directories.
+func notInstanceFile(path string) error {
+	merr := mgmterror.NewInvalidValueApplicationError()
+	merr.Path = "/ephemerad-v1:file"
+	merr.AppTag = codeInvalidInput
+	merr.Message = message(msgNotInstanceFile, "file", path)
+	return merr
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultEventLogSize = 1000
+
+var stateDir string
+
+func init() {
+	flag.StringVar(
+		&stateDir,
+		"state-dir",
+		"/var/lib/ephemerad",
+		"directory for ephemerad's persistent state",
+	)
+}
+
+// eventLog is a bounded journal of component lifecycle events. It is
+// kept in memory for queries and appended to a file so that history
+// survives restarts. Events are retained according to the configured
+// event retention. The file is compacted once it holds twice the
+// number of events that are retained, or would grow beyond the
+// retained size.
+type eventLog struct {
+	mu      sync.Mutex
+	file    string
+	written int
+	size    int64
+	events  []*lifecycleEvent
+}
+
+var events = &eventLog{}
+
+func (l *eventLog) open(file string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file = file
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			elog.Println("event log:", err)
+		}
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev lifecycleEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) != nil {
+			continue
+		}
+		l.events = append(l.events, &ev)
+		l.written++
+		l.size += int64(len(scanner.Bytes()) + 1)
+	}
+	l.trim(settings().eventRetention, time.Now())
+}
+
+// trim drops the events r doesn't retain at now.
+func (l *eventLog) trim(r retention, now time.Time) {
+	start := r.keep(len(l.events),
+		func(i int) time.Time { return l.events[i].Time },
+		func(i int) int { return eventSize(l.events[i]) },
+		now)
+	if start > 0 {
+		l.events = append([]*lifecycleEvent(nil), l.events[start:]...)
+	}
+}
+
+// eventSize is the number of bytes ev takes in the file.
+func eventSize(ev *lifecycleEvent) int {
+	buf, _ := json.Marshal(ev)
+	return len(buf) + 1
+}
+
+func (l *eventLog) record(ev *lifecycleEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := settings().eventRetention
+	l.events = append(l.events, ev)
+	l.trim(r, time.Now())
+	if l.file == "" {
+		return
+	}
+	var err error
+	if l.written+1 > 2*len(l.events) ||
+		r.maxSize > 0 && l.size+int64(eventSize(ev)) > r.maxSize {
+		err = l.compact()
+	} else {
+		err = l.append(ev)
+	}
+	if err != nil {
+		elog.Println("event log:", err)
+	}
+}
+
+func (l *eventLog) append(ev *lifecycleEvent) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(l.file), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(buf, '\n'))
+	if err == nil {
+		l.written++
+		l.size += int64(len(buf) + 1)
+	}
+	return err
+}
+
+func (l *eventLog) compact() error {
+	var buf bytes.Buffer
+	for _, ev := range l.events {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmp := l.file + ".tmp"
+	err := ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, l.file)
+	if err != nil {
+		return err
+	}
+	l.written = len(l.events)
+	l.size = int64(buf.Len())
+	return nil
+}
+
+// query returns up to limit of the most recent events, oldest first,
+// optionally restricted to a single component.
+func (l *eventLog) query(component string, limit int) []*lifecycleEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trim(settings().eventRetention, time.Now())
+	var out []*lifecycleEvent
+	for i := len(l.events) - 1; i >= 0; i-- {
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+		ev := l.events[i]
+		if component != "" && ev.Component != component {
+			continue
+		}
+		out = append(out, ev)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// recordEvent journals a lifecycle event and fires any hooks for it.
+func recordEvent(ev *lifecycleEvent) {
+	events.record(ev)
+	fireHooks(ev)
+}
+
+type eventOutput struct {
+	Time      string `rfc7951:"time"`
+	Component string `rfc7951:"component"`
+	Event     string `rfc7951:"event"`
+	Error     string `rfc7951:"error,omitempty"`
+}
+
+type getEventsOutput struct {
+	Events []eventOutput `rfc7951:"ephemerad-v1:event"`
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// runtimeState is the document produced by export-state and consumed
+// by import-state. It captures what ephemerad knows at runtime that
+// cannot be recovered from the instance files.
+type runtimeState struct {
+	Components []componentRuntimeState `json:"components"`
+}
+
+// componentRuntimeState records whether a component is active, the
+// configuration cached for its models without Config/Get, and its
+// sessions with their leases. What the component caches from its
+// scripts to answer requests, such as RPC results and state, is left
+// out as it is rebuilt by running the scripts again.
+type componentRuntimeState struct {
+	Name     string                     `json:"name"`
+	Active   bool                       `json:"active"`
+	Reason   string                     `json:"reason,omitempty"`
+	Config   map[string]json.RawMessage `json:"config,omitempty"`
+	Sessions []sessionRuntimeState      `json:"sessions,omitempty"`
+}
+
+type sessionRuntimeState struct {
+	ID         string            `json:"id"`
+	Expires    time.Time         `json:"expires"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+func exportRuntimeState(cs *hashmap.Map) *runtimeState {
+	out := &runtimeState{}
+	cs.Range(func(name string, comp *component) {
+		st := componentRuntimeState{
+			Name:     name,
+			Active:   comp.Running(),
+			Reason:   comp.Reason(),
+			Sessions: sessions.export(name),
+		}
+		cached, err := comp.meta.CachedConfig()
+		if err != nil {
+			elog.Printf("Export state: %s: %s\n", name, err)
+		}
+		for model, buf := range cached {
+			if st.Config == nil {
+				st.Config = make(map[string]json.RawMessage)
+			}
+			st.Config[model] = buf
+		}
+		out.Components = append(out.Components, st)
+	})
+	sort.Slice(out.Components, func(i, j int) bool {
+		return out.Components[i].Name < out.Components[j].Name
+	})
+	return out
+}
+
+// importRuntimeState brings the managed components in line with a
+// previously exported document. Components that are not known to
+// this ephemerad are skipped. Components are activated as by
+// ephemerad-v1:activate, subject to ha, so activations policy refuses
+// are reported as errors.
+func importRuntimeState(
+	cs *hashmap.Map,
+	ha *haMonitor,
+	in *runtimeState,
+) error {
+	var errs []string
+	// The error's code is that of the first failure, so that a
+	// component failing to start is reported as start-failed.
+	code := ""
+	fail := func(err error, fallback string) {
+		if code == "" {
+			code = errorCode(err, fallback)
+		}
+	}
+	for _, st := range in.Components {
+		val, ok := cs.Find(st.Name)
+		if !ok {
+			dlog.Printf("Import state: skipping unknown component %s\n",
+				st.Name)
+			continue
+		}
+		comp := val.(*component)
+		// The cached configuration is restored first, so that it is
+		// in place for the Start script.
+		for model, buf := range st.Config {
+			err := comp.meta.RestoreCachedConfig(model, buf)
+			if err != nil {
+				fail(err, codeInvalidInput)
+				errs = append(errs, st.Name+": "+err.Error())
+			}
+		}
+		var err error
+		if st.Active {
+			err = activate(comp, ha)
+			if err != nil {
+				fail(err, codeStartFailed)
+			}
+		} else {
+			err = comp.Stop()
+			if err != nil {
+				fail(err, codeStopFailed)
+			}
+		}
+		if err != nil {
+			errs = append(errs, st.Name+": "+err.Error())
+			continue
+		}
+		comp.reason.Reset(st.Reason)
+		if !st.Active {
+			continue
+		}
+		for _, s := range st.Sessions {
+			err := sessions.restore(comp, s.ID, s.Parameters,
+				s.Expires)
+			if err != nil {
+				fail(err, codeStartFailed)
+				errs = append(errs, st.Name+": session "+s.ID+
+					": "+err.Error())
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return operationFailed(code,
+			"import state: "+strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func encodeRuntimeState(st *runtimeState) (string, error) {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func decodeRuntimeState(in string) (*runtimeState, error) {
+	var st runtimeState
+	err := json.Unmarshal([]byte(in), &st)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
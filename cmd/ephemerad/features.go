@@ -0,0 +1,86 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"os"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"github.com/danos/ephemera"
+	"github.com/go-ini/ini"
+)
+
+// featuresEnv names the environment variable holding a comma
+// separated list of features to enable, or to disable when prefixed
+// by '-', overriding the configuration file.
+const featuresEnv = "EPHEMERAD_FEATURES"
+
+// defaultFeatures are ephemera's own feature defaults, restored for
+// features no longer configured.
+var defaultFeatures = ephemera.Features()
+
+// loadFeatures reads the [Features] section, mapping feature names to
+// booleans, and then the features environment variable.
+func loadFeatures(section *ini.Section) (map[string]bool, error) {
+	out := make(map[string]bool)
+	for name, enabled := range defaultFeatures {
+		out[name] = enabled
+	}
+	for _, key := range section.Keys() {
+		if _, ok := defaultFeatures[key.Name()]; !ok {
+			return nil, errors.New("unknown feature " + key.Name())
+		}
+		out[key.Name()] = key.MustBool(out[key.Name()])
+	}
+	env, err := ephemera.ParseFeatures(os.Getenv(featuresEnv))
+	if err != nil {
+		return nil, errors.New(featuresEnv + ": " + err.Error())
+	}
+	for name, enabled := range env {
+		out[name] = enabled
+	}
+	return out, nil
+}
+
+func applyFeatures(features map[string]bool) {
+	for name, enabled := range features {
+		err := ephemera.SetFeature(name, enabled)
+		if err != nil {
+			elog.Println("features:", err)
+		}
+	}
+}
+
+type featureState struct {
+	Name    string `rfc7951:"name"`
+	Enabled bool   `rfc7951:"enabled"`
+}
+
+type featuresState struct {
+	Feature []featureState `rfc7951:"feature"`
+}
+
+func featureStates() featuresState {
+	var out featuresState
+	features := ephemera.Features()
+	for _, name := range ephemera.FeatureNames() {
+		out.Feature = append(out.Feature, featureState{
+			Name:    name,
+			Enabled: features[name],
+		})
+	}
+	return out
+}
+
+func (r *rpc) SetFeature(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	name := in.At("/ephemerad-v1:name").ToString()
+	enabled := in.At("/ephemerad-v1:enabled").ToBool()
+	err := ephemera.SetFeature(name, enabled)
+	if err != nil {
+		return nil, rpcError(err, codeInvalidInput)
+	}
+	ilog.Printf("Feature %s set to %t\n", name, enabled)
+	return rfc7951.TreeNew(), nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"sync"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"github.com/danos/vci"
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+const (
+	haActive  = "active"
+	haStandby = "standby"
+
+	reasonHAStandby = "stopped while the router is HA standby"
+)
+
+var haNotification string
+
+func init() {
+	flag.StringVar(
+		&haNotification,
+		"ha-notification",
+		"",
+		"module:notification carrying the platform HA state "+
+			"(disabled if empty)",
+	)
+}
+
+// haMonitor tracks whether this router is the active member of an HA
+// pair and keeps ActiveOnly components in step with that. Without HA
+// the router is always considered active.
+type haMonitor struct {
+	managedComponents *atom.Atom
+
+	mu    sync.Mutex
+	state string
+	// gen counts the transitions, so that one superseded by a later
+	// transition stops acting on the components.
+	gen uint64
+
+	// transitioning serializes acting on the components for each
+	// transition, which is done without holding mu so that the
+	// state can be queried meanwhile.
+	transitioning sync.Mutex
+}
+
+func newHAMonitor(managedComponents *atom.Atom) *haMonitor {
+	return &haMonitor{
+		managedComponents: managedComponents,
+		state:             haActive,
+	}
+}
+
+func (h *haMonitor) State() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+func (h *haMonitor) IsActive() bool {
+	return h.State() == haActive
+}
+
+func (h *haMonitor) transition(state string) {
+	h.mu.Lock()
+	if state == h.state {
+		h.mu.Unlock()
+		return
+	}
+	ilog.Printf("HA state changing from %s to %s\n", h.state, state)
+	h.state = state
+	h.gen++
+	gen := h.gen
+	cs := h.managedComponents.Deref().(*hashmap.Map)
+	var comps []*component
+	cs.Range(func(name string, comp *component) {
+		if comp.meta.ActiveOnly() {
+			comps = append(comps, comp)
+		}
+	})
+	h.mu.Unlock()
+
+	h.transitioning.Lock()
+	defer h.transitioning.Unlock()
+	for _, comp := range comps {
+		if h.superseded(gen) {
+			return
+		}
+		switch state {
+		case haStandby:
+			h.becomeStandby(comp, cs)
+		case haActive:
+			h.becomeActive(comp)
+		}
+	}
+}
+
+// superseded reports whether the transition counted as gen has been
+// followed by another.
+func (h *haMonitor) superseded(gen uint64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return gen != h.gen
+}
+
+// becomeStandby stops the ActiveOnly component comp, along with the
+// components in cs depending on it, and runs its OnStandby script.
+func (h *haMonitor) becomeStandby(comp *component, cs *hashmap.Map) {
+	name := comp.meta.Name()
+	if comp.Running() {
+		err := stopWithDependents(comp, cs, nil)
+		if err != nil {
+			elog.Printf("Error stopping %s for HA standby: %s\n",
+				name, err)
+		} else {
+			comp.reason.Reset(reasonHAStandby)
+		}
+	}
+	err := comp.meta.BecomeStandby()
+	if err != nil {
+		elog.Printf("Error running OnStandby for %s: %s\n",
+			name, err)
+	}
+}
+
+// becomeActive runs the ActiveOnly component comp's OnActive script,
+// and activates it again if it was stopped for HA standby, subject to
+// the same policy as ephemerad-v1:activate.
+func (h *haMonitor) becomeActive(comp *component) {
+	name := comp.meta.Name()
+	err := comp.meta.BecomeActive()
+	if err != nil {
+		elog.Printf("Error running OnActive for %s: %s\n",
+			name, err)
+	}
+	if comp.Reason() != reasonHAStandby || !settings().autoActivate {
+		return
+	}
+	err = activate(comp, h)
+	if err != nil {
+		elog.Printf("Error starting %s for HA active: %s\n",
+			name, err)
+	}
+}
+
+func (h *haMonitor) checkActivation(comp *component) error {
+	if !comp.meta.ActiveOnly() || h.IsActive() {
+		return nil
+	}
+	return newOperatorError(msgHAStandby, "component", comp.meta.Name())
+}
+
+// parseHAState maps the platform's notion of HA state on to ours,
+// treating anything that is not explicitly active as standby.
+func parseHAState(state string) string {
+	switch strings.ToLower(state) {
+	case "active", "master":
+		return haActive
+	default:
+		return haStandby
+	}
+}
+
+func (h *haMonitor) subscribe(client *vci.Client, spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return errors.New("invalid HA notification " + spec)
+	}
+	module, name := parts[0], parts[1]
+	return client.Subscribe(module, name, func(in *rfc7951.Tree) {
+		h.transition(parseHAState(
+			in.At("/" + module + ":state").ToString()))
+	}).Run()
+}
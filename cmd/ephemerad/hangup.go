@@ -0,0 +1,55 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+	"jsouthworth.net/go/etm/atom"
+)
+
+// handleHangup rereads the configuration file and the instance
+// directories whenever ephemerad receives SIGHUP, so that it can be
+// reloaded like other daemons, such as by systemd's ExecReload.
+// Settings committed through ephemerad's configuration model still
+// override those from the file.
+func handleHangup(managedComponents *atom.Atom, cfg *config) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, unix.SIGHUP)
+	go func() {
+		for range sigs {
+			ilog.Println("Received SIGHUP, rereading the " +
+				"configuration and instance directories")
+			reloadSettings(cfg)
+			reloadMessageCatalog()
+			managedComponents.Swap(instanceSwapper(instanceDirs.dirs))
+		}
+	}()
+}
+
+// reloadSettings rereads the configuration file, keeping the current
+// settings if it can't be loaded.
+func reloadSettings(cfg *config) {
+	conf, err := loadDaemonConfig(configFile)
+	if err != nil {
+		elog.Println("Keeping the current settings:", err)
+		return
+	}
+	fileSettings.Reset(conf)
+	applySettings(mergeSettings(conf, cfg.Get().Settings))
+}
+
+// reloadMessageCatalog rereads the message catalog, if there is one,
+// keeping the current messages if it can't be loaded.
+func reloadMessageCatalog() {
+	if messageCatalog == "" {
+		return
+	}
+	err := loadMessageCatalog(messageCatalog)
+	if err != nil {
+		elog.Println("Keeping the current message catalog:", err)
+	}
+}
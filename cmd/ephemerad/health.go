@@ -0,0 +1,202 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"github.com/danos/vci"
+)
+
+const busProbeTimeout = 5 * time.Second
+
+var healthListen string
+
+func init() {
+	flag.StringVar(
+		&healthListen,
+		"health-listen",
+		"",
+		"localhost address, such as 127.0.0.1:8081, to serve "+
+			"/healthz and /readyz on",
+	)
+}
+
+// healthMonitor tracks the conditions reported to external watchdogs
+// by the health endpoint.
+type healthMonitor struct {
+	mu      sync.Mutex
+	client  *vci.Client
+	watcher error
+	fatal   error
+	pings   chan chan struct{}
+}
+
+var health = &healthMonitor{}
+
+// setClient records that ephemerad has registered on the bus.
+func (h *healthMonitor) setClient(client *vci.Client) {
+	h.mu.Lock()
+	h.client = client
+	h.mu.Unlock()
+}
+
+// setWatcherError records the latest error from the instance
+// directory watcher, or that it is delivering events again.
+func (h *healthMonitor) setWatcherError(err error) {
+	h.mu.Lock()
+	h.watcher = err
+	h.mu.Unlock()
+}
+
+// setFatal records an error ephemerad can't recover from without
+// being restarted.
+func (h *healthMonitor) setFatal(err error) {
+	h.mu.Lock()
+	if h.fatal == nil {
+		h.fatal = err
+	}
+	h.mu.Unlock()
+}
+
+// watcherPings returns the channel on which the instance directory
+// watcher is to answer pings, by closing the channel it receives.
+func (h *healthMonitor) watcherPings() chan chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pings == nil {
+		h.pings = make(chan chan struct{})
+	}
+	return h.pings
+}
+
+// pingWatcher checks that the instance directory watcher answers a
+// ping within timeout, and so is still reading events. The events
+// are handled elsewhere, so a long sync doesn't hold up the answer.
+// It succeeds when nothing watches the directories for events, such
+// as when they are polled.
+func (h *healthMonitor) pingWatcher(timeout time.Duration) error {
+	h.mu.Lock()
+	pings := h.pings
+	h.mu.Unlock()
+	if pings == nil {
+		return nil
+	}
+	deadline := time.After(timeout)
+	done := make(chan struct{})
+	select {
+	case pings <- done:
+	case <-deadline:
+		return errors.New("watch instances: no response after " +
+			timeout.String())
+	}
+	select {
+	case <-done:
+		return nil
+	case <-deadline:
+		return errors.New("watch instances: no response after " +
+			timeout.String())
+	}
+}
+
+// liveness reports why ephemerad should be restarted, if it should.
+func (h *healthMonitor) liveness() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.fatal != nil {
+		return h.fatal
+	}
+	if h.watcher != nil {
+		return errors.New("watch instances: " + h.watcher.Error())
+	}
+	return nil
+}
+
+// readiness reports why ephemerad can't currently serve requests, if
+// it can't.
+func (h *healthMonitor) readiness() error {
+	err := h.liveness()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	client := h.client
+	h.mu.Unlock()
+	if client == nil {
+		return errors.New("not registered on the bus")
+	}
+	return probeBus(client)
+}
+
+// probeBus checks that ephemerad's own RPCs can be reached over the
+// bus.
+func probeBus(client *vci.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		var out listComponentsOutput
+		done <- client.Call("ephemerad-v1", "list-components",
+			rfc7951.TreeNew()).StoreOutputInto(&out)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.New("bus: " + err.Error())
+		}
+		return nil
+	case <-time.After(busProbeTimeout):
+		return errors.New("bus: no response after " +
+			busProbeTimeout.String())
+	}
+}
+
+func healthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := check()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// listenLoopback listens on addr for the named endpoint, refusing
+// addresses other than loopback ones as ephemerad's HTTP endpoints are
+// unauthenticated.
+func listenLoopback(endpoint, addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, errors.New(endpoint + " endpoint " + addr +
+			" is not a loopback address")
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveHealth serves /healthz and /readyz on addr, which must be a
+// loopback address as the endpoint is unauthenticated.
+func serveHealth(addr string) error {
+	listener, err := listenLoopback("health", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler(health.liveness))
+	mux.HandleFunc("/readyz", healthHandler(health.readiness))
+	go func() {
+		err := http.Serve(listener, mux)
+		elog.Println("health endpoint:", err)
+	}()
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/danos/ephemera"
+)
+
+const (
+	eventStarted   = "started"
+	eventCompleted = "completed"
+	eventStopped   = "stopped"
+	eventFailed    = "failed"
+)
+
+// hookTimeout bounds how long a hook may take before it is given up
+// on, and for exec hooks killed.
+const hookTimeout = 10 * time.Second
+
+// lifecycleEvent is the JSON body passed to lifecycle hooks.
+type lifecycleEvent struct {
+	Event     string    `json:"event"`
+	Component string    `json:"component"`
+	Time      time.Time `json:"time"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func newLifecycleEvent(event, name string, err error) *lifecycleEvent {
+	ev := &lifecycleEvent{
+		Event:     event,
+		Component: name,
+		Time:      time.Now(),
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	return ev
+}
+
+// fireHooks runs the configured lifecycle hooks for the event in the
+// background so that slow hooks can't hold up component management.
+func fireHooks(ev *lifecycleEvent) {
+	hooks := settings().hooks
+	if hooks.exec == "" && hooks.url == "" {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		elog.Println("lifecycle hook:", err)
+		return
+	}
+	if hooks.exec != "" {
+		goComponent(ev.Component, func() {
+			runExecHook(hooks.exec, ev, body)
+		})
+	}
+	if hooks.url != "" {
+		goComponent(ev.Component, func() {
+			runHTTPHook(hooks.url, body)
+		})
+	}
+}
+
+func runExecHook(command string, ev *lifecycleEvent, body []byte) {
+	args, err := ephemera.SplitCommand(command)
+	if err != nil {
+		elog.Printf("Error for lifecycle hook %s: %s\n", command, err)
+		return
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewBuffer(body)
+	cmd.Env = []string{
+		"VCI_COMPONENT_NAME=" + ev.Component,
+		"EPHEMERA_EVENT=" + ev.Event,
+	}
+	// The hook leads its own process group so that it can be
+	// killed, along with anything it started, if it hangs.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = execProcs.start(cmd)
+	if err == nil {
+		killed := ephemera.KillAfter(cmd, hookTimeout)
+		err = execProcs.wait(cmd)
+		if killed() {
+			err = errors.New("timed out after " + hookTimeout.String())
+		}
+	}
+	if err != nil {
+		elog.Printf("Error for lifecycle hook %s: %s\n%s\n",
+			command, err, out.Bytes())
+	}
+}
+
+func runHTTPHook(url string, body []byte) {
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(url, "application/json",
+		bytes.NewBuffer(body))
+	if err != nil {
+		elog.Printf("Error for lifecycle hook %s: %s\n", url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		elog.Printf("Error for lifecycle hook %s: %s\n", url, resp.Status)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danos/ephemera"
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// idleMonitor deactivates running components that have an IdleTimeout
+// once they have gone that long without a Config, State or RPC
+// request, counting from their activation if they have had none
+// since. Each such component gets its own timer loop, which is started
+// and stopped as the managed components change. Components with open
+// sessions are left running.
+type idleMonitor struct {
+	mu    sync.Mutex
+	loops map[*component]chan struct{}
+}
+
+func newIdleMonitor() *idleMonitor {
+	return &idleMonitor{loops: make(map[*component]chan struct{})}
+}
+
+func (m *idleMonitor) sync(
+	key string,
+	a *atom.Atom,
+	old, new *hashmap.Map,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for comp, done := range m.loops {
+		cur, ok := new.Find(comp.meta.Name())
+		if ok && cur.(*component) == comp {
+			continue
+		}
+		close(done)
+		delete(m.loops, comp)
+	}
+	new.Range(func(name string, comp *component) {
+		if _, ok := m.loops[comp]; ok {
+			return
+		}
+		if comp.meta.IdleTimeout() <= 0 ||
+			comp.meta.Type() == ephemera.TypeOneshot {
+			return
+		}
+		done := make(chan struct{})
+		m.loops[comp] = done
+		goComponent(name, func() { m.loop(comp, done) })
+	})
+}
+
+func (m *idleMonitor) loop(comp *component, done chan struct{}) {
+	timeout := comp.meta.IdleTimeout()
+	wait := timeout
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		wait = m.check(comp, timeout)
+	}
+}
+
+// check deactivates comp if it has been idle for timeout, returning
+// how long to wait before checking again.
+func (m *idleMonitor) check(
+	comp *component,
+	timeout time.Duration,
+) time.Duration {
+	name := comp.meta.Name()
+	if !comp.Running() || len(sessions.ids(name)) != 0 {
+		return timeout
+	}
+	last := comp.meta.LastUsed()
+	activated := comp.lifecycle.Deref().(*lifecycle).activated
+	if activated.After(last) {
+		last = activated
+	}
+	idle := time.Since(last)
+	if idle < timeout {
+		return timeout - idle
+	}
+	componentLog(name, logLevelInfo).Printf(
+		"Deactivating %s, idle for %s\n", name, idle.Round(time.Second))
+	err := comp.Stop()
+	if err != nil {
+		elog.Printf("Error deactivating idle %s: %s\n", name, err)
+		return timeout
+	}
+	comp.reason.Reset("deactivated after being idle for " +
+		timeout.String())
+	return timeout
+}
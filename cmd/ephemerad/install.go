@@ -0,0 +1,105 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+var installTimeout time.Duration
+
+func init() {
+	flag.DurationVar(
+		&installTimeout,
+		"install-timeout",
+		2*time.Minute,
+		"how long activate waits, when asked to, for a component "+
+			"that isn't yet installed",
+	)
+}
+
+// installWaiters are the activations waiting for their components'
+// instance files to be installed, so that a unit activating a
+// component at boot doesn't race the package installing it.
+type installWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+var installs = &installWaiters{waiters: make(map[string][]chan struct{})}
+
+// sync is the managed components watch waking those waiting for the
+// components that have appeared.
+func (w *installWaiters) sync(
+	key string,
+	a *atom.Atom,
+	old, new *hashmap.Map,
+) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, chs := range w.waiters {
+		if !new.Contains(name) {
+			continue
+		}
+		for _, ch := range chs {
+			close(ch)
+		}
+		delete(w.waiters, name)
+	}
+}
+
+func (w *installWaiters) add(name string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan struct{})
+	w.waiters[name] = append(w.waiters[name], ch)
+	return ch
+}
+
+func (w *installWaiters) remove(name string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	chs := w.waiters[name]
+	for i, c := range chs {
+		if c == ch {
+			chs = append(chs[:i], chs[i+1:]...)
+			break
+		}
+	}
+	if len(chs) == 0 {
+		delete(w.waiters, name)
+		return
+	}
+	w.waiters[name] = chs
+}
+
+// await returns the managed components once they include name, or
+// fails if it isn't installed within the install timeout.
+func (w *installWaiters) await(
+	managedComponents *atom.Atom,
+	name string,
+) (*hashmap.Map, error) {
+	ch := w.add(name)
+	defer w.remove(name, ch)
+	// It may have been installed before we started waiting.
+	cs := managedComponents.Deref().(*hashmap.Map)
+	if cs.Contains(name) {
+		return cs, nil
+	}
+	timeout := settings().installTimeout
+	componentLog(name, logLevelInfo).Printf(
+		"Waiting up to %s for %s to be installed\n", timeout, name)
+	select {
+	case <-ch:
+		return managedComponents.Deref().(*hashmap.Map), nil
+	case <-time.After(timeout):
+		return nil, newOperatorError(msgNotInstalled,
+			"component", name, "timeout", timeout.String())
+	}
+}
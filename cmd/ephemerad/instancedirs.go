@@ -0,0 +1,124 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultInstanceDir = "/lib/vci/ephemera/instances"
+
+// instanceDirList is the -instance-dir flag. It may be given more
+// than once, or as a colon separated list, and a component defined
+// in more than one directory is taken from the first directory
+// listed.
+type instanceDirList struct {
+	dirs []string
+	set  bool
+}
+
+var instanceDirs = &instanceDirList{dirs: []string{defaultInstanceDir}}
+
+func (l *instanceDirList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.dirs, ":")
+}
+
+func (l *instanceDirList) Set(s string) error {
+	if !l.set {
+		// Replace the default.
+		l.dirs = nil
+		l.set = true
+	}
+	for _, dir := range strings.Split(s, ":") {
+		if dir != "" {
+			l.dirs = append(l.dirs, filepath.Clean(dir))
+		}
+	}
+	return nil
+}
+
+// within reports whether path is dir or lies beneath it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// inInstanceDir reports whether path is one of the instance
+// directories or lies beneath one.
+func inInstanceDir(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if within(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceFile returns path with any symbolic links resolved if it
+// names a file in one of the instance directories, so that the
+// daemon can't be asked to read files elsewhere on its behalf.
+func instanceFile(path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	for _, dir := range instanceDirs.dirs {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = real
+		}
+		if resolved != dir && within(resolved, dir) {
+			return resolved, true
+		}
+	}
+	return "", false
+}
+
+// concernsInstances reports whether an event on path affects the
+// instance directories: it is in one of them, or it is one of their
+// ancestors, whose removal takes the directory with it.
+func concernsInstances(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if within(path, dir) || within(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchInstanceDir watches dir, if it exists, and everything beneath
+// it. Otherwise it watches the closest existing ancestor so that it
+// is noticed when the directory is recreated, such as when a package
+// is purged and reinstalled.
+func watchInstanceDir(watcher *fsnotify.Watcher, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		// Also watch the parent to notice dir being removed and
+		// recreated.
+		watchAncestor(watcher, filepath.Dir(dir))
+		return watchTree(watcher, dir)
+	}
+	return watchAncestor(watcher, filepath.Dir(dir))
+}
+
+func watchAncestor(watcher *fsnotify.Watcher, dir string) error {
+	for {
+		_, err := os.Stat(dir)
+		if err == nil {
+			return watcher.Add(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/danos/ephemera"
+)
+
+// A component's listener is its registration on the bus. If it drops
+// off the bus without ephemerad stopping it, a component with
+// Restart=on-failure has it restarted after a delay that doubles with
+// each consecutive restart. Otherwise the component is deactivated.
+
+// startListener registers the component's models on the bus and
+// watches for them dropping off it. It must be called by the started
+// agent.
+func (c *component) startListener() error {
+	err := c.vci.Run()
+	if err != nil {
+		return err
+	}
+	c.listening = true
+	c.listenerGen++
+	c.listenerUp = time.Now()
+	gen := c.listenerGen
+	goComponent(c.meta.Name(), func() { c.watchListener(gen) })
+	return nil
+}
+
+// stopListener unregisters the component's models from the bus, and
+// cancels any pending restart of its listener. It must be called by
+// the started agent.
+func (c *component) stopListener() error {
+	c.listenerGen++
+	if !c.listening {
+		return nil
+	}
+	c.listening = false
+	return c.vci.Stop()
+}
+
+// watchListener waits for the listener started as generation gen to
+// stop, handling it having dropped off the bus if ephemerad didn't
+// stop it.
+func (c *component) watchListener(gen uint64) {
+	err := c.vci.Wait()
+	c.started.Send(func(isRunning bool) bool {
+		if !c.listening || gen != c.listenerGen {
+			return isRunning
+		}
+		c.listenerDropped(isRunning, err)
+		return isRunning
+	})
+}
+
+// listenerDropped handles the component's listener having dropped off
+// the bus with err. It must be called by the started agent.
+func (c *component) listenerDropped(isRunning bool, err error) {
+	name := c.meta.Name()
+	msg := "listener dropped off the bus"
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	elog.Printf("%s: %s\n", name, msg)
+	recordEvent(newLifecycleEvent(eventFailed, name, errors.New(msg)))
+	atomic.AddUint64(&c.listenerDrops, 1)
+
+	// Release whatever is left of the connection.
+	c.stopListener()
+	c.proxied = false
+
+	if c.meta.Restart() == ephemera.RestartOnFailure &&
+		ephemera.FeatureEnabled(ephemera.FeatureSupervision) {
+		if time.Since(c.listenerUp) >= c.meta.RestartMaxDelay() {
+			c.listenerFailures = 0
+		}
+		c.scheduleRestart()
+		return
+	}
+	if !isRunning {
+		return
+	}
+	goComponent(name, func() {
+		err := c.Stop()
+		if err != nil {
+			elog.Printf("Error deactivating %s: %s\n", name, err)
+			return
+		}
+		c.reason.Reset("deactivated after its listener dropped off " +
+			"the bus")
+	})
+}
+
+// scheduleRestart restarts the component's listener after its restart
+// delay, unless it is started or stopped in the meantime. It must be
+// called by the started agent.
+func (c *component) scheduleRestart() {
+	c.listenerFailures++
+	delay := restartDelay(c.meta, c.listenerFailures)
+	gen := c.listenerGen
+	name := c.meta.Name()
+	componentLog(name, logLevelInfo).Printf(
+		"Restarting listener for %s in %s\n", name, delay)
+	goComponent(name, func() {
+		time.Sleep(delay)
+		c.started.Send(func(isRunning bool) bool {
+			if c.listening || gen != c.listenerGen ||
+				isShuttingDown() {
+				return isRunning
+			}
+			if !isRunning && !c.meta.OnDemand() {
+				return isRunning
+			}
+			c.restartListener(isRunning)
+			return isRunning
+		})
+	})
+}
+
+// restartListener restarts the component's listener, as its proxy if
+// it isn't running, scheduling another attempt if that fails. It must
+// be called by the started agent.
+func (c *component) restartListener(isRunning bool) {
+	name := c.meta.Name()
+	err := c.startListener()
+	if err != nil {
+		elog.Printf("Error restarting listener for %s: %s\n", name, err)
+		recordEvent(newLifecycleEvent(eventFailed, name, err))
+		c.scheduleRestart()
+		return
+	}
+	c.proxied = !isRunning
+	componentLog(name, logLevelInfo).
+		Println("Restarted listener for", name)
+	c.reason.Reset("listener restarted after dropping off the bus")
+}
+
+// restartDelay is how long to wait before the nth consecutive restart
+// of the listener of the component defined by meta.
+func restartDelay(meta *ephemera.Component, n int) time.Duration {
+	delay, max := meta.RestartDelay(), meta.RestartMaxDelay()
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// ListenerDrops counts the times the component's listener has dropped
+// off the bus.
+func (c *component) ListenerDrops() uint64 {
+	return atomic.LoadUint64(&c.listenerDrops)
+}
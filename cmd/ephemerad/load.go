@@ -0,0 +1,172 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadReport describes the outcome of reading the instance directory,
+// so that provisioning can verify a deployment landed cleanly.
+type loadReport struct {
+	Loaded     []string            `rfc7951:"ephemerad-v1:loaded"`
+	Rejected   []rejectedInstance  `rfc7951:"ephemerad-v1:rejected"`
+	Duplicates []duplicateInstance `rfc7951:"ephemerad-v1:duplicate"`
+}
+
+type rejectedInstance struct {
+	File   string `rfc7951:"file"`
+	Reason string `rfc7951:"reason"`
+}
+
+// duplicateInstance records a file ignored because a file later in
+// the instance directory defines a component of the same name.
+type duplicateInstance struct {
+	Component string `rfc7951:"component"`
+	File      string `rfc7951:"file"`
+	UsedFile  string `rfc7951:"used-file"`
+}
+
+func (r *loadReport) loaded(name string) {
+	r.Loaded = append(r.Loaded, name)
+}
+
+func (r *loadReport) reject(file string, err error) {
+	r.Rejected = append(r.Rejected, rejectedInstance{
+		File:   file,
+		Reason: err.Error(),
+	})
+}
+
+func (r *loadReport) duplicate(name, file, usedFile string) {
+	for i, n := range r.Loaded {
+		if n == name {
+			r.Loaded = append(r.Loaded[:i], r.Loaded[i+1:]...)
+			break
+		}
+	}
+	r.Duplicates = append(r.Duplicates, duplicateInstance{
+		Component: name,
+		File:      file,
+		UsedFile:  usedFile,
+	})
+}
+
+// log writes the report as a single summary entry.
+func (r *loadReport) log() {
+	var b strings.Builder
+	b.WriteString("Startup: loaded " + strings.Join(r.Loaded, ", "))
+	if len(r.Loaded) == 0 {
+		b.WriteString("no components")
+	}
+	for _, rej := range r.Rejected {
+		b.WriteString("; rejected " + rej.File + ": " + rej.Reason)
+	}
+	for _, dup := range r.Duplicates {
+		b.WriteString("; ignored " + dup.File + " as " + dup.Component +
+			" is defined by " + dup.UsedFile)
+	}
+	ilog.Println(b.String())
+}
+
+type brokenInstance struct {
+	File      string `rfc7951:"file"`
+	Error     string `rfc7951:"error"`
+	FirstSeen string `rfc7951:"first-seen"`
+	LastSeen  string `rfc7951:"last-seen"`
+}
+
+type brokenEntry struct {
+	err       string
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// brokenInstances remembers the instance files that were rejected the
+// last time the instance directory was read, and since when, so that
+// they are visible in the operational datastore and not just syslog.
+type brokenInstances struct {
+	mu    sync.Mutex
+	files map[string]*brokenEntry
+}
+
+var broken = &brokenInstances{files: make(map[string]*brokenEntry)}
+
+// update replaces the broken instances with those rejected by report.
+// Files that are still broken keep the time they were first seen.
+func (b *brokenInstances) update(report *loadReport, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	files := make(map[string]*brokenEntry, len(report.Rejected))
+	for _, rej := range report.Rejected {
+		entry, ok := b.files[rej.File]
+		if !ok {
+			entry = &brokenEntry{firstSeen: now}
+		}
+		entry.err = rej.Reason
+		entry.lastSeen = now
+		files[rej.File] = entry
+	}
+	b.files = files
+}
+
+// reject records that file is broken.
+func (b *brokenInstances) reject(file string, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.files[file]
+	if !ok {
+		entry = &brokenEntry{firstSeen: now}
+		b.files[file] = entry
+	}
+	entry.err = err.Error()
+	entry.lastSeen = now
+}
+
+// clear records that file is no longer broken.
+func (b *brokenInstances) clear(file string) {
+	b.mu.Lock()
+	delete(b.files, file)
+	b.mu.Unlock()
+}
+
+// named returns the broken instance file for the named component, if
+// there is one, going by the name of the file or of the component
+// directory holding it, and why it is broken.
+func (b *brokenInstances) named(name string) (string, string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for file, entry := range b.files {
+		base := filepath.Base(file)
+		if base == componentInstanceFile {
+			base = filepath.Base(filepath.Dir(file))
+		}
+		if base == name {
+			return file, entry.err, true
+		}
+	}
+	return "", "", false
+}
+
+func (b *brokenInstances) state() []brokenInstance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]brokenInstance, 0, len(b.files))
+	for file, entry := range b.files {
+		out = append(out, brokenInstance{
+			File:      file,
+			Error:     entry.err,
+			FirstSeen: entry.firstSeen.Format(time.RFC3339),
+			LastSeen:  entry.lastSeen.Format(time.RFC3339),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].File < out[j].File
+	})
+	return out
+}
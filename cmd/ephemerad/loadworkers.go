@@ -0,0 +1,70 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"sync"
+)
+
+var loadWorkers int
+
+func init() {
+	flag.IntVar(
+		&loadWorkers,
+		"load-workers",
+		runtime.NumCPU(),
+		"how many instance files to parse at once when reading the "+
+			"instance directories",
+	)
+}
+
+// instanceResult is the outcome of reading an instance.
+type instanceResult struct {
+	comp *component
+	file string
+	err  error
+}
+
+// readInstancesConcurrently loads the components defined by each of
+// names, with its file info in fis, as readInstance does. Up to
+// loadWorkers are parsed at once, so that boxes with many instances
+// are ready sooner. The results are in the order of names.
+func readInstancesConcurrently(
+	names []string,
+	fis []os.FileInfo,
+) []instanceResult {
+	results := make([]instanceResult, len(names))
+	workers := loadWorkers
+	if workers > len(names) {
+		workers = len(names)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				comp, file, err := readInstance(names[i], fis[i])
+				results[i] = instanceResult{
+					comp: comp,
+					file: file,
+					err:  err,
+				}
+			}
+		}()
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+	"github.com/danos/ephemera"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logFormat string
+
+func init() {
+	flag.StringVar(
+		&logFormat,
+		"log-format",
+		logFormatText,
+		"format of log messages: "+logFormatText+", or "+logFormatJSON+
+			" for one object per message with script invocations' "+
+			"component, model, operation, duration and exit code",
+	)
+}
+
+var errInvalidLogFormat = errors.New("log format must be " +
+	logFormatText + " or " + logFormatJSON)
+
+// jsonOut holds the writers under elog, ilog and dlog, by level,
+// from before they were wrapped to write JSON.
+var jsonOut map[string]io.Writer
+
+// setLogFormat makes elog, ilog and dlog write messages in format. It
+// must be called before the log level is first set.
+func setLogFormat(format string) error {
+	switch format {
+	case logFormatText:
+		return nil
+	case logFormatJSON:
+	default:
+		return errInvalidLogFormat
+	}
+	loggers := map[string]*log.Logger{
+		logLevelError: elog,
+		logLevelInfo:  ilog,
+		logLevelDebug: dlog,
+	}
+	jsonOut = make(map[string]io.Writer, len(loggers))
+	for level, l := range loggers {
+		jsonOut[level] = l.Writer()
+		l.SetOutput(&jsonLineWriter{level: level, out: l.Writer()})
+	}
+	return nil
+}
+
+// writeJSON writes fields, along with the time and level, to out as a
+// single line.
+func writeJSON(out io.Writer, level string, fields map[string]interface{}) {
+	fields["time"] = time.Now().Format(time.RFC3339Nano)
+	fields["level"] = level
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	out.Write(append(buf, '\n'))
+}
+
+// jsonLineWriter writes each message it is given by a log.Logger as a
+// JSON object.
+type jsonLineWriter struct {
+	level string
+	out   io.Writer
+}
+
+func (w *jsonLineWriter) Write(p []byte) (int, error) {
+	writeJSON(w.out, w.level, map[string]interface{}{
+		"message": strings.TrimSuffix(string(p), "\n"),
+	})
+	return len(p), nil
+}
+
+// scriptLogger is an ephemera.StructuredLogger logging script errors
+// and output as JSON objects with their fields, and other messages
+// through Logger.
+type scriptLogger struct {
+	ephemera.Logger
+	level string
+}
+
+func (l *scriptLogger) LogScript(rec ephemera.ScriptRecord) {
+	if l.level != logLevelError &&
+		componentLog(rec.Component, l.level) == logging.discard {
+		return
+	}
+	fields := map[string]interface{}{
+		"component":   rec.Component,
+		"operation":   rec.Operation,
+		"duration_ms": rec.Duration.Milliseconds(),
+		"message":     strings.TrimSuffix(rec.Message, "\n"),
+	}
+	if rec.Model != "" {
+		fields["model"] = rec.Model
+	}
+	if rec.ExitCode >= 0 {
+		fields["exit_code"] = rec.ExitCode
+	}
+	writeJSON(jsonOut[l.level], l.level, fields)
+}
+
+// componentLoggers returns the loggers for the errors and debugging
+// output of the component named by name, in the log format.
+func componentLoggers(name func() string) (errs, debug ephemera.Logger) {
+	errs = elog
+	debug = &componentLogger{name: name, level: logLevelDebug}
+	if logFormat == logFormatJSON {
+		return &scriptLogger{Logger: errs, level: logLevelError},
+			&scriptLogger{Logger: debug, level: logLevelDebug}
+	}
+	if journal.Enabled() {
+		return &journalLogger{Logger: errs, level: logLevelError},
+			&journalLogger{Logger: debug, level: logLevelDebug}
+	}
+	return errs, debug
+}
+
+// journalLogger is an ephemera.StructuredLogger sending script errors
+// and output to the systemd journal with their fields, for example
+// for 'journalctl COMPONENT=net.vyatta.eng.vci.example', and other
+// messages through Logger.
+type journalLogger struct {
+	ephemera.Logger
+	level string
+}
+
+func (l *journalLogger) LogScript(rec ephemera.ScriptRecord) {
+	if l.level != logLevelError &&
+		componentLog(rec.Component, l.level) == logging.discard {
+		return
+	}
+	vars := map[string]string{
+		"COMPONENT": rec.Component,
+		"OPERATION": rec.Operation,
+	}
+	if rec.Model != "" {
+		vars["MODEL"] = rec.Model
+	}
+	if l.level != logLevelError {
+		journal.Send("Output for "+rec.Operation+"\n"+rec.Message,
+			journal.PriDebug, vars)
+		return
+	}
+	if rec.ExitCode >= 0 {
+		vars["EXIT_CODE"] = strconv.Itoa(rec.ExitCode)
+	}
+	journal.Send("Error for "+rec.Operation+": "+rec.Message,
+		journal.PriErr, vars)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+)
+
+const (
+	logLevelError = "error"
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+
+	// logLevelDefault is accepted by the set-log-level RPC to return
+	// to the configured level.
+	logLevelDefault = "default"
+
+	defaultLogLevel = logLevelInfo
+)
+
+// flagLogLevel is the log level used when the configuration doesn't
+// set one.
+var flagLogLevel = defaultLogLevel
+
+func init() {
+	flag.StringVar(
+		&flagLogLevel,
+		"log-level",
+		defaultLogLevel,
+		"log level, "+logLevelError+", "+logLevelInfo+" or "+
+			logLevelDebug+", unless the configuration sets Logging/Level",
+	)
+}
+
+var errInvalidLogLevel = errors.New("log level must be one of " +
+	logLevelError + ", " + logLevelInfo + " or " + logLevelDebug)
+
+func checkLogLevel(level string) error {
+	switch level {
+	case logLevelError, logLevelInfo, logLevelDebug:
+		return nil
+	default:
+		return errInvalidLogLevel
+	}
+}
+
+func logLevelRank(level string) int {
+	switch level {
+	case logLevelError:
+		return 0
+	case logLevelInfo:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// logLevels tracks the global log level and any overrides for single
+// components. The global level is applied by discarding the output of
+// ilog and dlog; messages about a component are logged through
+// componentLog so that they follow its override.
+type logLevels struct {
+	once              sync.Once
+	infoOut, debugOut io.Writer
+	discard           *log.Logger
+	info, debug       *log.Logger
+
+	mu         sync.Mutex
+	global     string
+	components map[string]string
+}
+
+var logging = &logLevels{
+	global:     defaultLogLevel,
+	components: make(map[string]string),
+	discard:    log.New(ioutil.Discard, "", 0),
+}
+
+func (l *logLevels) init() {
+	l.once.Do(func() {
+		l.infoOut, l.debugOut = ilog.Writer(), dlog.Writer()
+		l.info = log.New(l.infoOut, "", 0)
+		l.debug = log.New(l.debugOut, "", 0)
+	})
+}
+
+// setLogLevel discards messages from the loggers below level. Errors
+// are always logged.
+func setLogLevel(level string) error {
+	err := checkLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logging.init()
+	logging.mu.Lock()
+	logging.global = level
+	logging.mu.Unlock()
+	ilog.SetOutput(logging.infoOut)
+	dlog.SetOutput(logging.debugOut)
+	switch level {
+	case logLevelError:
+		ilog.SetOutput(ioutil.Discard)
+		dlog.SetOutput(ioutil.Discard)
+	case logLevelInfo:
+		dlog.SetOutput(ioutil.Discard)
+	}
+	return nil
+}
+
+// setComponentLogLevel overrides the log level for messages about a
+// single component. An empty level removes the override.
+func setComponentLogLevel(name, level string) error {
+	if level != "" {
+		err := checkLogLevel(level)
+		if err != nil {
+			return err
+		}
+	}
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	if level == "" {
+		delete(logging.components, name)
+	} else {
+		logging.components[name] = level
+	}
+	return nil
+}
+
+// componentLog returns a logger for messages of the given level about
+// the named component, which discards them if the component's level,
+// or the global level if it has none, is less verbose.
+func componentLog(name, level string) *log.Logger {
+	logging.init()
+	logging.mu.Lock()
+	effective, ok := logging.components[name]
+	if !ok {
+		effective = logging.global
+	}
+	logging.mu.Unlock()
+	if logLevelRank(level) > logLevelRank(effective) {
+		return logging.discard
+	}
+	if level == logLevelDebug {
+		return logging.debug
+	}
+	return logging.info
+}
+
+// componentLogger logs messages about the component named by name
+// through componentLog, so that they follow its log level.
+type componentLogger struct {
+	name  func() string
+	level string
+}
+
+func (l *componentLogger) Printf(format string, v ...interface{}) {
+	componentLog(l.name(), l.level).Printf(format, v...)
+}
+
+func (l *componentLogger) Println(v ...interface{}) {
+	componentLog(l.name(), l.level).Println(v...)
+}
@@ -10,33 +10,47 @@ import (
 	"log"
 	"log/syslog"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	rfc7951 "github.com/danos/encoding/rfc7951/data"
 	"github.com/danos/ephemera"
 	"github.com/danos/vci"
 	"github.com/fsnotify/fsnotify"
-	"jsouthworth.net/go/dyn"
 	"jsouthworth.net/go/etm/agent"
 	"jsouthworth.net/go/etm/atom"
 	"jsouthworth.net/go/immutable/hashmap"
-	"jsouthworth.net/go/immutable/vector"
 )
 
 var (
 	elog        *log.Logger
+	ilog        *log.Logger
 	dlog        *log.Logger
-	instanceDir string
+	keystoreDir string
 )
 
+// componentInstanceFile is the name of the instance file within a
+// component directory.
+const componentInstanceFile = "instance"
+
 func init() {
 	elog, _ = syslog.NewLogger(syslog.LOG_ERR, 0)
+	ilog, _ = syslog.NewLogger(syslog.LOG_INFO, 0)
 	dlog, _ = syslog.NewLogger(syslog.LOG_DEBUG, 0)
-	flag.StringVar(
-		&instanceDir,
+	flag.Var(
+		instanceDirs,
 		"instance-dir",
-		"/lib/vci/ephemera/instances",
-		"directory with instance information",
+		"directory with instance information; may be repeated or "+
+			"colon separated, earlier directories take precedence",
+	)
+	flag.StringVar(
+		&keystoreDir,
+		"keystore-dir",
+		"/config/auth/ephemera",
+		"directory holding secrets referenced by SecretEnv keystore: keys",
 	)
 }
 
@@ -44,6 +58,49 @@ type component struct {
 	meta    *ephemera.Component
 	vci     vci.Component
 	started *agent.Agent
+	reason  *atom.Atom
+	result  *atom.Atom
+
+	// inProgress counts oneshot runs that are running or queued.
+	inProgress int32
+
+	// starting counts the activations under way.
+	starting int32
+
+	// lifecycle records the component's last activation and
+	// deactivation.
+	lifecycle *atom.Atom
+
+	// hash is meta's fingerprint, computed once as the definition
+	// never changes.
+	hash string
+
+	// owner is the component's owner, found once when it is loaded.
+	owner string
+
+	// documented holds the *ephemera.Component documenting c, as
+	// documentation can change without the definition changing.
+	documented *atom.Atom
+
+	// proxied is set while an OnDemand component is registered on
+	// the bus without having been activated. It is only used by the
+	// started agent.
+	proxied bool
+
+	// listening is set while the component's models are registered on
+	// the bus. listenerGen is advanced whenever its listener is
+	// started or stopped, so that watchers and restarts of an earlier
+	// one know to do nothing. listenerUp is when the listener was last
+	// started and listenerFailures counts its consecutive restarts.
+	// They are only used by the started agent.
+	listening        bool
+	listenerGen      uint64
+	listenerUp       time.Time
+	listenerFailures int
+
+	// listenerDrops counts the times the listener dropped off the
+	// bus.
+	listenerDrops uint64
 }
 
 func newComponent(meta *ephemera.Component, vci vci.Component) *component {
@@ -51,25 +108,124 @@ func newComponent(meta *ephemera.Component, vci vci.Component) *component {
 		meta:    meta,
 		vci:     vci,
 		started: agent.New(false),
+		reason:  atom.New(""),
+		result:  atom.New(""),
+
+		lifecycle: atom.New(&lifecycle{}),
+
+		hash:       meta.Hash(),
+		owner:      ownerOf(meta),
+		documented: atom.New(meta),
+	}
+}
+
+// sameDefinition reports whether c and other were read from
+// instances defining them alike. Their documentation may differ.
+func (c *component) sameDefinition(other *component) bool {
+	return c.hash == other.hash
+}
+
+// preserve returns prev, which c was found to define alike, to be
+// kept in c's place so that it keeps running, after taking on c's
+// documentation.
+func (c *component) preserve(prev *component) *component {
+	prev.documented.Reset(c.meta)
+	return prev
+}
+
+// docs returns the component whose documentation is to be shown for
+// c, that of the instance it was last read from.
+func (c *component) docs() *ephemera.Component {
+	return c.documented.Deref().(*ephemera.Component)
+}
+
+func loadComponent(file string) (*component, error) {
+	var c *component
+	name := func() string {
+		if c == nil {
+			return ""
+		}
+		return c.meta.Name()
+	}
+	errs, debug := componentLoggers(name)
+	meta, err := ephemera.New(
+		ephemera.From(file),
+		ephemera.WithLogger(errs, debug),
+		ephemera.WithKeystore(keystoreDir),
+		ephemera.WithConfigCacheDir(filepath.Join(stateDir, "config")),
+		ephemera.ResolveCommands(),
+		ephemera.OnScriptFinished(scriptFinished),
+		ephemera.OnRequest(func(*ephemera.Component) error {
+			return c.demand()
+		}),
+		ephemera.OnCircuitChange(
+			func(_ *ephemera.Component, state ephemera.CircuitState) {
+				c.circuitChanged(state)
+			}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBus(meta)
+	if err != nil {
+		return nil, err
 	}
+	c = newComponent(meta, createVCIComponent(meta))
+	return c, nil
 }
 
 func (c *component) Run() error {
+	if isShuttingDown() {
+		return errShuttingDown()
+	}
+	atomic.AddInt32(&c.starting, 1)
+	defer atomic.AddInt32(&c.starting, -1)
+	err := waitForUnits(c.meta.Name(), c.meta.AfterUnits())
+	if err != nil {
+		return err
+	}
+	if c.meta.Type() == ephemera.TypeOneshot {
+		return c.runOnce()
+	}
 	ch := make(chan error)
 	c.started.Send(func(isRunning bool) bool {
+		if !isRunning && isShuttingDown() {
+			// Shutdown may already have stopped the component.
+			ch <- errShuttingDown()
+			return isRunning
+		}
 		var err error
 		defer func() {
 			ch <- err
-			if !isRunning && err == nil {
-				dlog.Println("Started listener for", c.meta.Name())
+			if isRunning {
+				return
+			}
+			if err != nil {
+				recordEvent(newLifecycleEvent(eventFailed,
+					c.meta.Name(), err))
+				return
 			}
+			componentLog(c.meta.Name(), logLevelInfo).
+				Println("Started listener for", c.meta.Name())
+			recordEvent(newLifecycleEvent(eventStarted,
+				c.meta.Name(), nil))
 		}()
 		if isRunning {
 			return isRunning
 		}
-		c.meta.Start()
-		err = c.vci.Run()
+		begin := time.Now()
+		err = c.meta.Start()
+		if err != nil {
+			// The backend didn't start, so it isn't put on
+			// the bus.
+			c.activated(err)
+			return false
+		}
+		err = c.listen()
 		if err == nil {
+			c.activated(nil)
+			startup.recordActivation(c.meta.Name(), time.Since(begin))
+			c.reason.Reset("")
 			return true
 		}
 		return false
@@ -77,26 +233,173 @@ func (c *component) Run() error {
 	return <-ch
 }
 
+// runOnce runs a oneshot component's Start script to completion. The
+// component is never considered to be running.
+func (c *component) runOnce() error {
+	atomic.AddInt32(&c.inProgress, 1)
+	defer atomic.AddInt32(&c.inProgress, -1)
+	ch := make(chan error)
+	c.started.Send(func(bool) bool {
+		err := c.meta.Start()
+		c.activated(err)
+		if err != nil {
+			c.result.Reset(err.Error())
+			recordEvent(newLifecycleEvent(eventFailed,
+				c.meta.Name(), err))
+		} else {
+			c.result.Reset("success")
+			recordEvent(newLifecycleEvent(eventCompleted,
+				c.meta.Name(), nil))
+		}
+		ch <- err
+		return false
+	})
+	return <-ch
+}
+
+func (c *component) stopOnce(params map[string]string) error {
+	ch := make(chan error)
+	c.started.Send(func(bool) bool {
+		err := c.meta.StopWithParameters(params)
+		c.deactivated(err)
+		if err != nil {
+			recordEvent(newLifecycleEvent(eventFailed,
+				c.meta.Name(), err))
+		} else {
+			recordEvent(newLifecycleEvent(eventStopped,
+				c.meta.Name(), nil))
+		}
+		ch <- err
+		return false
+	})
+	return <-ch
+}
+
+// LastResult is the outcome of the last run of a oneshot component.
+func (c *component) LastResult() string {
+	return c.result.Deref().(string)
+}
+
+// Reason explains why the component is in its current state when
+// that was not the result of an explicit request.
+func (c *component) Reason() string {
+	return c.reason.Deref().(string)
+}
+
+func (c *component) circuitChanged(state ephemera.CircuitState) {
+	name := c.meta.Name()
+	componentLog(name, logLevelInfo).
+		Printf("Circuit for %s is now %s\n", name, state)
+	notifications.emit("circuit-state-changed", &circuitStateChanged{
+		Component: name,
+		State:     state.String(),
+	})
+	if state != ephemera.CircuitOpen {
+		return
+	}
+	recordEvent(newLifecycleEvent(eventFailed, name,
+		errors.New("circuit opened after repeated failures")))
+	if !ephemera.FeatureEnabled(ephemera.FeatureSupervision) {
+		return
+	}
+	// The circuit changes state from within a script invocation
+	// that may itself have been made by the component's listener,
+	// so act on the policy asynchronously. The circuit is reset
+	// first, as it would otherwise refuse the scripts the policy
+	// runs.
+	switch c.meta.FailurePolicy() {
+	case ephemera.FailureDeactivate:
+		goComponent(name, func() {
+			c.meta.ResetCircuit()
+			err := c.Stop()
+			if c.Running() {
+				elog.Printf("Error deactivating %s: %s\n", name, err)
+				return
+			}
+			if err != nil {
+				elog.Printf("Error stopping %s: %s\n", name, err)
+			}
+			c.reason.Reset("deactivated after repeated failures")
+		})
+	case ephemera.FailureRestart:
+		goComponent(name, func() {
+			if !c.Running() {
+				// Only running components are restarted, so
+				// that one whose Start keeps failing isn't
+				// restarted over and over.
+				return
+			}
+			c.meta.ResetCircuit()
+			err := c.Stop()
+			if c.Running() {
+				elog.Printf("Error restarting %s: %s\n", name, err)
+				return
+			}
+			if err != nil {
+				elog.Printf("Error stopping %s: %s\n", name, err)
+			}
+			if !settings().autoActivate {
+				c.reason.Reset("deactivated after repeated failures, " +
+					"auto-activation is disabled")
+				return
+			}
+			err = c.Run()
+			if err != nil {
+				elog.Printf("Error restarting %s: %s\n", name, err)
+				c.reason.Reset("restart after repeated failures " +
+					"failed: " + err.Error())
+				return
+			}
+			c.reason.Reset("restarted after repeated failures")
+		})
+	}
+}
+
 func (c *component) Running() bool {
 	return c.started.Deref().(bool)
 }
 
 func (c *component) Stop() error {
+	return c.StopWithParameters(nil)
+}
+
+// StopWithParameters deactivates the component, giving params to its
+// Stop script, after tearing down any sessions on it. The component is
+// taken off the bus even if the Stop script fails, whose error is
+// returned.
+func (c *component) StopWithParameters(params map[string]string) error {
+	sessions.stopAll(c)
+	if c.meta.Type() == ephemera.TypeOneshot {
+		return c.stopOnce(params)
+	}
 	ch := make(chan error)
 	c.started.Send(func(isRunning bool) bool {
 		var err error
 		defer func() {
 			ch <- err
-			if isRunning && err == nil {
-				dlog.Println("Stopped listener for", c.meta.Name())
+			if !isRunning {
+				return
 			}
+			if err != nil {
+				recordEvent(newLifecycleEvent(eventFailed,
+					c.meta.Name(), err))
+				return
+			}
+			componentLog(c.meta.Name(), logLevelInfo).
+				Println("Stopped listener for", c.meta.Name())
+			recordEvent(newLifecycleEvent(eventStopped,
+				c.meta.Name(), nil))
 		}()
 		if !isRunning {
 			return isRunning
 		}
-		c.meta.Stop()
-		err = c.vci.Stop()
+		stopErr := c.meta.StopWithParameters(params)
+		err = c.unlisten()
 		if err == nil {
+			// The component is off the bus even if its Stop
+			// script failed, but the failure is still reported.
+			c.deactivated(stopErr)
+			err = stopErr
 			return false
 		}
 		return true
@@ -104,37 +407,133 @@ func (c *component) Stop() error {
 	return <-ch
 }
 
-func readAllComponents(instanceDir string) *hashmap.Map {
+// readAllComponents reads the instance directories. Where a
+// component is defined more than once the definition in the earliest
+// directory is used, and within a directory the last file read.
+func readAllComponents(instanceDirs []string) (*hashmap.Map, *loadReport) {
+	report := &loadReport{}
+	files := make(map[string]string)
 	return hashmap.Empty().
 		Transform(func(cs *hashmap.TMap) *hashmap.TMap {
-			dir, err := ioutil.ReadDir(instanceDir)
-			if err != nil {
-				return cs
-			}
-			for _, fi := range dir {
-				if fi.IsDir() {
-					continue
-				}
-				name := instanceDir + "/" + fi.Name()
-				comp, err := ephemera.New(
-					ephemera.From(name),
-				)
-				if err != nil {
-					elog.Printf("%s: %s", name, err)
-					continue
-				}
-				cs = cs.Assoc(comp.Name(), newComponent(
-					comp,
-					createVCIComponent(comp),
-				))
+			// Read the directories in reverse so that
+			// definitions in earlier ones replace those in
+			// later ones.
+			for i := len(instanceDirs) - 1; i >= 0; i-- {
+				cs = readInstanceDir(instanceDirs[i], cs, files,
+					report)
 			}
 			return cs
-		})
+		}), report
+}
+
+func readInstanceDir(
+	instanceDir string,
+	cs *hashmap.TMap,
+	files map[string]string,
+	report *loadReport,
+) *hashmap.TMap {
+	dir, err := ioutil.ReadDir(instanceDir)
+	if err != nil {
+		report.reject(instanceDir, err)
+		return cs
+	}
+	err = checkOwnership(instanceDir)
+	if err != nil {
+		elog.Printf("Security: ignoring instances in %s: %s\n",
+			instanceDir, err)
+		report.reject(instanceDir, err)
+		return cs
+	}
+	var names []string
+	var fis []os.FileInfo
+	for _, fi := range dir {
+		name := instanceDir + "/" + fi.Name()
+		if isDropInDir(name, fi) {
+			// Read along with the instance file it extends.
+			continue
+		}
+		names = append(names, name)
+		fis = append(fis, fi)
+	}
+	// Merge in directory order so that the last file read still
+	// wins, however the files were parsed.
+	for _, res := range readInstancesConcurrently(names, fis) {
+		comp, file, err := res.comp, res.file, res.err
+		if err != nil {
+			report.reject(file, err)
+			continue
+		}
+		compName := comp.meta.Name()
+		if prev, ok := files[compName]; ok {
+			elog.Printf("%s: ignored, %s is also defined by %s\n",
+				prev, compName, file)
+			report.duplicate(compName, prev, file)
+		}
+		files[compName] = file
+		report.loaded(compName)
+		cs = cs.Assoc(compName, comp)
+	}
+	return cs
+}
+
+// readInstance loads the component defined by name, an instance file
+// or a component directory, returning the instance file it read.
+func readInstance(name string, fi os.FileInfo) (*component, string, error) {
+	if fi.IsDir() {
+		// A component directory holds its instance file along
+		// with any assets it needs.
+		err := checkOwnership(name)
+		if err != nil {
+			elog.Printf("Security: ignoring %s: %s\n", name, err)
+			return nil, name, err
+		}
+		name += "/" + componentInstanceFile
+	}
+	err := checkOwnership(name)
+	if err != nil {
+		elog.Printf("Security: ignoring %s: %s\n", name, err)
+		return nil, name, err
+	}
+	comp, err := loadComponent(name)
+	if err != nil {
+		elog.Printf("%s: %s", name, err)
+		return nil, name, err
+	}
+	err = checkSources(comp.meta)
+	if err != nil {
+		elog.Printf("Security: ignoring %s: %s\n", name, err)
+		return nil, name, err
+	}
+	return comp, name, nil
+}
+
+// isDropInDir reports whether fi is the drop-in directory of an
+// instance file beside it.
+func isDropInDir(name string, fi os.FileInfo) bool {
+	if !fi.IsDir() || !strings.HasSuffix(name, ephemera.DropInSuffix) {
+		return false
+	}
+	base, err := os.Stat(strings.TrimSuffix(name, ephemera.DropInSuffix))
+	return err == nil && !base.IsDir()
+}
+
+// readInstances reads the instance directories, recording the files
+// that could not be loaded.
+func readInstances(instanceDirs []string) (*hashmap.Map, *loadReport) {
+	cs, report := readAllComponents(instanceDirs)
+	broken.update(report, time.Now())
+	setInstanceDuplicates(report)
+	return cs, report
 }
 
 func createVCIComponent(comp *ephemera.Component) vci.Component {
-	c := vci.NewComponent(comp.Name())
-	for name, model := range comp.Models() {
+	c := busComponent(comp.Name(), comp.Bus())
+	models := comp.Models()
+	for _, name := range comp.ModelNames() {
+		model := models[name]
+		if !model.Enabled() {
+			continue
+		}
 		m := c.Model(name)
 		conf, ok := model.Config()
 		if ok {
@@ -148,181 +547,634 @@ func createVCIComponent(comp *ephemera.Component) vci.Component {
 		if !ok {
 			continue
 		}
-		for module, rpcs := range modules {
-			m.RPC(module, rpcs)
+		for _, module := range model.RPCModules() {
+			m.RPC(module, modules[module])
 		}
 	}
 	return c
 }
 
-func syncComponents(
-	key string,
-	a *atom.Atom,
-	old, new *hashmap.Map,
-) {
-	type action struct {
-		op     func() error
-		name   string
-		opname string
-	}
-	actions := vector.Empty().AsTransient()
-	old.Range(func(name string, comp *component) {
-		if new.Contains(name) {
-			return
-		}
-		actions = actions.Append(&action{
-			op:     comp.Stop,
-			name:   name,
-			opname: "stopp",
-		})
-	})
-	new.Range(func(name string, comp *component) {
-		val, ok := old.Find(name)
-		if !ok || dyn.Equal(comp.meta, val.(*component).meta) {
-			return
-		}
-		// If the meta components differ then we need to stop the
-		// old one. The new one will be started with activation
-		// on the next call. We can't start the new one now because
-		// if the component file were added during package installation
-		// the bus may not be setup correctly yet.
-		actions = actions.Append(&action{
-			op:     val.(*component).Stop,
-			name:   name,
-			opname: "stopp",
+// instanceSwapper returns the swap function rereading the instance
+// directories into the managed components.
+func instanceSwapper(instanceDirs []string) func(*hashmap.Map) *hashmap.Map {
+	return func(old *hashmap.Map) *hashmap.Map {
+		new, _ := readInstances(instanceDirs)
+		return preserveUnchanged(old, new)
+	}
+}
+
+// preserveUnchanged replaces the components in new that are defined
+// just as they were in old with the old ones, so that they keep
+// running.
+func preserveUnchanged(old, new *hashmap.Map) *hashmap.Map {
+	return new.Transform(func(t *hashmap.TMap) *hashmap.TMap {
+		t.Range(func(name string, comp *component) {
+			// If the meta components are the same,
+			// preserve the original vci component.
+			oldComp, ok := old.Find(name)
+			if !ok {
+				return
+			}
+			if comp.sameDefinition(oldComp.(*component)) {
+				t.Assoc(name, comp.preserve(oldComp.(*component)))
+			}
 		})
-	})
-	actions.Range(func(_ int, act *action) {
-		dlog.Printf("Instance sync: %sing %s\n", act.opname, act.name)
-		err := act.op()
-		if err == nil {
-			return
-		}
-		elog.Printf("Error %sing component on sync: %s: %s\n",
-			act.opname, act.name, err)
+		return t
 	})
 }
 
-func watchInstanceDirectory(
-	instanceDir string,
+func watchInstanceDirectories(
+	instanceDirs []string,
 	managedComponents *atom.Atom,
 ) {
-	swapper := func(old *hashmap.Map) *hashmap.Map {
-		new := readAllComponents(instanceDir)
-		new = new.Transform(func(t *hashmap.TMap) *hashmap.TMap {
-			t.Range(func(name string, comp *component) {
-				// If the meta components are the
-				// same, preserve the original vci
-				// component.
-				oldComp, ok := old.Find(name)
-				if !ok {
-					return
-				}
-				if dyn.Equal(comp.meta,
-					oldComp.(*component).meta) {
-					t.Assoc(name, oldComp)
-				}
-			})
-			return t
-		})
-		return new
+	swapper := instanceSwapper(instanceDirs)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		elog.Printf("watch instances: %s; polling every %s instead\n",
+			err, pollInterval)
+		pollInstanceDirectories(instanceDirs, managedComponents)
+		return
 	}
 
 	handleEvent := func(event fsnotify.Event) {
 		switch {
 		case event.Op&fsnotify.Chmod == fsnotify.Chmod:
+		case !concernsInstances(instanceDirs, event.Name):
+			// Something else changed beside the watched
+			// ancestor of an instance directory.
 		default:
+			for _, instanceDir := range instanceDirs {
+				if !within(instanceDir, event.Name) {
+					continue
+				}
+				// An instance directory, or one of
+				// its ancestors, was removed or
+				// created. Watches on removed
+				// directories go with them, so watch
+				// it again, or whatever remains of
+				// its path until it is recreated.
+				ilog.Printf("Instance directory %s changed, "+
+					"rescanning\n", instanceDir)
+				err := watchInstanceDir(watcher, instanceDir)
+				if err != nil {
+					elog.Println("watch instances:", err)
+				}
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create &&
+				inInstanceDir(instanceDirs, event.Name) {
+				// Watch component directories as they
+				// are added. Watches on removed
+				// directories go with them.
+				err := watchTree(watcher, event.Name)
+				if err != nil {
+					elog.Println("watch instances:", err)
+				}
+			}
+			if unit, ok := instanceUnit(instanceDirs,
+				event.Name); ok {
+				managedComponents.Swap(
+					reloadSwapper(instanceDirs, unit))
+				return
+			}
 			managedComponents.Swap(swapper)
 		}
 	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		panic(err)
+	for _, instanceDir := range instanceDirs {
+		err = watchInstanceDir(watcher, instanceDir)
+		if err != nil {
+			elog.Println("watch instances:", err)
+			health.setFatal(errors.New("watch instances: " +
+				err.Error()))
+		}
 	}
-	watcher.Add(instanceDir)
+
+	// Events are handled, and components synced, on a goroutine of
+	// their own, queued in order, so that the watcher keeps reading
+	// events and answering health pings however long a sync takes.
+	events := make(chan fsnotify.Event)
+	go func() {
+		for event := range events {
+			handleEvent(event)
+		}
+	}()
 
 	var ready sync.WaitGroup
 	ready.Add(1)
 	go func() {
+		pings := health.watcherPings()
 		ready.Done()
+		var pending []fsnotify.Event
 		for {
+			var next chan<- fsnotify.Event
+			var first fsnotify.Event
+			if len(pending) != 0 {
+				next, first = events, pending[0]
+			}
 			select {
 			case event := <-watcher.Events:
-				handleEvent(event)
+				health.setWatcherError(nil)
+				metrics.watcherEvent(event.Op.String())
+				pending = append(pending, event)
+			case next <- first:
+				pending = pending[1:]
 			case err := <-watcher.Errors:
 				elog.Println("watch instances:", err)
+				health.setWatcherError(err)
+				metrics.watcherError()
+			case done := <-pings:
+				close(done)
 			}
 		}
 	}()
 	ready.Wait()
 }
 
+// watchTree adds watches for root, if it is a directory, and every
+// directory beneath it.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root,
+		func(path string, fi os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				// It was removed before it could be
+				// watched.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			return watcher.Add(path)
+		})
+}
+
 type rpc struct {
 	managedComponents *atom.Atom
+	ha                *haMonitor
+}
+
+type scriptParameter struct {
+	Name  string `rfc7951:"name"`
+	Value string `rfc7951:"value"`
+}
+
+func parameterMap(params []scriptParameter) map[string]string {
+	out := make(map[string]string, len(params))
+	for _, param := range params {
+		out[param.Name] = param.Value
+	}
+	return out
+}
+
+// componentInput is the input of activate and deactivate.
+type componentInput struct {
+	Component      string            `rfc7951:"ephemerad-v1:component"`
+	Parameters     []scriptParameter `rfc7951:"ephemerad-v1:parameter"`
+	Session        string            `rfc7951:"ephemerad-v1:session"`
+	Lease          uint32            `rfc7951:"ephemerad-v1:lease"`
+	WaitForInstall bool              `rfc7951:"ephemerad-v1:wait-for-install"`
+}
+
+func (r *rpc) Activate(in *componentInput) (*sessionOutput, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	if in.WaitForInstall && !cs.Contains(in.Component) {
+		var err error
+		cs, err = installs.await(r.managedComponents, in.Component)
+		if err != nil {
+			return nil, rpcError(err, codeStartFailed)
+		}
+	}
+	found, ok := cs.Find(in.Component)
+	if !ok {
+		return nil, unknownComponent(in.Component)
+	}
+	comp := redirect(cs, found.(*component))
+	if comp.meta.Sessions() {
+		err := activate(comp, r.ha)
+		if err != nil {
+			return nil, rpcError(err, codeStartFailed)
+		}
+		s, err := sessions.start(comp,
+			parameterMap(in.Parameters),
+			time.Duration(in.Lease)*time.Second)
+		if err != nil {
+			return nil, rpcError(err, codeStartFailed)
+		}
+		return sessionOutputNew(s), nil
+	}
+	err := activateWithParameters(comp, r.ha, parameterMap(in.Parameters))
+	if err != nil {
+		return nil, rpcError(err, codeStartFailed)
+	}
+
+	return &sessionOutput{}, nil
+}
+
+// activate runs comp if policy allows it to be activated now, after
+// activating the components it Requires and waiting for those it
+// comes After. Start is given the parameters it was last given.
+func activate(comp *component, ha *haMonitor) error {
+	return activateChain(comp, ha, nil, nil)
+}
+
+// activateWithParameters activates comp as activate does, giving its
+// Start script params, which clear any given before if empty, once
+// everything else allows it to be started, so that a refused
+// activation leaves the parameters alone. A running component can't
+// be given other parameters, as they only reach Start.
+func activateWithParameters(
+	comp *component,
+	ha *haMonitor,
+	params map[string]string,
+) error {
+	return activateChain(comp, ha, params, nil)
 }
 
-func (r *rpc) Activate(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+// activateChain activates comp as activateWithParameters does, or as
+// activate does if params is nil, with chain holding the components
+// whose activation required comp's.
+func activateChain(
+	comp *component,
+	ha *haMonitor,
+	params map[string]string,
+	chain []string,
+) error {
+	warnDeprecated(comp)
+	err := admin.checkActivation(comp)
+	if err != nil {
+		return err
+	}
+	err = ha.checkActivation(comp)
+	if err != nil {
+		return err
+	}
+	err = comp.meta.CheckActiveWindow(time.Now())
+	if err != nil {
+		return err
+	}
+	if params != nil && comp.Running() &&
+		!sameParameters(comp.meta.Parameters(), params) {
+		return newOperatorError(msgParametersChanged,
+			"component", comp.meta.Name())
+	}
+	err = activateRequired(comp, ha, chain)
+	if err != nil {
+		return err
+	}
+	err = waitForComponents(comp,
+		ha.managedComponents.Deref().(*hashmap.Map))
+	if err != nil {
+		return err
+	}
+	if params != nil {
+		err = comp.meta.SetParameters(params)
+		if err != nil {
+			return err
+		}
+	}
+	return comp.Run()
+}
+
+// sameParameters reports whether a and b hold the same parameters.
+func sameParameters(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if v, ok := b[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *rpc) Deactivate(in *componentInput) (*rfc7951.Tree, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	comp, found := cs.Find(in.Component)
+	if !found {
+		return nil, unknownComponent(in.Component)
+	}
+
+	if in.Session != "" {
+		err := sessions.stop(comp.(*component), in.Session,
+			parameterMap(in.Parameters))
+		if err != nil {
+			return nil, rpcError(err, codeStopFailed)
+		}
+		return rfc7951.TreeNew(), nil
+	}
+
+	err := stopWithDependents(comp.(*component), cs,
+		parameterMap(in.Parameters))
+	if err != nil {
+		return nil, rpcError(err, codeStopFailed)
+	}
+
+	return rfc7951.TreeNew(), nil
+}
+
+type listComponentsOutput struct {
+	Components []componentState `rfc7951:"ephemerad-v1:component"`
+}
+
+func (r *rpc) ListComponents(
+	in *rfc7951.Tree,
+) (*listComponentsOutput, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	owner := in.At("/ephemerad-v1:owner").ToString()
+	out := &listComponentsOutput{}
+	for _, state := range componentStates(cs) {
+		if owner != "" && state.Owner != owner {
+			continue
+		}
+		out.Components = append(out.Components, state)
+	}
+	return out, nil
+}
+
+func (r *rpc) SetEnabled(in *rfc7951.Tree) (*rfc7951.Tree, error) {
 	name := in.At("/ephemerad-v1:component").ToString()
+	enabled := in.At("/ephemerad-v1:enabled").ToBool()
 
 	cs := r.managedComponents.Deref().(*hashmap.Map)
 	comp, found := cs.Find(name)
 	if !found {
-		return nil, errors.New("no component by the name " +
-			name + " found")
+		return nil, unknownComponent(name)
+	}
+
+	admin.set(name, enabled)
+	if !enabled {
+		err := stopWithDependents(comp.(*component), cs, nil)
+		if err != nil {
+			return nil, rpcError(err, codeStopFailed)
+		}
+	}
+
+	return rfc7951.TreeNew(), nil
+}
+
+func (r *rpc) SetLogLevel(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	level := in.At("/ephemerad-v1:level").ToString()
+	name := in.At("/ephemerad-v1:component").ToString()
+
+	if level == logLevelDefault {
+		level = ""
+	}
+	var err error
+	switch {
+	case name != "":
+		cs := r.managedComponents.Deref().(*hashmap.Map)
+		if !cs.Contains(name) {
+			return nil, unknownComponent(name)
+		}
+		err = setComponentLogLevel(name, level)
+	case level == "":
+		err = setLogLevel(settings().logLevel)
+	default:
+		err = setLogLevel(level)
 	}
-	err := comp.(*component).Run()
 	if err != nil {
-		return nil, err
+		return nil, rpcError(err, codeInvalidInput)
 	}
+	return rfc7951.TreeNew(), nil
+}
 
+func (r *rpc) SetHaState(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	r.ha.transition(parseHAState(in.At("/ephemerad-v1:state").ToString()))
 	return rfc7951.TreeNew(), nil
 }
 
-func (r *rpc) Deactivate(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+func (r *rpc) GetEvents(in *rfc7951.Tree) (*getEventsOutput, error) {
+	out := &getEventsOutput{}
+	evs := events.query(in.At("/ephemerad-v1:component").ToString(),
+		int(in.At("/ephemerad-v1:limit").ToUint32()))
+	for _, ev := range evs {
+		out.Events = append(out.Events, eventOutput{
+			Time:      ev.Time.Format(time.RFC3339),
+			Component: ev.Component,
+			Event:     ev.Event,
+			Error:     ev.Error,
+		})
+	}
+	return out, nil
+}
+
+const defaultLogLines = 20
+
+type getLogsOutput struct {
+	Lines []string `rfc7951:"ephemerad-v1:line"`
+}
+
+func (r *rpc) GetLogs(in *rfc7951.Tree) (*getLogsOutput, error) {
 	name := in.At("/ephemerad-v1:component").ToString()
+	lines := int(in.At("/ephemerad-v1:lines").ToUint32())
+	if lines == 0 {
+		lines = defaultLogLines
+	}
 
 	cs := r.managedComponents.Deref().(*hashmap.Map)
 	comp, found := cs.Find(name)
 	if !found {
-		return nil, errors.New("no component by the name " +
-			name + " found")
+		return nil, unknownComponent(name)
 	}
+	out, err := comp.(*component).meta.Logs(lines)
+	if err != nil {
+		return nil, rpcError(err, codeOperationFailed)
+	}
+	return &getLogsOutput{Lines: out}, nil
+}
 
-	err := comp.(*component).Stop()
+func (r *rpc) SetTrace(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	name := in.At("/ephemerad-v1:component").ToString()
+
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	comp, found := cs.Find(name)
+	if !found {
+		return nil, unknownComponent(name)
+	}
+	comp.(*component).meta.SetTrace(
+		in.At("/ephemerad-v1:enabled").ToBool())
+	return rfc7951.TreeNew(), nil
+}
+
+func (r *rpc) GetTrace(in *rfc7951.Tree) (*getTraceOutput, error) {
+	name := in.At("/ephemerad-v1:component").ToString()
+
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	comp, found := cs.Find(name)
+	if !found {
+		return nil, unknownComponent(name)
+	}
+	return newGetTraceOutput(comp.(*component).meta), nil
+}
+
+func (r *rpc) ExportState(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	doc, err := encodeRuntimeState(exportRuntimeState(cs))
 	if err != nil {
-		return nil, err
+		return nil, rpcError(err, codeOperationFailed)
+	}
+	return rfc7951.TreeNew().
+		Assoc("/ephemerad-v1:state", doc), nil
+}
+
+func (r *rpc) ImportState(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	st, err := decodeRuntimeState(
+		in.At("/ephemerad-v1:state").ToString())
+	if err != nil {
+		return nil, rpcError(err, codeInvalidInput)
+	}
+
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	err = importRuntimeState(cs, r.ha, st)
+	if err != nil {
+		return nil, rpcError(err, codeOperationFailed)
 	}
 
 	return rfc7951.TreeNew(), nil
 }
 
+func (r *rpc) Validate(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	path := in.At("/ephemerad-v1:file").ToString()
+	file, ok := instanceFile(path)
+	if !ok {
+		return nil, notInstanceFile(path)
+	}
+	_, err := ephemera.New(
+		ephemera.From(file),
+		ephemera.WithKeystore(keystoreDir),
+		ephemera.Strict(),
+		ephemera.ResolveCommands(),
+	)
+	out := rfc7951.TreeNew().Assoc("/ephemerad-v1:valid", err == nil)
+	if err != nil {
+		out = out.Assoc("/ephemerad-v1:message", err.Error())
+	}
+	return out, nil
+}
+
 func main() {
 	flag.Parse()
-	// Ensure that the instanceDir exists
-	err := os.MkdirAll(instanceDir, 0644)
+	err := setLogFormat(logFormat)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	if relay {
+		err = runRelay(os.Stdin, os.Stdout)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		return
+	}
+	err = checkLogLevel(flagLogLevel)
 	if err != nil {
 		elog.Fatal(err)
 	}
+	if messageCatalog != "" {
+		err = loadMessageCatalog(messageCatalog)
+		if err != nil {
+			elog.Fatal(err)
+		}
+	}
+	conf, err := loadDaemonConfig(configFile)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	fileSettings.Reset(conf)
+	applySettings(conf)
+	events.open(filepath.Join(stateDir, "events.log"))
+	syncPlans.open(filepath.Join(stateDir, "sync-plans.json"))
+	totals.open(filepath.Join(stateDir, "counters.json"))
+	err = startReaper()
+	if err != nil {
+		elog.Println("Unable to reap orphaned processes:", err)
+	}
+	if healthListen != "" {
+		err = serveHealth(healthListen)
+		if err != nil {
+			elog.Fatal(err)
+		}
+	}
+	if metricsListen != "" {
+		err = serveMetrics(metricsListen)
+		if err != nil {
+			elog.Fatal(err)
+		}
+	}
+
+	// Ensure that the instance directories exist
+	for _, instanceDir := range instanceDirs.dirs {
+		err = os.MkdirAll(instanceDir, 0644)
+		if err != nil {
+			elog.Fatal(err)
+		}
+	}
 
 	// Load initial components
-	components := readAllComponents(instanceDir)
+	begin := time.Now()
+	components, report := readInstances(instanceDirs.dirs)
+	startup.setLoad(time.Since(begin))
 	// Store them in an atomic variable
+	checkDependencies(components)
 	managedComponents := atom.New(components)
+	ha := newHAMonitor(managedComponents)
+	demandHA = ha
 	// Register a handler to sync them to the system when they change
-	managedComponents.Watch("sync-components", syncComponents)
+	managedComponents.Watch("sync-components", instanceSync(ha))
+	managedComponents.Watch("install-waiters", installs.sync)
 	// register file system watcher for component updates
-	watchInstanceDirectory(instanceDir, managedComponents)
+	watchInstanceDirectories(instanceDirs.dirs, managedComponents)
+
+	// Run scheduled oneshot components
+	sched := newScheduler(ha)
+	managedComponents.Watch("schedule-components", sched.sync)
+	publishMetrics(managedComponents)
+	sched.sync("schedule-components", managedComponents,
+		hashmap.Empty(), components)
+
+	// Deactivate components once they've been idle too long
+	idle := newIdleMonitor()
+	managedComponents.Watch("idle-components", idle.sync)
+	idle.sync("idle-components", managedComponents,
+		hashmap.Empty(), components)
 
 	// Component and datamodel for ephemerad.
+	begin = time.Now()
 	ephemerad := vci.NewComponent("net.vyatta.vci.ephemera")
-	ephemerad.Model("net.vyatta.vci.ephemera.v1").RPC("ephemerad-v1", &rpc{
-		managedComponents: managedComponents,
-	})
+	settingsConfig := newConfig()
+	ephemerad.Model("net.vyatta.vci.ephemera.v1").
+		Config(settingsConfig).
+		State(&state{
+			managedComponents: managedComponents,
+			ha:                ha,
+		}).
+		RPC("ephemerad-v1", &rpc{
+			managedComponents: managedComponents,
+			ha:                ha,
+		})
+	handleShutdown(managedComponents, ephemerad)
+	handleHangup(managedComponents, settingsConfig)
+	watchConfigFile(settingsConfig)
 	err = ephemerad.Run()
 	if err != nil {
 		elog.Fatal(err)
 	}
+	startup.setRegistration(time.Since(begin))
+	startup.logSummary(components.Length())
+	report.log()
+	notifications.setClient(ephemerad.Client())
+	notifications.emit("startup-summary", report)
+	health.setClient(ephemerad.Client())
+	startWatchdog()
+	proxyOnDemand(managedComponents.Deref().(*hashmap.Map))
+	if haNotification != "" {
+		err = ha.subscribe(ephemerad.Client(), haNotification)
+		if err != nil {
+			elog.Println("HA state subscription:", err)
+		}
+	}
 
 	// Wait (forever)
 	ephemerad.Wait()
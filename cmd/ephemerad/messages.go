@@ -0,0 +1,136 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+
+	"github.com/danos/ephemera"
+	"github.com/go-ini/ini"
+)
+
+// messageCode identifies an operator-facing error message in the
+// catalog, so that it can be translated. Each maps on to one of the
+// error codes callers branch on.
+type messageCode string
+
+const (
+	msgUnknownComponent  messageCode = "unknown-component"
+	msgNotInstalled      messageCode = "not-installed"
+	msgAdminDisabled     messageCode = "administratively-disabled"
+	msgHAStandby         messageCode = "ha-standby"
+	msgAutoActivationOff messageCode = "auto-activation-disabled"
+	msgUnitFailed        messageCode = "unit-failed"
+	msgUnitTimeout       messageCode = "unit-timeout"
+	msgBrokenInstance    messageCode = "broken-instance"
+	msgRequiredMissing   messageCode = "required-missing"
+	msgRequiredFailed    messageCode = "required-failed"
+	msgDependencyCycle   messageCode = "dependency-cycle"
+	msgDependencyTimeout messageCode = "dependency-timeout"
+	msgNotInstanceFile   messageCode = "not-instance-file"
+	msgShuttingDown      messageCode = "shutting-down"
+	msgParametersChanged messageCode = "parameters-changed"
+)
+
+// defaultMessages are the messages used for codes the catalog doesn't
+// translate. {name} in a message is replaced by the argument of that
+// name.
+var defaultMessages = map[messageCode]string{
+	msgUnknownComponent: "no component by the name {component} found",
+	msgNotInstalled: "no component by the name {component} was " +
+		"installed within {timeout}",
+	msgAdminDisabled: "component {component} is administratively " +
+		"disabled",
+	msgHAStandby: "component {component} may only be activated on " +
+		"the HA active router",
+	msgAutoActivationOff: "auto-activation is disabled",
+	msgUnitFailed: "component {component} requires {unit} which " +
+		"has failed",
+	msgUnitTimeout: "component {component} timed out waiting for {unit}",
+	msgBrokenInstance: "component {component} can't be loaded from " +
+		"{file}: {error}",
+	msgRequiredMissing: "component {component} requires {required} " +
+		"which is not installed",
+	msgRequiredFailed: "component {component} requires {required} " +
+		"which failed to activate: {error}",
+	msgDependencyCycle: "component {component} depends on itself: " +
+		"{cycle}",
+	msgDependencyTimeout: "component {component} timed out waiting " +
+		"for {dependency}",
+	msgNotInstanceFile: "{file} is not in an instance directory",
+	msgShuttingDown:    "ephemerad is shutting down",
+	msgParametersChanged: "component {component} is already running " +
+		"with other parameters",
+}
+
+var messageCatalog string
+
+func init() {
+	flag.StringVar(
+		&messageCatalog,
+		"message-catalog",
+		"",
+		"file translating operator-facing error messages, with a "+
+			"[Messages] section keyed by message code",
+	)
+}
+
+var catalog struct {
+	sync.Mutex
+	messages map[messageCode]string
+}
+
+// loadMessageCatalog reads the translations in file, replacing any
+// read before. Codes it doesn't mention keep their default message.
+func loadMessageCatalog(file string) error {
+	cfg, err := ini.Load(file)
+	if err != nil {
+		return err
+	}
+	messages := make(map[messageCode]string)
+	translations := make(map[string]string)
+	for _, key := range cfg.Section("Messages").Keys() {
+		messages[messageCode(key.Name())] = key.Value()
+		translations[key.Name()] = key.Value()
+	}
+	catalog.Lock()
+	catalog.messages = messages
+	catalog.Unlock()
+	// The errors ephemera returns itself are translated by the same
+	// catalog.
+	ephemera.SetMessages(translations)
+	return nil
+}
+
+// message returns the message for code from the catalog, with its
+// arguments, given as name and value pairs, filled in.
+func message(code messageCode, args ...string) string {
+	catalog.Lock()
+	msg, ok := catalog.messages[code]
+	catalog.Unlock()
+	if !ok {
+		msg = defaultMessages[code]
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		msg = strings.ReplaceAll(msg, "{"+args[i]+"}", args[i+1])
+	}
+	return msg
+}
+
+// operatorError is an error reported to operators, carrying the code
+// of its message.
+type operatorError struct {
+	code    messageCode
+	message string
+}
+
+func newOperatorError(code messageCode, args ...string) *operatorError {
+	return &operatorError{code: code, message: message(code, args...)}
+}
+
+func (e *operatorError) Error() string {
+	return e.message
+}
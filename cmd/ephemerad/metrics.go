@@ -0,0 +1,240 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danos/ephemera"
+)
+
+var metricsListen string
+
+func init() {
+	flag.StringVar(
+		&metricsListen,
+		"metrics-listen",
+		"",
+		"localhost address, such as 127.0.0.1:9101, to serve "+
+			"Prometheus metrics on at /metrics",
+	)
+}
+
+// scriptDurationBuckets are the upper bounds, in seconds, of the
+// script duration histogram's buckets.
+var scriptDurationBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60,
+}
+
+type scriptKey struct {
+	component string
+	operation string
+}
+
+// scriptHistogram counts a script's invocations by how long they took,
+// along with how many failed.
+type scriptHistogram struct {
+	buckets  []uint64
+	count    uint64
+	sum      float64
+	failures uint64
+}
+
+// metricsRegistry holds the metrics that aren't kept elsewhere in
+// ephemerad, for the Prometheus endpoint.
+type metricsRegistry struct {
+	mu            sync.Mutex
+	scripts       map[scriptKey]*scriptHistogram
+	watcherEvents map[string]uint64
+	watcherErrors uint64
+}
+
+var metrics = &metricsRegistry{
+	scripts:       make(map[scriptKey]*scriptHistogram),
+	watcherEvents: make(map[string]uint64),
+}
+
+// scriptFinished is the ephemera.OnScriptFinished function accounting
+// for each script invocation.
+func scriptFinished(
+	comp *ephemera.Component,
+	operation string,
+	d time.Duration,
+	err error,
+) {
+	totals.scriptFinished(comp.Name(), err)
+	metrics.observeScript(comp.Name(), operation, d, err)
+}
+
+func (m *metricsRegistry) observeScript(
+	component, operation string,
+	d time.Duration,
+	err error,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := scriptKey{component: component, operation: operation}
+	h, ok := m.scripts[key]
+	if !ok {
+		h = &scriptHistogram{
+			buckets: make([]uint64, len(scriptDurationBuckets)),
+		}
+		m.scripts[key] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range scriptDurationBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if err != nil {
+		h.failures++
+	}
+}
+
+// watcherEvent counts an event from the instance directory watcher,
+// by its operation, such as "create" or "write".
+func (m *metricsRegistry) watcherEvent(op string) {
+	m.mu.Lock()
+	m.watcherEvents[strings.ToLower(op)]++
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) watcherError() {
+	m.mu.Lock()
+	m.watcherErrors++
+	m.mu.Unlock()
+}
+
+// labelValue escapes v for use as a label value in the Prometheus
+// text format.
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).
+		Replace(v)
+}
+
+func (m *metricsRegistry) writeScripts(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]scriptKey, 0, len(m.scripts))
+	for key := range m.scripts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].component != keys[j].component {
+			return keys[i].component < keys[j].component
+		}
+		return keys[i].operation < keys[j].operation
+	})
+	labels := func(key scriptKey) string {
+		return fmt.Sprintf(`component="%s",operation="%s"`,
+			labelValue(key.component), labelValue(key.operation))
+	}
+
+	fmt.Fprintln(w, "# HELP ephemerad_script_duration_seconds "+
+		"How long components' scripts took to run.")
+	fmt.Fprintln(w, "# TYPE ephemerad_script_duration_seconds histogram")
+	for _, key := range keys {
+		h := m.scripts[key]
+		for i, le := range scriptDurationBuckets {
+			fmt.Fprintf(w, "ephemerad_script_duration_seconds_bucket"+
+				"{%s,le=\"%g\"} %d\n", labels(key), le, h.buckets[i])
+		}
+		fmt.Fprintf(w, "ephemerad_script_duration_seconds_bucket"+
+			"{%s,le=\"+Inf\"} %d\n", labels(key), h.count)
+		fmt.Fprintf(w, "ephemerad_script_duration_seconds_sum{%s} %g\n",
+			labels(key), h.sum)
+		fmt.Fprintf(w, "ephemerad_script_duration_seconds_count{%s} %d\n",
+			labels(key), h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP ephemerad_script_failures_total "+
+		"Component scripts that failed, such as Config/Set and "+
+		"Config/Check rejecting a configuration.")
+	fmt.Fprintln(w, "# TYPE ephemerad_script_failures_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "ephemerad_script_failures_total{%s} %d\n",
+			labels(key), m.scripts[key].failures)
+	}
+}
+
+func (m *metricsRegistry) writeWatcher(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ops := make([]string, 0, len(m.watcherEvents))
+	for op := range m.watcherEvents {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	fmt.Fprintln(w, "# HELP ephemerad_watcher_events_total "+
+		"Events from the instance directory watcher.")
+	fmt.Fprintln(w, "# TYPE ephemerad_watcher_events_total counter")
+	for _, op := range ops {
+		fmt.Fprintf(w, "ephemerad_watcher_events_total{op=\"%s\"} %d\n",
+			labelValue(op), m.watcherEvents[op])
+	}
+	fmt.Fprintln(w, "# HELP ephemerad_watcher_errors_total "+
+		"Errors from the instance directory watcher.")
+	fmt.Fprintln(w, "# TYPE ephemerad_watcher_errors_total counter")
+	fmt.Fprintf(w, "ephemerad_watcher_errors_total %d\n", m.watcherErrors)
+}
+
+// writeActivations writes the cumulative activation counters.
+func writeActivations(w *bufio.Writer) {
+	all := totals.all()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "# HELP ephemerad_activations_total "+
+		"Activations of each component.")
+	fmt.Fprintln(w, "# TYPE ephemerad_activations_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "ephemerad_activations_total{component=\"%s\"} %d\n",
+			labelValue(name), all[name].Activations)
+	}
+	fmt.Fprintln(w, "# HELP ephemerad_activation_failures_total "+
+		"Activations of each component whose Start script failed.")
+	fmt.Fprintln(w, "# TYPE ephemerad_activation_failures_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "ephemerad_activation_failures_total"+
+			"{component=\"%s\"} %d\n",
+			labelValue(name), all[name].ActivationFailures)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	metrics.writeScripts(bw)
+	writeActivations(bw)
+	metrics.writeWatcher(bw)
+	bw.Flush()
+}
+
+// serveMetrics serves Prometheus metrics at /metrics on addr, which
+// must be a loopback address as the endpoint is unauthenticated.
+func serveMetrics(addr string) error {
+	listener, err := listenLoopback("metrics", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	go func() {
+		err := http.Serve(listener, mux)
+		elog.Println("metrics endpoint:", err)
+	}()
+	return nil
+}
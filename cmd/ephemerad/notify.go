@@ -0,0 +1,45 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sync"
+
+	"github.com/danos/vci"
+)
+
+// notifier emits ephemerad-v1 notifications once ephemerad is
+// connected to the bus. Notifications raised before that are
+// logged and dropped.
+type notifier struct {
+	mu     sync.RWMutex
+	client *vci.Client
+}
+
+var notifications = &notifier{}
+
+func (n *notifier) setClient(client *vci.Client) {
+	n.mu.Lock()
+	n.client = client
+	n.mu.Unlock()
+}
+
+func (n *notifier) emit(name string, object interface{}) {
+	n.mu.RLock()
+	client := n.client
+	n.mu.RUnlock()
+	if client == nil {
+		dlog.Printf("Dropping %s notification, bus not ready\n", name)
+		return
+	}
+	err := client.Emit("ephemerad-v1", name, object)
+	if err != nil {
+		elog.Printf("Error emitting %s notification: %s\n", name, err)
+	}
+}
+
+type circuitStateChanged struct {
+	Component string `rfc7951:"ephemerad-v1:component"`
+	State     string `rfc7951:"ephemerad-v1:state"`
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// An OnDemand component's models are registered on the bus while it
+// is inactive, proxying for it, so that the first Config, State or RPC
+// request activates it through demand. Deactivating it returns it to
+// being proxied, and it only leaves the bus once its instance is
+// removed or changed.
+
+// demandHA is the HA monitor whose policy OnDemand components are
+// activated with.
+var demandHA *haMonitor
+
+// listen registers the component's models on the bus, unless they are
+// already registered as its proxy. It must be called by the started
+// agent.
+func (c *component) listen() error {
+	if c.proxied {
+		c.proxied = false
+		return nil
+	}
+	return c.startListener()
+}
+
+// unlisten unregisters the component's models from the bus, or leaves
+// them registered as its proxy if it is OnDemand, as they are once its
+// listener is restarted if it dropped off the bus. It must be called
+// by the started agent.
+func (c *component) unlisten() error {
+	if c.meta.OnDemand() {
+		c.proxied = c.listening
+		return nil
+	}
+	return c.stopListener()
+}
+
+// proxy registers an inactive OnDemand component's models on the
+// bus, to be activated on the first request.
+func (c *component) proxy() error {
+	if !c.meta.OnDemand() {
+		return nil
+	}
+	ch := make(chan error)
+	c.started.Send(func(isRunning bool) bool {
+		var err error
+		defer func() { ch <- err }()
+		if isRunning || c.proxied || isShuttingDown() {
+			return isRunning
+		}
+		err = c.startListener()
+		if err == nil {
+			c.proxied = true
+		}
+		return false
+	})
+	return <-ch
+}
+
+// unproxy unregisters an OnDemand component's models from the bus if
+// they are registered as its proxy, or are to be once its listener is
+// restarted.
+func (c *component) unproxy() error {
+	ch := make(chan error)
+	c.started.Send(func(isRunning bool) bool {
+		var err error
+		defer func() { ch <- err }()
+		if isRunning {
+			return isRunning
+		}
+		c.proxied = false
+		err = c.stopListener()
+		return false
+	})
+	return <-ch
+}
+
+// demand activates an inactive OnDemand component as it is asked to
+// serve a request.
+func (c *component) demand() error {
+	if !c.meta.OnDemand() || c.Running() {
+		return nil
+	}
+	name := c.meta.Name()
+	componentLog(name, logLevelInfo).Println("Activating", name,
+		"on demand")
+	err := activate(c, demandHA)
+	if err != nil {
+		elog.Printf("Error activating %s on demand: %s\n", name, err)
+	}
+	return rpcError(err, codeStartFailed)
+}
+
+// proxyOnDemand registers the proxies of the inactive OnDemand
+// components in cs.
+func proxyOnDemand(cs *hashmap.Map) {
+	cs.Range(func(name string, comp *component) {
+		err := comp.proxy()
+		if err != nil {
+			elog.Printf("Error registering %s for on-demand "+
+				"activation: %s\n", name, err)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/danos/ephemera"
+)
+
+// packageOwners caches the package owning each instance file, as
+// reported by dpkg, including that no package owns it. Failures to
+// ask dpkg aren't cached, so that they are retried at the next load.
+var packageOwners = struct {
+	mu     sync.Mutex
+	owners map[string]string
+}{owners: make(map[string]string)}
+
+// packageOwner returns the package that installed file, or "" if it
+// wasn't installed by a package or dpkg couldn't say.
+func packageOwner(file string) string {
+	packageOwners.mu.Lock()
+	owner, ok := packageOwners.owners[file]
+	packageOwners.mu.Unlock()
+	if ok {
+		return owner
+	}
+	out, err := execProcs.output(exec.Command("dpkg-query", "-S", file))
+	switch err := err.(type) {
+	case nil:
+		// The output is "package[, package...]: file".
+		colon := strings.Index(string(out), ": ")
+		if colon > 0 {
+			owner = string(out[:colon])
+		}
+	case *exec.ExitError:
+		// dpkg-query exits 1 for files no package owns.
+		if err.ExitCode() != 1 {
+			return ""
+		}
+	default:
+		return ""
+	}
+	packageOwners.mu.Lock()
+	packageOwners.owners[file] = owner
+	packageOwners.mu.Unlock()
+	return owner
+}
+
+// ownerOf returns the component's Owner, or the package that
+// installed its instance file if it doesn't declare one. It is found
+// when the component is loaded, as dpkg is too slow to ask whenever
+// the component's state is read.
+func ownerOf(meta *ephemera.Component) string {
+	if owner := meta.Owner(); owner != "" {
+		return owner
+	}
+	return packageOwner(meta.InstanceFile())
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"jsouthworth.net/go/etm/atom"
+)
+
+var pollInterval time.Duration
+
+func init() {
+	flag.DurationVar(
+		&pollInterval,
+		"poll-interval",
+		5*time.Second,
+		"how often to check the instance directories for changes "+
+			"when they can't be watched with inotify",
+	)
+}
+
+// pollInstanceDirectories rereads the instance directories whenever
+// their contents change, checking every poll interval, for systems
+// where inotify is unavailable, such as when its limits are
+// exhausted or in some containers.
+func pollInstanceDirectories(
+	instanceDirs []string,
+	managedComponents *atom.Atom,
+) {
+	if pollInterval <= 0 {
+		elog.Println("Instance directories won't be checked for " +
+			"changes, the poll interval isn't positive")
+		return
+	}
+	swapper := instanceSwapper(instanceDirs)
+	last := listInstanceDirs(instanceDirs)
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			listing := listInstanceDirs(instanceDirs)
+			if listing == last {
+				continue
+			}
+			last = listing
+			managedComponents.Swap(swapper)
+		}
+	}()
+}
+
+// listInstanceDirs describes every file beneath the instance
+// directories by its path, size, mode and modification time, so that
+// a change to any of them changes the listing.
+func listInstanceDirs(instanceDirs []string) string {
+	var b strings.Builder
+	for _, dir := range instanceDirs {
+		filepath.Walk(dir,
+			func(path string, fi os.FileInfo, err error) error {
+				if err != nil {
+					b.WriteString(path + " " + err.Error() + "\n")
+					return nil
+				}
+				b.WriteString(path + " " +
+					strconv.FormatInt(fi.Size(), 10) + " " +
+					fi.Mode().String() + " " +
+					strconv.FormatInt(fi.ModTime().UnixNano(), 10) +
+					"\n")
+				return nil
+			})
+	}
+	return b.String()
+}
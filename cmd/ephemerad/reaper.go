@@ -0,0 +1,172 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danos/ephemera"
+	"golang.org/x/sys/unix"
+)
+
+// orphanCounts records how many orphaned processes left behind by each
+// component's scripts have been reaped.
+type orphanCounts struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+var orphans = &orphanCounts{counts: make(map[string]uint64)}
+
+func (o *orphanCounts) add(name string) {
+	o.mu.Lock()
+	o.counts[name]++
+	o.mu.Unlock()
+}
+
+func (o *orphanCounts) get(name string) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.counts[name]
+}
+
+// trackedProcs tracks processes ephemerad starts itself, other than
+// component scripts, so that the reaper leaves them to be waited for.
+// Every command ephemerad runs must be started through it.
+type trackedProcs struct {
+	mu      sync.Mutex
+	running map[int]bool
+}
+
+var execProcs = &trackedProcs{running: make(map[int]bool)}
+
+func (p *trackedProcs) start(cmd *exec.Cmd) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	p.running[cmd.Process.Pid] = true
+	return nil
+}
+
+func (p *trackedProcs) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	p.mu.Lock()
+	delete(p.running, cmd.Process.Pid)
+	p.mu.Unlock()
+	return err
+}
+
+// output runs cmd, returning its standard output like cmd.Output.
+func (p *trackedProcs) output(cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := p.start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	err = p.wait(cmd)
+	return out.Bytes(), err
+}
+
+func (p *trackedProcs) contains(pid int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running[pid]
+}
+
+// startReaper makes ephemerad the subreaper for the scripts it runs,
+// so that processes they leave behind when double forking are
+// adopted by ephemerad rather than init, and reaps those orphans as
+// they exit so they don't accumulate as zombies. The scripts
+// themselves are left to be waited for by whoever started them, as
+// are any other children, which the reaper tells apart from orphans
+// by their being in ephemerad's own process group; scripts and hooks
+// lead their own.
+func startReaper() error {
+	err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, unix.SIGCHLD)
+	go func() {
+		for range sigs {
+			reapOrphans()
+		}
+	}()
+	return nil
+}
+
+func reapOrphans() {
+	self := os.Getpid()
+	group := unix.Getpgrp()
+	dir, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	for _, fi := range dir {
+		pid, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		ppid, pgid, zombie, ok := procStat(pid)
+		if !ok || ppid != self || !zombie || pgid == group ||
+			ephemera.IsScript(pid) || execProcs.contains(pid) {
+			continue
+		}
+		var status unix.WaitStatus
+		wpid, err := unix.Wait4(pid, &status, unix.WNOHANG, nil)
+		if err != nil || wpid != pid {
+			continue
+		}
+		owner, known := ephemera.ProcessGroupOwner(pgid)
+		if !known {
+			dlog.Printf("Reaped orphan %d exit status %d\n",
+				pid, status.ExitStatus())
+			continue
+		}
+		orphans.add(owner)
+		componentLog(owner, logLevelInfo).Printf(
+			"Reaped orphan %d of %s exit status %d\n",
+			pid, owner, status.ExitStatus())
+	}
+}
+
+// procStat returns the parent, process group and whether pid is a
+// zombie from /proc/pid/stat.
+func procStat(pid int) (int, int, bool, bool) {
+	buf, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, 0, false, false
+	}
+	// The command name may contain spaces so skip past it.
+	stat := string(buf)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, 0, false, false
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 3 {
+		return 0, 0, false, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, false, false
+	}
+	pgid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, false, false
+	}
+	return ppid, pgid, fields[0] == "Z", true
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// RefreshState drops the results a component has cached from its
+// scripts, so that operators who changed its backend by hand see fresh
+// data without waiting for them to expire. With populate set an
+// active component's Get scripts are run straight away, failing with
+// their errors. Inactive components only have their caches dropped,
+// as their backends may not be there to run the scripts against.
+func (r *rpc) RefreshState(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	name := in.At("/ephemerad-v1:component").ToString()
+	populate := in.At("/ephemerad-v1:populate").ToBool()
+
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	comp, found := cs.Find(name)
+	if !found {
+		return nil, unknownComponent(name)
+	}
+	componentLog(name, logLevelInfo).Println("Refreshing state of", name)
+	c := comp.(*component)
+	err := c.meta.Refresh(populate && c.Running())
+	if err != nil {
+		return nil, rpcError(err, codeOperationFailed)
+	}
+	return rfc7951.TreeNew(), nil
+}
@@ -0,0 +1,591 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/danos/mgmterror"
+	"github.com/danos/vci"
+)
+
+// VCI registers components on the system bus, whose address it takes
+// from DBUS_SYSTEM_BUS_ADDRESS. A component on another bus is
+// registered by a relay: ephemerad started again with -relay and that
+// variable set to the bus's address in the relay's environment alone.
+// ephemerad writes the layout of the component's models to the
+// relay's stdin, the relay registers them and then passes each Config,
+// State and RPC request it receives back over its stdout, to be served
+// by ephemerad just as for a component on the system bus. Closing its
+// stdin stops it.
+
+const (
+	relayRegistered = "registered"
+	relayFailed     = "failed"
+	relayConfigGet  = "config-get"
+	relayConfigSet  = "config-set"
+	relayConfigChk  = "config-check"
+	relayStateGet   = "state-get"
+	relayRPC        = "rpc"
+
+	systemBusEnv = "DBUS_SYSTEM_BUS_ADDRESS"
+)
+
+var relay bool
+
+func init() {
+	flag.BoolVar(
+		&relay,
+		"relay",
+		false,
+		"register a component on another bus for the ephemerad that "+
+			"started this one; not to be used directly",
+	)
+}
+
+// relayLayout is the layout of the models of the component a relay
+// registers.
+type relayLayout struct {
+	Component string             `json:"component"`
+	Models    []relayModelLayout `json:"models"`
+}
+
+type relayModelLayout struct {
+	Name   string              `json:"name"`
+	Config bool                `json:"config,omitempty"`
+	State  bool                `json:"state,omitempty"`
+	RPCs   map[string][]string `json:"rpcs,omitempty"`
+}
+
+// relayRequest is a request the relay received, or the outcome of its
+// registration.
+type relayRequest struct {
+	ID     uint64 `json:"id"`
+	Op     string `json:"op"`
+	Model  string `json:"model,omitempty"`
+	Module string `json:"module,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Meta   []byte `json:"meta,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+type relayReply struct {
+	ID    uint64      `json:"id"`
+	Data  []byte      `json:"data,omitempty"`
+	Error *relayError `json:"error,omitempty"`
+}
+
+// relayError carries an error back to the relay, keeping a management
+// error's type, tag and app-tag for the caller.
+type relayError struct {
+	Message string               `json:"message"`
+	Mgmt    *mgmterror.MgmtError `json:"mgmt,omitempty"`
+}
+
+func newRelayError(err error) *relayError {
+	if err == nil {
+		return nil
+	}
+	return &relayError{Message: err.Error(), Mgmt: mgmtErrorOf(err)}
+}
+
+func (e *relayError) err() error {
+	switch {
+	case e == nil:
+		return nil
+	case e.Mgmt != nil:
+		return e.Mgmt
+	}
+	return errors.New(e.Message)
+}
+
+// relayComponent is the VCI component of a component on the bus at
+// addr, registered by a relay each time it is run.
+type relayComponent struct {
+	name, addr string
+	models     []*relayModel
+
+	mu  sync.Mutex
+	run *relayRun
+}
+
+// relayRun is a relay started by Run.
+type relayRun struct {
+	stdin  io.Closer
+	exited chan struct{}
+	err    error
+}
+
+func newRelayComponent(name, addr string) *relayComponent {
+	return &relayComponent{name: name, addr: addr}
+}
+
+func (c *relayComponent) Model(name string) vci.Model {
+	m := &relayModel{name: name}
+	c.models = append(c.models, m)
+	return m
+}
+
+func (c *relayComponent) Run() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "-relay")
+	cmd.Env = relayEnv(os.Environ(), c.addr)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	return c.attach(stdout, stdin, cmd.Wait)
+}
+
+// attach has the relay reading from stdin and writing to stdout
+// register the component's models, and then serves its requests
+// until it exits, which wait waits for.
+func (c *relayComponent) attach(
+	stdout io.Reader,
+	stdin io.WriteCloser,
+	wait func() error,
+) error {
+	dec := json.NewDecoder(stdout)
+	enc := json.NewEncoder(stdin)
+	err := enc.Encode(c.layout())
+	if err == nil {
+		var req relayRequest
+		err = dec.Decode(&req)
+		switch {
+		case err != nil:
+		case req.Op == relayFailed:
+			err = errors.New(string(req.Data))
+		case req.Op != relayRegistered:
+			err = errors.New("relay for " + c.name +
+				" sent " + req.Op + " before registering")
+		}
+	}
+	if err != nil {
+		stdin.Close()
+		wait()
+		return err
+	}
+
+	run := &relayRun{stdin: stdin, exited: make(chan struct{})}
+	c.mu.Lock()
+	c.run = run
+	c.mu.Unlock()
+	go func() {
+		c.serve(dec, enc)
+		run.err = wait()
+		close(run.exited)
+	}()
+	return nil
+}
+
+func (c *relayComponent) Wait() error {
+	run := c.current()
+	if run == nil {
+		return nil
+	}
+	<-run.exited
+	return run.err
+}
+
+func (c *relayComponent) Stop() error {
+	run := c.current()
+	if run == nil {
+		return nil
+	}
+	run.stdin.Close()
+	<-run.exited
+	return nil
+}
+
+// Client returns nil as the component has no connection of its own.
+func (c *relayComponent) Client() *vci.Client {
+	return nil
+}
+
+func (c *relayComponent) current() *relayRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.run
+}
+
+func (c *relayComponent) layout() *relayLayout {
+	layout := &relayLayout{Component: c.name}
+	for _, m := range c.models {
+		ml := relayModelLayout{
+			Name:   m.name,
+			Config: m.config != nil,
+			State:  m.state != nil,
+		}
+		for module, rpcs := range m.rpcs {
+			if ml.RPCs == nil {
+				ml.RPCs = make(map[string][]string)
+			}
+			for name := range rpcs {
+				ml.RPCs[module] = append(ml.RPCs[module], name)
+			}
+			sort.Strings(ml.RPCs[module])
+		}
+		layout.Models = append(layout.Models, ml)
+	}
+	return layout
+}
+
+// serve serves the relay's requests until it stops sending them.
+func (c *relayComponent) serve(dec *json.Decoder, enc *json.Encoder) {
+	var mu sync.Mutex
+	for {
+		req := new(relayRequest)
+		err := dec.Decode(req)
+		if err != nil {
+			return
+		}
+		go func() {
+			out, err := c.call(req)
+			reply := &relayReply{
+				ID:    req.ID,
+				Data:  out,
+				Error: newRelayError(err),
+			}
+			mu.Lock()
+			enc.Encode(reply)
+			mu.Unlock()
+		}()
+	}
+}
+
+// call serves req with the component's models.
+func (c *relayComponent) call(req *relayRequest) ([]byte, error) {
+	var m *relayModel
+	for _, model := range c.models {
+		if model.name == req.Model {
+			m = model
+		}
+	}
+	if m == nil {
+		return nil, errors.New("unknown model " + req.Model)
+	}
+	switch req.Op {
+	case relayConfigGet:
+		return callMethod(m.config, "Get")
+	case relayConfigSet:
+		return callMethod(m.config, "Set", req.Data)
+	case relayConfigChk:
+		return callMethod(m.config, "Check", req.Data)
+	case relayStateGet:
+		return callMethod(m.state, "Get")
+	case relayRPC:
+		fn := m.rpcs[req.Module][req.Name]
+		return callFunc(reflect.ValueOf(fn), req.Meta, req.Data)
+	}
+	return nil, errors.New("unknown request " + req.Op)
+}
+
+// relayModel records the objects a model is registered with, which
+// serve the relay's requests.
+type relayModel struct {
+	name          string
+	config, state interface{}
+	rpcs          map[string]map[string]interface{}
+}
+
+func (m *relayModel) Config(object interface{}) vci.Model {
+	m.config = object
+	return m
+}
+
+func (m *relayModel) State(object interface{}) vci.Model {
+	m.state = object
+	return m
+}
+
+func (m *relayModel) RPC(module string, object interface{}) vci.Model {
+	rpcs, ok := object.(map[string]interface{})
+	if !ok {
+		return m
+	}
+	if m.rpcs == nil {
+		m.rpcs = make(map[string]map[string]interface{})
+	}
+	m.rpcs[module] = rpcs
+	return m
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callMethod calls object's method with encoded arguments, returning
+// its encoded result and error.
+func callMethod(
+	object interface{},
+	method string,
+	args ...[]byte,
+) ([]byte, error) {
+	if object == nil {
+		return nil, errors.New(method + " is not implemented")
+	}
+	return callFunc(reflect.ValueOf(object).MethodByName(method), args...)
+}
+
+// callFunc calls fn with the last of the encoded arguments it takes,
+// returning its encoded result and error.
+func callFunc(fn reflect.Value, args ...[]byte) ([]byte, error) {
+	if !fn.IsValid() || fn.Kind() != reflect.Func ||
+		fn.Type().NumIn() > len(args) {
+		return nil, errors.New("not implemented")
+	}
+	t := fn.Type()
+	in := make([]reflect.Value, t.NumIn())
+	args = args[len(args)-len(in):]
+	for i := range in {
+		in[i] = reflect.ValueOf(args[i]).Convert(t.In(i))
+	}
+	var out []byte
+	var err error
+	for _, v := range fn.Call(in) {
+		switch {
+		case v.Type().Implements(errorType):
+			if !v.IsNil() {
+				err = v.Interface().(error)
+			}
+		case v.Kind() == reflect.Slice:
+			out = v.Bytes()
+		}
+	}
+	return out, err
+}
+
+// relayEnv is env with the system bus at addr.
+func relayEnv(env []string, addr string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, systemBusEnv+"=") {
+			out = append(out, kv)
+		}
+	}
+	return append(out, systemBusEnv+"="+addr)
+}
+
+// runRelay registers the component whose layout is read from stdin,
+// passing its requests back over stdout, until stdin is closed or it
+// drops off the bus.
+func runRelay(stdin io.Reader, stdout io.Writer) error {
+	dec := json.NewDecoder(stdin)
+	var layout relayLayout
+	err := dec.Decode(&layout)
+	if err != nil {
+		return err
+	}
+	r := &relayClient{
+		enc:     json.NewEncoder(stdout),
+		pending: make(map[uint64]chan *relayReply),
+	}
+	c := newVCIComponent(layout.Component, "")
+	r.register(c, &layout)
+	err = c.Run()
+	if err != nil {
+		r.send(&relayRequest{Op: relayFailed, Data: []byte(err.Error())})
+		return err
+	}
+	err = r.send(&relayRequest{Op: relayRegistered})
+	if err != nil {
+		c.Stop()
+		return err
+	}
+	go func() {
+		r.receive(dec)
+		c.Stop()
+	}()
+	return c.Wait()
+}
+
+// relayClient passes the requests a relay receives back to ephemerad.
+type relayClient struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	nextID  uint64
+	pending map[uint64]chan *relayReply
+	closed  bool
+}
+
+// register registers the models of layout with c, as objects passing
+// their requests back to ephemerad.
+func (r *relayClient) register(c vci.Component, layout *relayLayout) {
+	for _, ml := range layout.Models {
+		m := c.Model(ml.Name)
+		if ml.Config {
+			m.Config(&relayConfig{r: r, model: ml.Name})
+		}
+		if ml.State {
+			m.State(&relayState{r: r, model: ml.Name})
+		}
+		for module, names := range ml.RPCs {
+			rpcs := make(map[string]interface{})
+			for _, name := range names {
+				rpcs[name] = r.rpc(ml.Name, module, name)
+			}
+			m.RPC(module, rpcs)
+		}
+	}
+}
+
+func (r *relayClient) send(req *relayRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(req)
+}
+
+// call passes req back to ephemerad and waits for its reply.
+func (r *relayClient) call(req *relayRequest) (relayData, error) {
+	ch := make(chan *relayReply, 1)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return relayData{}, errors.New("ephemerad has stopped the relay")
+	}
+	r.nextID++
+	req.ID = r.nextID
+	r.pending[req.ID] = ch
+	err := r.enc.Encode(req)
+	if err != nil {
+		delete(r.pending, req.ID)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return relayData{}, err
+	}
+	reply, ok := <-ch
+	if !ok {
+		return relayData{}, errors.New("ephemerad has stopped the relay")
+	}
+	if reply.Data == nil {
+		reply.Data = []byte{}
+	}
+	return relayData(reply.Data), reply.Error.err()
+}
+
+// receive hands the replies read from dec to their callers until
+// ephemerad stops sending them, when any still waiting fail.
+func (r *relayClient) receive(dec *json.Decoder) {
+	for {
+		reply := new(relayReply)
+		err := dec.Decode(reply)
+		if err != nil {
+			break
+		}
+		r.mu.Lock()
+		ch, ok := r.pending[reply.ID]
+		delete(r.pending, reply.ID)
+		r.mu.Unlock()
+		if ok {
+			ch <- reply
+		}
+	}
+	r.mu.Lock()
+	r.closed = true
+	for id, ch := range r.pending {
+		delete(r.pending, id)
+		close(ch)
+	}
+	r.mu.Unlock()
+}
+
+func (r *relayClient) rpc(
+	model, module, name string,
+) func(meta, in relayData) (relayData, error) {
+	return func(meta, in relayData) (relayData, error) {
+		return r.call(&relayRequest{
+			Op:     relayRPC,
+			Model:  model,
+			Module: module,
+			Name:   name,
+			Meta:   meta,
+			Data:   in,
+		})
+	}
+}
+
+type relayConfig struct {
+	r     *relayClient
+	model string
+}
+
+func (c *relayConfig) Get() relayData {
+	out, _ := c.r.call(&relayRequest{Op: relayConfigGet, Model: c.model})
+	return out
+}
+
+func (c *relayConfig) Set(in relayData) error {
+	_, err := c.r.call(&relayRequest{
+		Op:    relayConfigSet,
+		Model: c.model,
+		Data:  in,
+	})
+	return err
+}
+
+func (c *relayConfig) Check(in relayData) error {
+	_, err := c.r.call(&relayRequest{
+		Op:    relayConfigChk,
+		Model: c.model,
+		Data:  in,
+	})
+	return err
+}
+
+type relayState struct {
+	r     *relayClient
+	model string
+}
+
+func (s *relayState) Get() relayData {
+	out, _ := s.r.call(&relayRequest{Op: relayStateGet, Model: s.model})
+	return out
+}
+
+// relayData is encoded data passed through the relay as it is.
+type relayData []byte
+
+func (s *relayData) UnmarshalJSON(data []byte) error {
+	*s = relayData(data)
+	return nil
+}
+
+func (s relayData) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
+
+func (s *relayData) UnmarshalRFC7951(data []byte) error {
+	*s = relayData(data)
+	return nil
+}
+
+func (s relayData) MarshalRFC7951() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
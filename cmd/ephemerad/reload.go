@@ -0,0 +1,135 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/danos/ephemera"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// instanceDuplicates is set while the last full read of the instance
+// directories found components defined more than once. Which
+// definition wins then depends on every directory, so changes are
+// handled by reading them all again.
+var instanceDuplicates int32
+
+func setInstanceDuplicates(report *loadReport) {
+	var dups int32
+	if len(report.Duplicates) > 0 {
+		dups = 1
+	}
+	atomic.StoreInt32(&instanceDuplicates, dups)
+}
+
+// instanceUnit returns the instance file or component directory,
+// directly within an instance directory, that a change to path
+// affects.
+func instanceUnit(instanceDirs []string, path string) (string, bool) {
+	for _, dir := range instanceDirs {
+		if path == dir || !within(path, dir) {
+			continue
+		}
+		rel := strings.TrimPrefix(path, dir+"/")
+		unit := filepath.Join(dir, strings.SplitN(rel, "/", 2)[0])
+		if !strings.HasSuffix(unit, ephemera.DropInSuffix) {
+			return unit, true
+		}
+		// A drop-in directory belongs to the instance file
+		// beside it, unless it is itself a component directory.
+		_, err := os.Stat(filepath.Join(unit, componentInstanceFile))
+		if err == nil {
+			return unit, true
+		}
+		return strings.TrimSuffix(unit, ephemera.DropInSuffix), true
+	}
+	return "", false
+}
+
+// inheritedFrom reports whether unit is, or holds, an instance file
+// that a component in cs inherits from through BaseInstance, whose
+// definition changes along with it.
+func inheritedFrom(cs *hashmap.Map, unit string) bool {
+	found := false
+	cs.Range(func(name string, comp *component) {
+		for _, base := range comp.meta.Bases() {
+			if within(base, unit) {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// reloadSwapper returns the swap function rereading only unit, an
+// instance file or component directory, into the managed components.
+// It falls back to reading the instance directories in full when
+// the change may affect other components, such as those inheriting
+// from unit.
+func reloadSwapper(
+	instanceDirs []string,
+	unit string,
+) func(*hashmap.Map) *hashmap.Map {
+	full := instanceSwapper(instanceDirs)
+	return func(old *hashmap.Map) *hashmap.Map {
+		new, ok := reloadUnit(old, unit)
+		if !ok {
+			return full(old)
+		}
+		return new
+	}
+}
+
+func reloadUnit(old *hashmap.Map, unit string) (*hashmap.Map, bool) {
+	if atomic.LoadInt32(&instanceDuplicates) != 0 ||
+		checkOwnership(filepath.Dir(unit)) != nil ||
+		inheritedFrom(old, unit) {
+		return nil, false
+	}
+	var prev *component
+	old.Range(func(name string, comp *component) {
+		file := comp.meta.InstanceFile()
+		if file == unit ||
+			file == filepath.Join(unit, componentInstanceFile) {
+			prev = comp
+		}
+	})
+	new := old
+	if prev != nil {
+		new = new.Delete(prev.meta.Name())
+	}
+	fi, err := os.Stat(unit)
+	if os.IsNotExist(err) {
+		broken.clear(unit)
+		broken.clear(filepath.Join(unit, componentInstanceFile))
+		return new, true
+	}
+	if err != nil {
+		return nil, false
+	}
+	if isDropInDir(unit, fi) {
+		return nil, false
+	}
+	comp, file, err := readInstance(unit, fi)
+	if err != nil {
+		broken.reject(file, err, time.Now())
+		return new, true
+	}
+	broken.clear(file)
+	name := comp.meta.Name()
+	if new.Contains(name) {
+		// Also defined by another file.
+		return nil, false
+	}
+	if prev != nil && comp.sameDefinition(prev) {
+		// Preserve the original vci component.
+		comp = comp.preserve(prev)
+	}
+	return new.Assoc(name, comp), true
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadBaseInstance(t *testing.T) {
+	dir := t.TempDir()
+	prevStateDir := stateDir
+	defer func() { stateDir = prevStateDir }()
+	stateDir = t.TempDir()
+	prevElog := elog
+	defer func() { elog = prevElog }()
+	elog = log.New(ioutil.Discard, "", 0)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	const (
+		baseName    = "net.vyatta.eng.vci.ephemeral.base"
+		derivedName = "net.vyatta.eng.vci.ephemeral.derived"
+	)
+	base := write(baseName+".instance", "[Component]\n"+
+		"Name="+baseName+"\n"+
+		"Start=/bin/true\n")
+	write(derivedName+".instance", "[Component]\n"+
+		"BaseInstance="+baseName+".instance\n"+
+		"Name="+derivedName+"\n")
+
+	instanceDirs := []string{dir}
+	old, _ := readInstances(instanceDirs)
+	prev, ok := old.Find(derivedName)
+	if !ok {
+		t.Fatal("expected", derivedName, "to be loaded")
+	}
+
+	write(baseName+".instance", "[Component]\n"+
+		"Name="+baseName+"\n"+
+		"Start=/bin/false\n")
+	unit, ok := instanceUnit(instanceDirs, base)
+	if !ok || unit != base {
+		t.Fatalf("expected unit %s, got %s", base, unit)
+	}
+	new := reloadSwapper(instanceDirs, unit)(old)
+	val, ok := new.Find(derivedName)
+	if !ok {
+		t.Fatal("expected", derivedName, "to still be loaded")
+	}
+	if val.(*component).sameDefinition(prev.(*component)) {
+		t.Error("expected", derivedName, "to be reloaded with the "+
+			"changed base")
+	}
+
+	err := os.Remove(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new = reloadSwapper(instanceDirs, unit)(new)
+	if new.Contains(derivedName) || new.Contains(baseName) {
+		t.Error("expected components to go along with their base")
+	}
+}
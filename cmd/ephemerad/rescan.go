@@ -0,0 +1,24 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// Rescan rereads every instance directory, as a change to them would,
+// for when the change went unnoticed, such as on NFS or overlayfs or
+// after an editor replaced a file in a way inotify didn't report.
+// Components whose definitions are unchanged keep running.
+func (r *rpc) Rescan(in *rfc7951.Tree) (*loadReport, error) {
+	ilog.Println("Rescanning instance directories on request")
+	var report *loadReport
+	r.managedComponents.Swap(func(old *hashmap.Map) *hashmap.Map {
+		var new *hashmap.Map
+		new, report = readInstances(instanceDirs.dirs)
+		return preserveUnchanged(old, new)
+	})
+	return report, nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/danos/ephemera"
+)
+
+// goroutineCounts tracks the goroutines ephemerad runs on behalf of
+// each component, so that leaks can be attributed.
+type goroutineCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var goroutines = &goroutineCounts{counts: make(map[string]int)}
+
+// goComponent runs fn in a new goroutine accounted to the component.
+func goComponent(name string, fn func()) {
+	goroutines.mu.Lock()
+	goroutines.counts[name]++
+	goroutines.mu.Unlock()
+	go func() {
+		defer func() {
+			goroutines.mu.Lock()
+			goroutines.counts[name]--
+			if goroutines.counts[name] == 0 {
+				delete(goroutines.counts, name)
+			}
+			goroutines.mu.Unlock()
+		}()
+		fn()
+	}()
+}
+
+func (g *goroutineCounts) snapshot() map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[string]int, len(g.counts))
+	for name, n := range g.counts {
+		out[name] = n
+	}
+	return out
+}
+
+type componentResources struct {
+	Name       string `rfc7951:"name"`
+	Processes  uint32 `rfc7951:"processes"`
+	Goroutines uint32 `rfc7951:"goroutines"`
+}
+
+type resourcesState struct {
+	Processes  uint32               `rfc7951:"processes"`
+	Pipes      uint32               `rfc7951:"pipes"`
+	OpenFiles  uint32               `rfc7951:"open-files"`
+	Goroutines uint32               `rfc7951:"goroutines"`
+	Components []componentResources `rfc7951:"component"`
+}
+
+// resourceState reports the processes, pipes and goroutines currently
+// held, in total and by component.
+func resourceState() resourcesState {
+	usage := ephemera.Resources()
+	perGoroutine := goroutines.snapshot()
+	out := resourcesState{
+		Processes:  uint32(usage.Processes),
+		Pipes:      uint32(usage.Pipes),
+		OpenFiles:  uint32(openFiles()),
+		Goroutines: uint32(runtime.NumGoroutine()),
+	}
+	names := make(map[string]bool)
+	for name := range usage.Components {
+		names[name] = true
+	}
+	for name := range perGoroutine {
+		names[name] = true
+	}
+	for name := range names {
+		out.Components = append(out.Components, componentResources{
+			Name:       name,
+			Processes:  uint32(usage.Components[name]),
+			Goroutines: uint32(perGoroutine[name]),
+		})
+	}
+	sort.Slice(out.Components, func(i, j int) bool {
+		return out.Components[i].Name < out.Components[j].Name
+	})
+	return out
+}
+
+func openFiles() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+	return len(fds)
+}
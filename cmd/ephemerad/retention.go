@@ -0,0 +1,54 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// retention bounds how much of a history ephemerad keeps, so that it
+// can't grow without limit on routers with little flash. A zero bound
+// is no limit.
+type retention struct {
+	maxEntries int
+	maxAge     time.Duration
+	// maxSize bounds the bytes the retained entries take on disk.
+	maxSize int64
+}
+
+// parseRetention reads a history's retention from its section of the
+// configuration file, falling back to def for missing keys.
+func parseRetention(section *ini.Section, def retention) retention {
+	return retention{
+		maxEntries: section.Key("MaxEntries").MustInt(def.maxEntries),
+		maxAge:     section.Key("MaxAge").MustDuration(def.maxAge),
+		maxSize:    section.Key("MaxSize").MustInt64(def.maxSize),
+	}
+}
+
+// keep returns the index of the oldest of n entries, ordered oldest
+// first, to retain at now. Entry i was recorded at time at(i) and
+// takes size(i) bytes.
+func (r retention) keep(
+	n int,
+	at func(int) time.Time,
+	size func(int) int,
+	now time.Time,
+) int {
+	var total int64
+	for i := n - 1; i >= 0; i-- {
+		if r.maxSize > 0 {
+			total += int64(size(i))
+		}
+		switch {
+		case r.maxEntries > 0 && n-i > r.maxEntries,
+			r.maxAge > 0 && now.Sub(at(i)) > r.maxAge,
+			r.maxSize > 0 && total > r.maxSize:
+			return i + 1
+		}
+	}
+	return 0
+}
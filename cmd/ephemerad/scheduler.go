@@ -0,0 +1,110 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// scheduler runs oneshot components that have an OnCalendar or
+// Interval schedule. Each scheduled component gets its own timer
+// loop, which is started and stopped as the managed components
+// change. A run that is due while the previous one, or one requested
+// with the activate RPC, is still in progress is skipped.
+type scheduler struct {
+	ha *haMonitor
+
+	mu    sync.Mutex
+	loops map[*component]chan struct{}
+}
+
+func newScheduler(ha *haMonitor) *scheduler {
+	return &scheduler{
+		ha:    ha,
+		loops: make(map[*component]chan struct{}),
+	}
+}
+
+func (s *scheduler) sync(
+	key string,
+	a *atom.Atom,
+	old, new *hashmap.Map,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for comp, done := range s.loops {
+		cur, ok := new.Find(comp.meta.Name())
+		if ok && cur.(*component) == comp {
+			continue
+		}
+		close(done)
+		delete(s.loops, comp)
+	}
+	new.Range(func(name string, comp *component) {
+		if _, ok := s.loops[comp]; ok {
+			return
+		}
+		if _, ok := comp.meta.NextRun(time.Now()); !ok {
+			return
+		}
+		done := make(chan struct{})
+		s.loops[comp] = done
+		goComponent(name, func() { s.loop(comp, done) })
+	})
+}
+
+func (s *scheduler) loop(comp *component, done chan struct{}) {
+	last := time.Now()
+	for {
+		next, ok := comp.meta.NextRun(last)
+		if !ok {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(comp)
+		last = time.Now()
+	}
+}
+
+func (s *scheduler) run(comp *component) {
+	name := comp.meta.Name()
+	debug := componentLog(name, logLevelDebug)
+	if atomic.LoadInt32(&comp.inProgress) > 0 {
+		debug.Printf("Skipping scheduled run of %s: "+
+			"previous run still in progress\n", name)
+		return
+	}
+	if !settings().autoActivate {
+		debug.Printf("Skipping scheduled run of %s: "+
+			"auto-activation is disabled\n", name)
+		return
+	}
+	err := admin.checkActivation(comp)
+	if err == nil {
+		err = s.ha.checkActivation(comp)
+	}
+	if err == nil {
+		err = comp.meta.CheckActiveWindow(time.Now())
+	}
+	if err != nil {
+		debug.Printf("Skipping scheduled run of %s: %s\n", name, err)
+		return
+	}
+	componentLog(name, logLevelInfo).Println("Scheduled run of", name)
+	err = comp.runOnce()
+	if err != nil {
+		elog.Printf("Scheduled run of %s failed: %s\n", name, err)
+	}
+}
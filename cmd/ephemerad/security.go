@@ -0,0 +1,66 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/danos/ephemera"
+)
+
+var secureInstances bool
+
+func init() {
+	flag.BoolVar(
+		&secureInstances,
+		"secure-instances",
+		false,
+		"ignore instance files and directories that are not owned by "+
+			"root or are writable by group or others",
+	)
+}
+
+// checkOwnership ensures that only root could have written the file,
+// since instance files name the commands ephemerad runs as root.
+func checkOwnership(path string) error {
+	if !secureInstances {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("unable to determine owner")
+	}
+	if st.Uid != 0 {
+		return errors.New("not owned by root")
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return errors.New("writable by group or others")
+	}
+	return nil
+}
+
+// checkSources ensures that the base instance files and drop-in
+// fragments the component's instance file is merged with are as well
+// protected as the file itself.
+func checkSources(comp *ephemera.Component) error {
+	paths := comp.Bases()
+	for _, file := range comp.DropIns() {
+		paths = append(paths, filepath.Dir(file), file)
+	}
+	for _, path := range paths {
+		err := checkOwnership(path)
+		if err != nil {
+			return errors.New(path + ": " + err.Error())
+		}
+	}
+	return nil
+}
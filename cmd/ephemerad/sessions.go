@@ -0,0 +1,284 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danos/mgmterror"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// session is one of the sessions, such as a packet capture, started
+// on a component declared with Sessions=true. It is torn down when
+// its lease expires unless renewed first.
+type session struct {
+	id      string
+	params  map[string]string
+	expires time.Time
+	timer   *time.Timer
+}
+
+// sessionTable tracks the sessions of each component, by component
+// name and session id.
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]map[string]*session
+}
+
+var sessions = &sessionTable{
+	sessions: make(map[string]map[string]*session),
+}
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func sessionNotFound(name, id string) error {
+	err := mgmterror.NewInvalidValueApplicationError()
+	err.Path = "/ephemerad-v1:session"
+	err.AppTag = codeInvalidInput
+	err.Message = "component " + name + " has no session " + id
+	return err
+}
+
+// start starts a new session on comp, lasting lease or the
+// component's SessionLease if lease is zero.
+func (t *sessionTable) start(
+	comp *component,
+	params map[string]string,
+	lease time.Duration,
+) (*session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	if lease == 0 {
+		lease = comp.meta.SessionLease()
+	}
+	return t.open(comp, id, params, lease)
+}
+
+// restore starts the session id on comp again, as exported by another
+// ephemerad, lasting until expires, unless it has already expired or
+// is running.
+func (t *sessionTable) restore(
+	comp *component,
+	id string,
+	params map[string]string,
+	expires time.Time,
+) error {
+	lease := time.Until(expires)
+	if lease <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	_, running := t.sessions[comp.meta.Name()][id]
+	t.mu.Unlock()
+	if running {
+		return nil
+	}
+	_, err := t.open(comp, id, params, lease)
+	return err
+}
+
+// open runs comp's Start script for the session id and tracks it,
+// expiring after lease.
+func (t *sessionTable) open(
+	comp *component,
+	id string,
+	params map[string]string,
+	lease time.Duration,
+) (*session, error) {
+	err := comp.meta.StartSession(id, params)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{id: id, params: params}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byID, ok := t.sessions[comp.meta.Name()]
+	if !ok {
+		byID = make(map[string]*session)
+		t.sessions[comp.meta.Name()] = byID
+	}
+	byID[id] = s
+	t.lease(comp, s, lease)
+	componentLog(comp.meta.Name(), logLevelInfo).
+		Println("Started session", id, "for", lease)
+	return s, nil
+}
+
+// lease sets the session to expire after lease. t.mu must be held.
+func (t *sessionTable) lease(
+	comp *component,
+	s *session,
+	lease time.Duration,
+) {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.expires = time.Now().Add(lease)
+	s.timer = time.AfterFunc(lease, func() {
+		componentLog(comp.meta.Name(), logLevelInfo).
+			Println("Session", s.id, "lease expired")
+		err := t.stop(comp, s.id, nil)
+		if err != nil {
+			elog.Println("Error stopping expired session:", err)
+		}
+	})
+}
+
+// renew extends the session's lease to lease from now, or the
+// component's SessionLease if lease is zero.
+func (t *sessionTable) renew(
+	comp *component,
+	id string,
+	lease time.Duration,
+) (*session, error) {
+	if lease == 0 {
+		lease = comp.meta.SessionLease()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[comp.meta.Name()][id]
+	if !ok {
+		return nil, sessionNotFound(comp.meta.Name(), id)
+	}
+	t.lease(comp, s, lease)
+	return s, nil
+}
+
+func (t *sessionTable) remove(name, id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[name][id]
+	if !ok {
+		return false
+	}
+	s.timer.Stop()
+	delete(t.sessions[name], id)
+	if len(t.sessions[name]) == 0 {
+		delete(t.sessions, name)
+	}
+	return true
+}
+
+// stop tears down a single session of comp.
+func (t *sessionTable) stop(
+	comp *component,
+	id string,
+	params map[string]string,
+) error {
+	if !t.remove(comp.meta.Name(), id) {
+		return sessionNotFound(comp.meta.Name(), id)
+	}
+	componentLog(comp.meta.Name(), logLevelInfo).
+		Println("Stopping session", id)
+	return comp.meta.StopSession(id, params)
+}
+
+func (t *sessionTable) ids(name string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ids []string
+	for id := range t.sessions[name] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// stopAll tears down every session of comp, as happens whenever the
+// component itself is stopped.
+func (t *sessionTable) stopAll(comp *component) {
+	for _, id := range t.ids(comp.meta.Name()) {
+		err := t.stop(comp, id, nil)
+		if err != nil {
+			elog.Printf("Error stopping session %s of %s: %s\n",
+				id, comp.meta.Name(), err)
+		}
+	}
+}
+
+type sessionState struct {
+	ID      string `rfc7951:"id"`
+	Expires string `rfc7951:"expires"`
+}
+
+func (t *sessionTable) state(name string) []sessionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []sessionState
+	for id, s := range t.sessions[name] {
+		out = append(out, sessionState{
+			ID:      id,
+			Expires: s.expires.Format(time.RFC3339),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// export returns the sessions of the named component as export-state
+// records them.
+func (t *sessionTable) export(name string) []sessionRuntimeState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []sessionRuntimeState
+	for id, s := range t.sessions[name] {
+		out = append(out, sessionRuntimeState{
+			ID:         id,
+			Expires:    s.expires,
+			Parameters: s.params,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+type sessionOutput struct {
+	Session string `rfc7951:"ephemerad-v1:session,omitempty"`
+	Expires string `rfc7951:"ephemerad-v1:expires,omitempty"`
+}
+
+func sessionOutputNew(s *session) *sessionOutput {
+	return &sessionOutput{
+		Session: s.id,
+		Expires: s.expires.Format(time.RFC3339),
+	}
+}
+
+type renewSessionInput struct {
+	Component string `rfc7951:"ephemerad-v1:component"`
+	Session   string `rfc7951:"ephemerad-v1:session"`
+	Lease     uint32 `rfc7951:"ephemerad-v1:lease"`
+}
+
+func (r *rpc) RenewSession(in *renewSessionInput) (*sessionOutput, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	comp, found := cs.Find(in.Component)
+	if !found {
+		return nil, sessionNotFound(in.Component, in.Session)
+	}
+	s, err := sessions.renew(comp.(*component), in.Session,
+		time.Duration(in.Lease)*time.Second)
+	if err != nil {
+		return nil, rpcError(err, codeInvalidInput)
+	}
+	return sessionOutputNew(s), nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"time"
+
+	"github.com/danos/mgmterror"
+	"jsouthworth.net/go/etm/atom"
+)
+
+const (
+	autoActivationEnabled  = "enabled"
+	autoActivationDisabled = "disabled"
+)
+
+type hooksData struct {
+	Exec string `rfc7951:"exec,omitempty"`
+	URL  string `rfc7951:"url,omitempty"`
+}
+
+type limitsData struct {
+	MaxProcesses          uint32 `rfc7951:"max-processes,omitempty"`
+	MaxComponentProcesses uint32 `rfc7951:"max-component-processes,omitempty"`
+}
+
+type timeoutsData struct {
+	Start uint32 `rfc7951:"start,omitempty"`
+	Stop  uint32 `rfc7951:"stop,omitempty"`
+	Set   uint32 `rfc7951:"set,omitempty"`
+	Get   uint32 `rfc7951:"get,omitempty"`
+	RPC   uint32 `rfc7951:"rpc,omitempty"`
+}
+
+type settingsData struct {
+	LogLevel          string        `rfc7951:"log-level,omitempty"`
+	UnitWaitTimeout   uint32        `rfc7951:"unit-wait-timeout,omitempty"`
+	AutoActivation    string        `rfc7951:"auto-activation,omitempty"`
+	Limits            *limitsData   `rfc7951:"limits,omitempty"`
+	Timeouts          *timeoutsData `rfc7951:"timeouts,omitempty"`
+	TelemetryInterval uint32        `rfc7951:"telemetry-interval,omitempty"`
+	Hooks             *hooksData    `rfc7951:"hooks,omitempty"`
+}
+
+type configData struct {
+	Settings *settingsData `rfc7951:"ephemerad-v1:settings,omitempty"`
+}
+
+// config is ephemerad's own configuration model. Committed settings
+// take effect immediately, overriding those from the configuration
+// file; settings that are not configured fall back to the file.
+type config struct {
+	current *atom.Atom
+}
+
+func newConfig() *config {
+	return &config{current: atom.New(&configData{})}
+}
+
+func (c *config) Get() *configData {
+	return c.current.Deref().(*configData)
+}
+
+func (c *config) Check(in *configData) error {
+	if in.Settings == nil || in.Settings.LogLevel == "" {
+		return nil
+	}
+	err := checkLogLevel(in.Settings.LogLevel)
+	if err != nil {
+		merr := mgmterror.NewInvalidValueApplicationError()
+		merr.Path = "/ephemerad-v1:settings/log-level"
+		merr.Message = err.Error()
+		return merr
+	}
+	return nil
+}
+
+func (c *config) Set(in *configData) error {
+	err := c.Check(in)
+	if err != nil {
+		return err
+	}
+	c.current.Reset(in)
+	applySettings(mergeSettings(
+		fileSettings.Deref().(*daemonConfig), in.Settings))
+	return nil
+}
+
+// mergeSettings layers the configured settings over base.
+func mergeSettings(base *daemonConfig, in *settingsData) *daemonConfig {
+	conf := *base
+	if in == nil {
+		return &conf
+	}
+	if in.LogLevel != "" {
+		conf.logLevel = in.LogLevel
+	}
+	if in.UnitWaitTimeout != 0 {
+		conf.unitWaitTimeout = time.Duration(in.UnitWaitTimeout) *
+			time.Second
+	}
+	switch in.AutoActivation {
+	case autoActivationEnabled:
+		conf.autoActivate = true
+	case autoActivationDisabled:
+		conf.autoActivate = false
+	}
+	if in.Limits != nil {
+		if in.Limits.MaxProcesses != 0 {
+			conf.limits.MaxProcesses = int(in.Limits.MaxProcesses)
+		}
+		if in.Limits.MaxComponentProcesses != 0 {
+			conf.limits.MaxComponentProcesses =
+				int(in.Limits.MaxComponentProcesses)
+		}
+	}
+	if in.Timeouts != nil {
+		seconds := func(s uint32, d *time.Duration) {
+			if s != 0 {
+				*d = time.Duration(s) * time.Second
+			}
+		}
+		seconds(in.Timeouts.Start, &conf.timeouts.Start)
+		seconds(in.Timeouts.Stop, &conf.timeouts.Stop)
+		seconds(in.Timeouts.Set, &conf.timeouts.Set)
+		seconds(in.Timeouts.Get, &conf.timeouts.Get)
+		seconds(in.Timeouts.RPC, &conf.timeouts.RPC)
+	}
+	if in.TelemetryInterval != 0 {
+		conf.telemetryInterval = time.Duration(in.TelemetryInterval) *
+			time.Second
+	}
+	if in.Hooks != nil {
+		if in.Hooks.Exec != "" {
+			conf.hooks.exec = in.Hooks.Exec
+		}
+		if in.Hooks.URL != "" {
+			conf.hooks.url = in.Hooks.URL
+		}
+	}
+	return &conf
+}
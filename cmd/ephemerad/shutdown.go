@@ -0,0 +1,151 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danos/vci"
+	"golang.org/x/sys/unix"
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+var shutdownTimeout time.Duration
+
+func init() {
+	flag.DurationVar(
+		&shutdownTimeout,
+		"shutdown-timeout",
+		30*time.Second,
+		"how long to wait for running components to stop when "+
+			"ephemerad is asked to exit",
+	)
+}
+
+// shuttingDown is set once ephemerad has begun stopping the running
+// components on its way out, after which nothing, whether the restart
+// policy, listener restarts, on-demand or HA activation or a caller,
+// may activate a component again.
+var shuttingDown int32
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) != 0
+}
+
+func errShuttingDown() error {
+	return newOperatorError(msgShuttingDown)
+}
+
+// handleShutdown stops the running components and then exits when
+// ephemerad receives SIGTERM or SIGINT, so that a restart of the
+// daemon doesn't leave components' listeners half-dead. A second
+// signal exits immediately.
+func handleShutdown(managedComponents *atom.Atom, ephemerad vci.Component) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, unix.SIGTERM, unix.SIGINT)
+	go func() {
+		sig := <-sigs
+		ilog.Printf("Received %s, stopping components\n", sig)
+		atomic.StoreInt32(&shuttingDown, 1)
+		done := make(chan struct{})
+		go func() {
+			cs := managedComponents.Deref().(*hashmap.Map)
+			stopRunning(cs, shutdownTimeout)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case sig = <-sigs:
+			elog.Printf("Received %s, exiting without waiting for "+
+				"components to stop\n", sig)
+			totals.flush()
+			os.Exit(1)
+		}
+		err := ephemerad.Stop()
+		if err != nil {
+			elog.Println("Unregistering from the bus:", err)
+		}
+		totals.flush()
+		os.Exit(0)
+	}()
+}
+
+// stopRunning stops every running component in the reverse of their
+// dependency order, each once those that Require it or come After it
+// have stopped, and otherwise at once. It waits at most timeout for
+// them all to stop, logging those that haven't.
+func stopRunning(cs *hashmap.Map, timeout time.Duration) {
+	var running []string
+	cs.Range(func(name string, comp *component) {
+		if comp.Running() {
+			running = append(running, name)
+		}
+	})
+	sort.Strings(running)
+	// Each component waits for the components stopped before it that
+	// depend on it, which a cycle can't make it wait for forever.
+	order := reversed(dependencyOrder(cs, running))
+	stopped := make(map[string]chan struct{}, len(order))
+	for _, name := range order {
+		stopped[name] = make(chan struct{})
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	stopping := make(map[string]bool)
+	for i, name := range order {
+		val, _ := cs.Find(name)
+		comp := val.(*component)
+		var waitFor []chan struct{}
+		for _, prev := range order[:i] {
+			if dependsOn(cs, prev, name) {
+				waitFor = append(waitFor, stopped[prev])
+			}
+		}
+		mu.Lock()
+		stopping[name] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func(name string, done chan struct{}) {
+			defer wg.Done()
+			defer close(done)
+			for _, ch := range waitFor {
+				<-ch
+			}
+			err := comp.Stop()
+			if err != nil {
+				elog.Printf("Stopping %s: %s\n", name, err)
+			}
+			mu.Lock()
+			delete(stopping, name)
+			mu.Unlock()
+		}(name, stopped[name])
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var out []string
+	for name := range stopping {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	elog.Printf("Components still stopping after %s: %s\n", timeout,
+		strings.Join(out, ", "))
+}
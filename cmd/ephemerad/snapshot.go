@@ -0,0 +1,155 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// componentSnapshot is the document produced by snapshot-components,
+// mapping each component's name to its ephemera.Component.Snapshot.
+type componentSnapshot struct {
+	Components map[string]map[string]string `json:"components"`
+}
+
+func takeSnapshot(cs *hashmap.Map) *componentSnapshot {
+	out := &componentSnapshot{
+		Components: make(map[string]map[string]string),
+	}
+	cs.Range(func(name string, comp *component) {
+		out.Components[name] = comp.meta.Snapshot()
+	})
+	return out
+}
+
+func decodeSnapshot(in string) (*componentSnapshot, error) {
+	var snap componentSnapshot
+	err := json.Unmarshal([]byte(in), &snap)
+	if err != nil {
+		return nil, errors.New("invalid snapshot: " + err.Error())
+	}
+	return &snap, nil
+}
+
+type snapshotDifference struct {
+	Component string `rfc7951:"component"`
+	Key       string `rfc7951:"key,omitempty"`
+	Change    string `rfc7951:"change"`
+	From      string `rfc7951:"from,omitempty"`
+	To        string `rfc7951:"to,omitempty"`
+}
+
+// diffSnapshots lists how to differs from from, ordered by component
+// and key. Components that were added or removed are reported once,
+// without their keys.
+func diffSnapshots(from, to *componentSnapshot) []snapshotDifference {
+	var out []snapshotDifference
+	for name := range from.Components {
+		if _, ok := to.Components[name]; !ok {
+			out = append(out, snapshotDifference{
+				Component: name,
+				Change:    changeRemoved,
+			})
+		}
+	}
+	for name, toKeys := range to.Components {
+		fromKeys, ok := from.Components[name]
+		if !ok {
+			out = append(out, snapshotDifference{
+				Component: name,
+				Change:    changeAdded,
+			})
+			continue
+		}
+		out = append(out, diffKeys(name, fromKeys, toKeys)...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Component != out[j].Component {
+			return out[i].Component < out[j].Component
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func diffKeys(name string, from, to map[string]string) []snapshotDifference {
+	var out []snapshotDifference
+	for key, value := range from {
+		toValue, ok := to[key]
+		switch {
+		case !ok:
+			out = append(out, snapshotDifference{
+				Component: name,
+				Key:       key,
+				Change:    changeRemoved,
+				From:      value,
+			})
+		case toValue != value:
+			out = append(out, snapshotDifference{
+				Component: name,
+				Key:       key,
+				Change:    changeChanged,
+				From:      value,
+				To:        toValue,
+			})
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok {
+			out = append(out, snapshotDifference{
+				Component: name,
+				Key:       key,
+				Change:    changeAdded,
+				To:        value,
+			})
+		}
+	}
+	return out
+}
+
+func (r *rpc) SnapshotComponents(in *rfc7951.Tree) (*rfc7951.Tree, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	buf, err := json.Marshal(takeSnapshot(cs))
+	if err != nil {
+		return nil, rpcError(err, codeOperationFailed)
+	}
+	return rfc7951.TreeNew().
+		Assoc("/ephemerad-v1:snapshot", string(buf)), nil
+}
+
+type diffComponentsOutput struct {
+	Differences []snapshotDifference `rfc7951:"ephemerad-v1:difference"`
+}
+
+// DiffComponents compares two snapshots, or a snapshot with the
+// components currently loaded when to is not given.
+func (r *rpc) DiffComponents(in *rfc7951.Tree) (*diffComponentsOutput, error) {
+	from, err := decodeSnapshot(in.At("/ephemerad-v1:from").ToString())
+	if err != nil {
+		return nil, rpcError(err, codeInvalidInput)
+	}
+	var to *componentSnapshot
+	if in.Contains("/ephemerad-v1:to") {
+		to, err = decodeSnapshot(in.At("/ephemerad-v1:to").ToString())
+		if err != nil {
+			return nil, rpcError(err, codeInvalidInput)
+		}
+	} else {
+		to = takeSnapshot(r.managedComponents.Deref().(*hashmap.Map))
+	}
+	return &diffComponentsOutput{
+		Differences: diffSnapshots(from, to),
+	}, nil
+}
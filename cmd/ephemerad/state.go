@@ -0,0 +1,166 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sort"
+
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+type componentState struct {
+	Name          string `rfc7951:"name"`
+	Type          string `rfc7951:"type"`
+	Owner         string `rfc7951:"owner,omitempty"`
+	Description   string `rfc7951:"description,omitempty"`
+	DocURL        string `rfc7951:"doc-url,omitempty"`
+	Deprecated    string `rfc7951:"deprecated-since,omitempty"`
+	ReplacedBy    string `rfc7951:"replaced-by,omitempty"`
+	Hash          string `rfc7951:"hash"`
+	Enabled       bool   `rfc7951:"enabled"`
+	Running       bool   `rfc7951:"running"`
+	OnDemand      bool   `rfc7951:"on-demand"`
+	Bus           string `rfc7951:"bus"`
+	CircuitState  string `rfc7951:"circuit-state"`
+	Reason        string `rfc7951:"reason,omitempty"`
+	LastResult    string `rfc7951:"last-result,omitempty"`
+	OrphansReaped uint64 `rfc7951:"orphans-reaped"`
+	ListenerDrops uint64 `rfc7951:"listener-drops"`
+	InFlight      uint32 `rfc7951:"in-flight"`
+	Queued        uint32 `rfc7951:"queued"`
+	Overloaded    bool   `rfc7951:"overloaded"`
+	PeakRSS       uint64 `rfc7951:"peak-rss"`
+	TotalRSS      uint64 `rfc7951:"total-rss"`
+	UserTime      uint64 `rfc7951:"user-time"`
+	SystemTime    uint64 `rfc7951:"system-time"`
+
+	Models   []string       `rfc7951:"model"`
+	Sessions []sessionState `rfc7951:"session"`
+	Bases    []string       `rfc7951:"base-instance,omitempty"`
+	DropIns  []string       `rfc7951:"drop-in,omitempty"`
+	Counters countersState  `rfc7951:"counters"`
+}
+
+type componentsState struct {
+	Component []componentState `rfc7951:"component"`
+}
+
+type stateData struct {
+	HAState         string          `rfc7951:"ephemerad-v1:ha-state"`
+	Startup         startupState    `rfc7951:"ephemerad-v1:startup"`
+	Components      componentsState `rfc7951:"ephemerad-v1:components"`
+	BrokenInstances brokenState     `rfc7951:"ephemerad-v1:broken-instances"`
+	StatePaths      statePathsState `rfc7951:"ephemerad-v1:state-paths"`
+	Resources       resourcesState  `rfc7951:"ephemerad-v1:resources"`
+	Features        featuresState   `rfc7951:"ephemerad-v1:features"`
+}
+
+type statePath struct {
+	Path      string `rfc7951:"path"`
+	Component string `rfc7951:"component"`
+	Model     string `rfc7951:"model"`
+}
+
+type statePathsState struct {
+	StatePath []statePath `rfc7951:"state-path"`
+}
+
+// statePaths lists the YANG paths served by each enabled model,
+// ordered by path.
+func statePaths(cs *hashmap.Map) []statePath {
+	var out []statePath
+	cs.Range(func(name string, comp *component) {
+		models := comp.meta.Models()
+		for _, modelName := range comp.meta.ModelNames() {
+			model := models[modelName]
+			if !model.Enabled() {
+				continue
+			}
+			for _, path := range model.StatePaths() {
+				out = append(out, statePath{
+					Path:      path,
+					Component: name,
+					Model:     modelName,
+				})
+			}
+		}
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Component < out[j].Component
+	})
+	return out
+}
+
+type brokenState struct {
+	Instance []brokenInstance `rfc7951:"instance"`
+}
+
+// state provides ephemerad's view of its managed components to the
+// operational datastore.
+type state struct {
+	managedComponents *atom.Atom
+	ha                *haMonitor
+}
+
+func (s *state) Get() *stateData {
+	out := &stateData{
+		HAState:   s.ha.State(),
+		Startup:   startup.state(),
+		Resources: resourceState(),
+		Features:  featureStates(),
+	}
+	out.BrokenInstances.Instance = broken.state()
+	cs := s.managedComponents.Deref().(*hashmap.Map)
+	out.Components.Component = componentStates(cs)
+	out.StatePaths.StatePath = statePaths(cs)
+	return out
+}
+
+// componentStates describes each managed component, ordered by name.
+func componentStates(cs *hashmap.Map) []componentState {
+	var out []componentState
+	cs.Range(func(name string, comp *component) {
+		inFlight, queued := comp.meta.Operations()
+		usage := comp.meta.Usage()
+		out = append(out, componentState{
+			Name:          name,
+			Type:          comp.meta.Type().String(),
+			Owner:         comp.owner,
+			Description:   comp.docs().Description(),
+			DocURL:        comp.docs().DocURL(),
+			Deprecated:    comp.meta.DeprecatedSince(),
+			ReplacedBy:    comp.meta.ReplacedBy(),
+			Hash:          comp.hash,
+			Enabled:       admin.enabled(comp),
+			Running:       comp.Running(),
+			OnDemand:      comp.meta.OnDemand(),
+			Bus:           busOf(comp),
+			CircuitState:  comp.meta.CircuitState().String(),
+			Reason:        comp.Reason(),
+			LastResult:    comp.LastResult(),
+			OrphansReaped: orphans.get(name),
+			ListenerDrops: comp.ListenerDrops(),
+			InFlight:      uint32(inFlight),
+			Queued:        uint32(queued),
+			Overloaded:    comp.meta.Overloaded(),
+			PeakRSS:       usage.PeakRSS,
+			TotalRSS:      usage.TotalRSS,
+			UserTime:      uint64(usage.UserTime.Milliseconds()),
+			SystemTime:    uint64(usage.SystemTime.Milliseconds()),
+			Models:        comp.meta.ModelNames(),
+			Sessions:      sessions.state(name),
+			Bases:         comp.meta.Bases(),
+			DropIns:       comp.meta.DropIns(),
+			Counters:      totals.state(name),
+		})
+	})
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"time"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+// lifecycle records when a component was last activated and
+// deactivated, and how its Start and Stop scripts fared.
+type lifecycle struct {
+	activated   time.Time
+	deactivated time.Time
+	startError  string
+	stopError   string
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// activated records an activation, in which the Start script
+// returned err.
+func (c *component) activated(err error) {
+	totals.activated(c.meta.Name(), err)
+	c.lifecycle.Swap(func(l *lifecycle) *lifecycle {
+		next := *l
+		next.activated = time.Now()
+		next.startError = errorString(err)
+		return &next
+	})
+}
+
+// deactivated records a deactivation, in which the Stop script
+// returned err.
+func (c *component) deactivated(err error) {
+	c.lifecycle.Swap(func(l *lifecycle) *lifecycle {
+		next := *l
+		next.deactivated = time.Now()
+		next.stopError = errorString(err)
+		return &next
+	})
+}
+
+func (c *component) getLifecycle() *lifecycle {
+	return c.lifecycle.Deref().(*lifecycle)
+}
+
+type componentLifecycle struct {
+	Name            string `rfc7951:"name"`
+	Running         bool   `rfc7951:"running"`
+	LastActivated   string `rfc7951:"last-activated,omitempty"`
+	LastDeactivated string `rfc7951:"last-deactivated,omitempty"`
+	LastUsed        string `rfc7951:"last-used,omitempty"`
+	StartError      string `rfc7951:"start-error,omitempty"`
+	StopError       string `rfc7951:"stop-error,omitempty"`
+}
+
+type statusOutput struct {
+	Components []componentLifecycle `rfc7951:"ephemerad-v1:component"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (r *rpc) Status(in *rfc7951.Tree) (*statusOutput, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	name := in.At("/ephemerad-v1:component").ToString()
+	if name != "" && !cs.Contains(name) {
+		return nil, unknownComponent(name)
+	}
+	out := &statusOutput{}
+	for _, state := range componentStates(cs) {
+		if name != "" && state.Name != name {
+			continue
+		}
+		comp := cs.At(state.Name).(*component)
+		l := comp.getLifecycle()
+		out.Components = append(out.Components, componentLifecycle{
+			Name:            state.Name,
+			Running:         comp.Running(),
+			LastActivated:   formatTime(l.activated),
+			LastDeactivated: formatTime(l.deactivated),
+			LastUsed:        formatTime(comp.meta.LastUsed()),
+			StartError:      l.startError,
+			StopError:       l.stopError,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+const defaultSyncHistorySize = 20
+
+const (
+	syncStop  = "stop"
+	syncStart = "start"
+	syncProxy = "proxy"
+
+	syncRemoved = "removed"
+	syncChanged = "changed"
+	syncAdded   = "added"
+)
+
+// syncStep is a single action taken to bring the running components
+// in line with the instance directory.
+type syncStep struct {
+	Action    string `json:"action"`
+	Component string `json:"component"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
+}
+
+// syncPlan is the ordered list of actions taken for a change to the
+// instance directory.
+type syncPlan struct {
+	Time  time.Time   `json:"time"`
+	Steps []*syncStep `json:"steps"`
+
+	comps []*component
+}
+
+func (p *syncPlan) add(action, reason string, comp *component) {
+	p.Steps = append(p.Steps, &syncStep{
+		Action:    action,
+		Component: comp.meta.Name(),
+		Reason:    reason,
+	})
+	p.comps = append(p.comps, comp)
+}
+
+// planSync works out how to move from the old to the new set of
+// components. Every stop comes before any start: components that
+// were removed or whose definitions changed are stopped, then those
+// with RestartOnChange that changed while running are started again
+// from their new definition. Components are started after those they
+// depend on and stopped before them. Other components are left for
+// activation to start, as when they are installed with a package the
+// bus may not be ready for them yet. OnDemand components that were
+// added, or changed while inactive, are proxied last.
+func planSync(old, new *hashmap.Map) *syncPlan {
+	plan := &syncPlan{Time: time.Now()}
+	var removed, changed, restarted, added, proxied []string
+	old.Range(func(name string, comp *component) {
+		if !new.Contains(name) {
+			removed = append(removed, name)
+		}
+	})
+	new.Range(func(name string, comp *component) {
+		val, ok := old.Find(name)
+		if !ok {
+			if comp.meta.OnDemand() {
+				added = append(added, name)
+			}
+			return
+		}
+		if comp.sameDefinition(val.(*component)) {
+			return
+		}
+		changed = append(changed, name)
+		switch {
+		case val.(*component).Running():
+			if comp.meta.RestartOnChange() {
+				restarted = append(restarted, name)
+			}
+		case comp.meta.OnDemand():
+			proxied = append(proxied, name)
+		}
+	})
+	sort.Strings(removed)
+	sort.Strings(changed)
+	sort.Strings(restarted)
+	sort.Strings(added)
+	sort.Strings(proxied)
+	removed = reversed(dependencyOrder(old, removed))
+	changed = reversed(dependencyOrder(old, changed))
+	restarted = dependencyOrder(new, restarted)
+	for _, name := range removed {
+		comp, _ := old.Find(name)
+		plan.add(syncStop, syncRemoved, comp.(*component))
+	}
+	for _, name := range changed {
+		comp, _ := old.Find(name)
+		plan.add(syncStop, syncChanged, comp.(*component))
+	}
+	for _, name := range restarted {
+		comp, _ := new.Find(name)
+		plan.add(syncStart, syncChanged, comp.(*component))
+	}
+	for _, name := range proxied {
+		comp, _ := new.Find(name)
+		plan.add(syncProxy, syncChanged, comp.(*component))
+	}
+	for _, name := range added {
+		comp, _ := new.Find(name)
+		plan.add(syncProxy, syncAdded, comp.(*component))
+	}
+	return plan
+}
+
+// dependencyOrder orders names, the components in cs, after those
+// among them they depend on, leaving them as they are if they depend
+// on one another in a cycle, as checkDependencies reports.
+func dependencyOrder(cs *hashmap.Map, names []string) []string {
+	ordered, _ := orderByDependencies(cs, names)
+	return ordered
+}
+
+// execute carries out the plan's steps in order. The components it
+// starts are only started once ephemerad answers over the bus, as
+// their own registrations would otherwise fail, and if it doesn't
+// within busReadyTimeout they fail with why.
+func (p *syncPlan) execute(ha *haMonitor) {
+	var busErr error
+	busChecked := false
+	for i, step := range p.Steps {
+		comp := p.comps[i]
+		componentLog(step.Component, logLevelInfo).
+			Printf("Instance sync: %s %s (%s)\n", step.Action,
+				step.Component, step.Reason)
+		var err error
+		switch step.Action {
+		case syncStop:
+			err = comp.Stop()
+			if err == nil {
+				err = comp.unproxy()
+			}
+		case syncStart:
+			if !busChecked {
+				busErr = waitForBus(busReadyTimeout)
+				busChecked = true
+			}
+			err = busErr
+			if err == nil {
+				err = p.start(comp, ha)
+			}
+		case syncProxy:
+			err = comp.proxy()
+		}
+		if err == nil {
+			continue
+		}
+		step.Error = err.Error()
+		elog.Printf("Error on instance sync: %s %s: %s\n",
+			step.Action, step.Component, err)
+	}
+}
+
+func (p *syncPlan) start(comp *component, ha *haMonitor) error {
+	if !settings().autoActivate {
+		comp.reason.Reset("deactivated as its instance changed, " +
+			"auto-activation is disabled")
+		return newOperatorError(msgAutoActivationOff)
+	}
+	err := activate(comp, ha)
+	if err == nil {
+		comp.reason.Reset("restarted as its instance changed")
+	}
+	return err
+}
+
+// instanceSync returns the watch function keeping the running
+// components in line with the instance directory. Plans are executed
+// in the order they were made, but not by the watch function itself,
+// so that a plan waiting for the bus doesn't hold up whoever changed
+// the components, such as the instance directory watcher.
+func instanceSync(
+	ha *haMonitor,
+) func(string, *atom.Atom, *hashmap.Map, *hashmap.Map) {
+	queue := &syncQueue{}
+	return func(key string, a *atom.Atom, old, new *hashmap.Map) {
+		checkDependencies(new)
+		plan := planSync(old, new)
+		if len(plan.Steps) == 0 {
+			return
+		}
+		queue.run(func() {
+			plan.execute(ha)
+			syncPlans.record(plan)
+		})
+	}
+}
+
+// syncQueue runs functions one at a time, in the order they were
+// given, without making those giving them wait.
+type syncQueue struct {
+	mu   sync.Mutex
+	last chan struct{}
+}
+
+func (q *syncQueue) run(fn func()) {
+	done := make(chan struct{})
+	q.mu.Lock()
+	prev := q.last
+	q.last = done
+	q.mu.Unlock()
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		fn()
+	}()
+}
+
+// syncHistory keeps the most recently executed sync plans, as many as
+// the configured sync plan retention allows, persisted so that they
+// survive restarts.
+type syncHistory struct {
+	mu    sync.Mutex
+	file  string
+	plans []*syncPlan
+}
+
+var syncPlans = &syncHistory{}
+
+func (h *syncHistory) open(file string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.file = file
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			elog.Println("sync history:", err)
+		}
+		return
+	}
+	err = json.Unmarshal(buf, &h.plans)
+	if err != nil {
+		elog.Println("sync history:", err)
+	}
+	h.trim(time.Now())
+}
+
+// trim drops the plans the sync plan retention doesn't retain at now.
+func (h *syncHistory) trim(now time.Time) {
+	start := settings().syncPlanRetention.keep(len(h.plans),
+		func(i int) time.Time { return h.plans[i].Time },
+		func(i int) int {
+			buf, _ := json.Marshal(h.plans[i])
+			return len(buf) + 1
+		},
+		now)
+	if start > 0 {
+		h.plans = append([]*syncPlan(nil), h.plans[start:]...)
+	}
+}
+
+func (h *syncHistory) record(plan *syncPlan) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.plans = append(h.plans, plan)
+	h.trim(time.Now())
+	if h.file == "" {
+		return
+	}
+	err := h.save()
+	if err != nil {
+		elog.Println("sync history:", err)
+	}
+}
+
+func (h *syncHistory) save() error {
+	buf, err := json.Marshal(h.plans)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(h.file), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := h.file + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.file)
+}
+
+// query returns up to limit of the most recent plans, oldest first.
+func (h *syncHistory) query(limit int) []*syncPlan {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trim(time.Now())
+	plans := h.plans
+	if limit > 0 && len(plans) > limit {
+		plans = plans[len(plans)-limit:]
+	}
+	return append([]*syncPlan(nil), plans...)
+}
+
+type syncStepOutput struct {
+	Action    string `rfc7951:"action"`
+	Component string `rfc7951:"component"`
+	Reason    string `rfc7951:"reason"`
+	Error     string `rfc7951:"error,omitempty"`
+}
+
+type syncPlanOutput struct {
+	Time  string           `rfc7951:"time"`
+	Steps []syncStepOutput `rfc7951:"step"`
+}
+
+type getSyncPlansOutput struct {
+	Plans []syncPlanOutput `rfc7951:"ephemerad-v1:plan"`
+}
+
+func (r *rpc) GetSyncPlans(in *rfc7951.Tree) (*getSyncPlansOutput, error) {
+	out := &getSyncPlansOutput{}
+	for _, plan := range syncPlans.query(
+		int(in.At("/ephemerad-v1:limit").ToUint32())) {
+		po := syncPlanOutput{Time: plan.Time.Format(time.RFC3339)}
+		for _, step := range plan.Steps {
+			po.Steps = append(po.Steps, syncStepOutput{
+				Action:    step.Action,
+				Component: step.Component,
+				Reason:    step.Reason,
+				Error:     step.Error,
+			})
+		}
+		out.Plans = append(out.Plans, po)
+	}
+	return out, nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/coreos/go-systemd/dbus"
+)
+
+const unitPollInterval = 500 * time.Millisecond
+
+var unitWaitTimeout time.Duration
+
+func init() {
+	flag.DurationVar(
+		&unitWaitTimeout,
+		"unit-wait-timeout",
+		90*time.Second,
+		"how long to wait for After=systemd: units before activating",
+	)
+}
+
+// waitForUnits blocks until each of the named systemd units is active,
+// so that components started at boot don't race the platform services
+// they rely on. It fails if a unit fails or doesn't become active
+// within the configured unit wait timeout.
+func waitForUnits(name string, units []string) error {
+	if len(units) == 0 {
+		return nil
+	}
+	conn, err := dbus.NewSystemConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(settings().unitWaitTimeout)
+	for _, unit := range units {
+		for {
+			state, err := unitActiveState(conn, unit)
+			if err != nil {
+				return err
+			}
+			if state == "active" {
+				break
+			}
+			if state == "failed" {
+				return newOperatorError(msgUnitFailed,
+					"component", name, "unit", unit)
+			}
+			if time.Now().After(deadline) {
+				return newOperatorError(msgUnitTimeout,
+					"component", name, "unit", unit)
+			}
+			componentLog(name, logLevelDebug).
+				Printf("%s waiting for %s (%s)\n", name, unit, state)
+			time.Sleep(unitPollInterval)
+		}
+	}
+	return nil
+}
+
+func unitActiveState(conn *dbus.Conn, unit string) (string, error) {
+	prop, err := conn.GetUnitProperty(unit, "ActiveState")
+	if err != nil {
+		return "", err
+	}
+	state, _ := prop.Value.Value().(string)
+	return state, nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sort"
+	"time"
+
+	"jsouthworth.net/go/etm/atom"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+type operationMetrics struct {
+	Operation     string `rfc7951:"operation"`
+	Count         uint64 `rfc7951:"count"`
+	Failures      uint64 `rfc7951:"failures"`
+	TotalDuration uint64 `rfc7951:"total-duration"`
+	MaxDuration   uint64 `rfc7951:"max-duration"`
+}
+
+type componentMetricsData struct {
+	Name       string             `rfc7951:"name"`
+	PeakRSS    uint64             `rfc7951:"peak-rss"`
+	TotalRSS   uint64             `rfc7951:"total-rss"`
+	UserTime   uint64             `rfc7951:"user-time"`
+	SystemTime uint64             `rfc7951:"system-time"`
+	Operations []operationMetrics `rfc7951:"operation"`
+	Counters   countersState      `rfc7951:"counters"`
+}
+
+type componentMetrics struct {
+	Components []componentMetricsData `rfc7951:"ephemerad-v1:component"`
+}
+
+func collectMetrics(cs *hashmap.Map) *componentMetrics {
+	out := &componentMetrics{}
+	cs.Range(func(name string, comp *component) {
+		usage := comp.meta.Usage()
+		cm := componentMetricsData{
+			Name:       name,
+			PeakRSS:    usage.PeakRSS,
+			TotalRSS:   usage.TotalRSS,
+			UserTime:   uint64(usage.UserTime.Milliseconds()),
+			SystemTime: uint64(usage.SystemTime.Milliseconds()),
+			Counters:   totals.state(name),
+		}
+		for op, st := range comp.meta.Stats() {
+			cm.Operations = append(cm.Operations, operationMetrics{
+				Operation:     op,
+				Count:         st.Count,
+				Failures:      st.Failures,
+				TotalDuration: uint64(st.TotalDuration.Milliseconds()),
+				MaxDuration:   uint64(st.MaxDuration.Milliseconds()),
+			})
+		}
+		sort.Slice(cm.Operations, func(i, j int) bool {
+			return cm.Operations[i].Operation < cm.Operations[j].Operation
+		})
+		out.Components = append(out.Components, cm)
+	})
+	sort.Slice(out.Components, func(i, j int) bool {
+		return out.Components[i].Name < out.Components[j].Name
+	})
+	return out
+}
+
+// publishMetrics emits the component-metrics notification every
+// telemetry interval, so that the platform's streaming telemetry can
+// subscribe to it. Changes to the interval take effect immediately.
+func publishMetrics(managedComponents *atom.Atom) {
+	changed := make(chan struct{}, 1)
+	daemonSettings.Watch("telemetry",
+		func(_ string, _ *atom.Atom, old, new *daemonConfig) {
+			if old.telemetryInterval == new.telemetryInterval {
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	go func() {
+		for {
+			var tick <-chan time.Time
+			interval := settings().telemetryInterval
+			if interval > 0 {
+				tick = time.After(interval)
+			}
+			select {
+			case <-tick:
+				cs := managedComponents.Deref().(*hashmap.Map)
+				notifications.emit("component-metrics",
+					collectMetrics(cs))
+			case <-changed:
+			}
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// startupTimes records how long the phases of ephemerad's startup
+// took, and how long each component took to activate the first time,
+// to help attribute slow boots.
+type startupTimes struct {
+	mu           sync.Mutex
+	load         time.Duration
+	registration time.Duration
+	activations  map[string]time.Duration
+}
+
+var startup = &startupTimes{
+	activations: make(map[string]time.Duration),
+}
+
+func (s *startupTimes) setLoad(d time.Duration) {
+	s.mu.Lock()
+	s.load = d
+	s.mu.Unlock()
+}
+
+func (s *startupTimes) setRegistration(d time.Duration) {
+	s.mu.Lock()
+	s.registration = d
+	s.mu.Unlock()
+}
+
+func (s *startupTimes) recordActivation(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, seen := s.activations[name]; seen {
+		return
+	}
+	s.activations[name] = d
+	componentLog(name, logLevelDebug).
+		Printf("Startup: first activation of %s took %s\n", name, d)
+}
+
+func (s *startupTimes) logSummary(components int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ilog.Printf("Startup: loaded %d components in %s, "+
+		"registered on the bus in %s\n",
+		components, s.load, s.registration)
+}
+
+type activationTime struct {
+	Component string `rfc7951:"component"`
+	Duration  uint64 `rfc7951:"duration"`
+}
+
+type startupState struct {
+	Load         uint64           `rfc7951:"load-duration"`
+	Registration uint64           `rfc7951:"registration-duration"`
+	Activation   []activationTime `rfc7951:"activation"`
+}
+
+func (s *startupTimes) state() startupState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := startupState{
+		Load:         uint64(s.load.Milliseconds()),
+		Registration: uint64(s.registration.Milliseconds()),
+	}
+	for name, d := range s.activations {
+		out.Activation = append(out.Activation, activationTime{
+			Component: name,
+			Duration:  uint64(d.Milliseconds()),
+		})
+	}
+	sort.Slice(out.Activation, func(i, j int) bool {
+		return out.Activation[i].Component < out.Activation[j].Component
+	})
+	return out
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"time"
+
+	"github.com/danos/ephemera"
+)
+
+type traceEntryOutput struct {
+	Time      string `rfc7951:"time"`
+	Model     string `rfc7951:"model,omitempty"`
+	Operation string `rfc7951:"operation"`
+	Duration  uint64 `rfc7951:"duration"`
+	Input     string `rfc7951:"input,omitempty"`
+	Output    string `rfc7951:"output,omitempty"`
+	Stderr    string `rfc7951:"stderr,omitempty"`
+	Error     string `rfc7951:"error,omitempty"`
+}
+
+type getTraceOutput struct {
+	Enabled bool               `rfc7951:"ephemerad-v1:enabled"`
+	Entries []traceEntryOutput `rfc7951:"ephemerad-v1:entry"`
+}
+
+func newGetTraceOutput(meta *ephemera.Component) *getTraceOutput {
+	out := &getTraceOutput{Enabled: meta.Tracing()}
+	for _, entry := range meta.Trace() {
+		out.Entries = append(out.Entries, traceEntryOutput{
+			Time:      entry.Time.Format(time.RFC3339Nano),
+			Model:     entry.Model,
+			Operation: entry.Operation,
+			Duration:  uint64(entry.Duration / time.Millisecond),
+			Input:     entry.Input,
+			Output:    entry.Output,
+			Stderr:    entry.Stderr,
+			Error:     entry.Error,
+		})
+	}
+	return out
+}
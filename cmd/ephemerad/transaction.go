@@ -0,0 +1,77 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"strings"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"github.com/danos/mgmterror"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+type transactionInput struct {
+	Components []string `rfc7951:"ephemerad-v1:component"`
+}
+
+// Transaction activates a bundle of components in the order given.
+// If any of them fails to activate those the transaction started are
+// deactivated again, in reverse order, so that the bundle is either
+// wholly activated or left as it was.
+func (r *rpc) Transaction(in *transactionInput) (*rfc7951.Tree, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	comps := make([]*component, 0, len(in.Components))
+	for _, name := range in.Components {
+		comp, found := cs.Find(name)
+		if !found {
+			return nil, unknownComponent(name)
+		}
+		comps = append(comps, redirect(cs, comp.(*component)))
+	}
+
+	var started []*component
+	for _, comp := range comps {
+		wasRunning := comp.Running()
+		err := activate(comp, r.ha)
+		if err == nil {
+			if !wasRunning {
+				started = append(started, comp)
+			}
+			continue
+		}
+		merr := mgmterror.NewOperationFailedApplicationError()
+		merr.AppTag = errorCode(err, codeStartFailed)
+		merr.Message = "activating " + comp.meta.Name() + ": " +
+			err.Error() + rollBack(started)
+		return nil, merr
+	}
+	return rfc7951.TreeNew(), nil
+}
+
+// rollBack deactivates the components a failed transaction started,
+// most recent first, describing the outcome.
+func rollBack(started []*component) string {
+	if len(started) == 0 {
+		return ""
+	}
+	var stopped, failed []string
+	for i := len(started) - 1; i >= 0; i-- {
+		name := started[i].meta.Name()
+		err := started[i].Stop()
+		if err != nil {
+			elog.Printf("Error rolling back %s: %s\n", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		stopped = append(stopped, name)
+	}
+	out := ""
+	if len(stopped) != 0 {
+		out += "; deactivated " + strings.Join(stopped, ", ")
+	}
+	if len(failed) != 0 {
+		out += "; failed to deactivate " + strings.Join(failed, ", ")
+	}
+	return out
+}
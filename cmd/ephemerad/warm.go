@@ -0,0 +1,65 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"strings"
+
+	rfc7951 "github.com/danos/encoding/rfc7951/data"
+	"jsouthworth.net/go/immutable/hashmap"
+)
+
+type warmStateInput struct {
+	Paths []string `rfc7951:"ephemerad-v1:path"`
+}
+
+// covers reports whether a subscription to path samples state served
+// at the served path: one lies beneath the other.
+func covers(served, path string) bool {
+	return served == path ||
+		strings.HasPrefix(path, served+"/") ||
+		strings.HasPrefix(served, path+"/")
+}
+
+// WarmState pre-warms the state of the running components serving
+// the subscribed paths, running their models' State/Get in the
+// background so that the first sample of a telemetry subscription
+// finds it cached. Components that aren't running are left alone.
+func (r *rpc) WarmState(in *warmStateInput) (*rfc7951.Tree, error) {
+	cs := r.managedComponents.Deref().(*hashmap.Map)
+	cs.Range(func(name string, comp *component) {
+		if !comp.Running() {
+			return
+		}
+		models := comp.meta.Models()
+		for _, modelName := range comp.meta.ModelNames() {
+			model := models[modelName]
+			if !model.Enabled() || !servesAny(model.StatePaths(),
+				in.Paths) {
+				continue
+			}
+			modelName := modelName
+			goComponent(name, func() {
+				err := model.Warm()
+				if err != nil {
+					componentLog(name, logLevelInfo).Printf(
+						"Warming state of %s: %s\n",
+						modelName, err)
+				}
+			})
+		}
+	})
+	return rfc7951.TreeNew(), nil
+}
+
+func servesAny(served, paths []string) bool {
+	for _, s := range served {
+		for _, path := range paths {
+			if covers(s, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
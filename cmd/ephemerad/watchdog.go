@@ -0,0 +1,57 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package main
+
+import (
+	"time"
+
+	"github.com/coreos/go-systemd/daemon"
+)
+
+// startWatchdog keeps systemd's watchdog, when the unit has
+// WatchdogSec set, from restarting ephemerad while it is healthy. At
+// twice the rate systemd expects, its own goroutine sends WATCHDOG=1
+// if ephemerad is registered and answering its own RPCs over the bus
+// and the instance directory watcher answers a ping within half the
+// watchdog interval, so that a wedged daemon is restarted. Neither
+// check waits for components being synced, so slow Start and Stop
+// scripts don't get ephemerad restarted.
+func startWatchdog() {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		elog.Println("watchdog:", err)
+		return
+	}
+	if interval <= 0 {
+		return
+	}
+	check := func() error {
+		err := health.readiness()
+		if err != nil {
+			return err
+		}
+		return health.pingWatcher(interval / 2)
+	}
+	go func() {
+		var failing bool
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := check()
+			if err != nil {
+				if !failing {
+					elog.Println("watchdog: unhealthy, "+
+						"not notifying systemd:", err)
+				}
+				failing = true
+				continue
+			}
+			failing = false
+			_, err = daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+			if err != nil {
+				elog.Println("watchdog:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const defaultConfigCacheDir = "/var/lib/ephemera/config"
+
+// configCache persists the configuration last set on each of a
+// component's models that have no Config/Get script, so that Get can
+// return it, even after ephemerad restarts. The component's models
+// share a single file holding a JSON object keyed by model name.
+type configCache struct {
+	path string
+
+	mu sync.Mutex
+}
+
+func configCacheNew(dir, compName string) *configCache {
+	return &configCache{path: filepath.Join(dir, compName+".json")}
+}
+
+func (c *configCache) read() (map[string]json.RawMessage, error) {
+	entries := make(map[string]json.RawMessage)
+	buf, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// load returns the configuration last stored for modelName, or nil
+// if there is none.
+func (c *configCache) load(modelName string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	return entries[modelName], nil
+}
+
+// store replaces the configuration stored for modelName. Empty
+// configuration removes it.
+func (c *configCache) store(modelName string, in []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := c.read()
+	if err != nil {
+		return err
+	}
+	if len(in) == 0 {
+		delete(entries, modelName)
+	} else {
+		entries[modelName] = append(json.RawMessage(nil), in...)
+	}
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// CachedConfig returns the configuration cached for each of the
+// component's models that have no Config/Get script, keyed by model
+// name, leaving out those with none cached.
+func (c *Component) CachedConfig() (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	for name, m := range c.models {
+		if m.config == nil || m.config.get != "" {
+			continue
+		}
+		buf, err := m.config.cache.load(name)
+		if err != nil {
+			return nil, err
+		}
+		if buf != nil {
+			out[name] = buf
+		}
+	}
+	return out, nil
+}
+
+// RestoreCachedConfig replaces the configuration cached for the named
+// model, such as one returned by CachedConfig on another router,
+// without running its Config/Set script.
+func (c *Component) RestoreCachedConfig(modelName string, in []byte) error {
+	m, err := c.model(modelName)
+	if err != nil {
+		return err
+	}
+	if m.config == nil || m.config.get != "" {
+		return errors.New(modelName + " has no cached configuration")
+	}
+	return m.config.cache.store(modelName, in)
+}
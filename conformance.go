@@ -0,0 +1,216 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/danos/mgmterror"
+)
+
+// malformedInput is given to scripts taking input to make them fail.
+const malformedInput = "{"
+
+// ConformanceCheck is the outcome of one of the checks Conformance
+// makes of a component's scripts.
+type ConformanceCheck struct {
+	// Model is the model whose operation was checked, empty for
+	// Start and Stop.
+	Model     string
+	Operation string
+	// Check describes what was checked.
+	Check string
+	// Err is why the check failed, or nil if it passed.
+	Err error
+}
+
+// Passed reports whether the check passed.
+func (c ConformanceCheck) Passed() bool {
+	return c.Err == nil
+}
+
+type conformance struct {
+	comp   *Component
+	checks []ConformanceCheck
+}
+
+func (cf *conformance) add(model, operation, check string, err error) {
+	cf.checks = append(cf.checks, ConformanceCheck{
+		Model:     model,
+		Operation: operation,
+		Check:     check,
+		Err:       err,
+	})
+}
+
+// ran checks how operation behaved when run with a representative
+// input, having returned out or failed with err. If mustSucceed is set
+// failing is itself a failed check, and otherwise the error only has
+// to be reported properly.
+func (cf *conformance) ran(
+	model, operation string,
+	out []byte,
+	err error,
+	mustSucceed, wantOutput bool,
+) {
+	// Each operation is checked on its own, so its failure shouldn't
+	// open the circuit for the checks that follow.
+	cf.comp.runner.breaker.reset()
+	if isTimeout(err) {
+		cf.add(model, operation, "completes within its timeout", err)
+		return
+	}
+	cf.add(model, operation, "completes within its timeout", nil)
+	if err != nil {
+		if mustSucceed {
+			cf.add(model, operation, "succeeds", err)
+		}
+		cf.add(model, operation, "reports errors as an mgmterror",
+			checkMgmtError(err))
+		return
+	}
+	if mustSucceed {
+		cf.add(model, operation, "succeeds", nil)
+	}
+	if wantOutput {
+		cf.add(model, operation, "outputs a JSON object",
+			checkJSONObject(out, !mustSucceed))
+	}
+}
+
+// rejected checks that operation failed, as it was given malformed
+// input, and reported why properly.
+func (cf *conformance) rejected(model, operation string, err error) {
+	cf.comp.runner.breaker.reset()
+	if err == nil {
+		cf.add(model, operation, "rejects malformed input",
+			errors.New("accepted "+malformedInput+" as its input"))
+		return
+	}
+	if isTimeout(err) {
+		cf.add(model, operation, "rejects malformed input", err)
+		return
+	}
+	cf.add(model, operation, "rejects malformed input", nil)
+	cf.add(model, operation, "reports errors as an mgmterror",
+		checkMgmtError(err))
+}
+
+func isTimeout(err error) bool {
+	merr, ok := err.(*mgmterror.OperationFailedApplicationError)
+	return ok && merr.AppTag == AppTagTimeout
+}
+
+// checkMgmtError checks that err came from a script writing an
+// mgmterror to its standard error, rather than plain text.
+func checkMgmtError(err error) error {
+	if _, ok := err.(*mgmterror.MgmtError); ok {
+		return nil
+	}
+	return errors.New("standard error is not a JSON mgmterror: " +
+		err.Error())
+}
+
+func checkJSONObject(out []byte, mayBeEmpty bool) error {
+	if mayBeEmpty && len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(out, &obj)
+	if err != nil {
+		return errors.New("invalid output " + string(out) + ": " +
+			err.Error())
+	}
+	return nil
+}
+
+// Conformance exercises each operation the component declares, so
+// that its author can check the scripts behave as ephemerad expects.
+// It runs Start, with the parameters last set; then each enabled
+// model's Config/Get, Config/Check and Config/Set given the
+// configuration Config/Get returned, State/Get, and each RPC with an
+// empty input; and lastly Stop. Each must finish within its timeout,
+// so one should be set, with SetDefaultTimeouts if the instance
+// doesn't set its own. Output must be a JSON object, and errors must
+// be reported with an mgmterror on standard error, which is also
+// checked by giving Config/Check and each RPC malformed input.
+//
+// The scripts are run for real, bypassing the component's caches, so
+// conformance should only be checked where that is safe, even though
+// Config/Set is only given the configuration the model already has.
+func (c *Component) Conformance() []ConformanceCheck {
+	cf := &conformance{comp: c}
+	if c.start != "" {
+		in, env := c.params.input()
+		err := c.runner.run("", "Start", c.start, in, env...)
+		cf.ran("", "Start", nil, err, true, false)
+	}
+	for _, name := range c.ModelNames() {
+		m := c.models[name]
+		if !m.enabled {
+			continue
+		}
+		cf.model(m)
+	}
+	if c.stop != "" {
+		err := c.runner.run("", "Stop", c.stop, nil)
+		cf.ran("", "Stop", nil, err, true, false)
+	}
+	return cf.checks
+}
+
+func (cf *conformance) model(m *Model) {
+	r := cf.comp.runner
+	name := m.name
+	if m.config != nil {
+		var current []byte
+		if m.config.get != "" {
+			out, err := r.output(name, "Config/Get", m.config.get, nil)
+			cf.ran(name, "Config/Get", out, err, true, true)
+			if err == nil && checkJSONObject(out, false) == nil {
+				current = out
+			}
+		}
+		if m.config.check != "" {
+			in := current
+			if in == nil {
+				in = []byte("{}")
+			}
+			err := r.run(name, "Config/Check", m.config.check, in)
+			cf.ran(name, "Config/Check", nil, err, true, false)
+			err = r.run(name, "Config/Check", m.config.check,
+				[]byte(malformedInput))
+			cf.rejected(name, "Config/Check", err)
+		}
+		// Without a configuration to give it Set could only be
+		// tried by changing the model's configuration.
+		if m.config.set != "" && current != nil {
+			err := r.run(name, "Config/Set", m.config.set, current)
+			cf.ran(name, "Config/Set", nil, err, true, false)
+		}
+	}
+	if m.state != nil && m.state.get != "" {
+		out, err := r.output(name, "State/Get", m.state.get, nil)
+		cf.ran(name, "State/Get", out, err, true, true)
+	}
+	if m.rpc == nil {
+		return
+	}
+	for _, module := range m.RPCModules() {
+		for _, rpcName := range m.RPCNames(module) {
+			operation := strings.Join([]string{"RPC", module, rpcName},
+				"/")
+			script := m.rpc.modules[module][rpcName]
+			out, err := r.output(name, operation, script,
+				[]byte("{}"), "VCI_RPC_METADATA=")
+			cf.ran(name, operation, out, err, false, true)
+			_, err = r.output(name, operation, script,
+				[]byte(malformedInput), "VCI_RPC_METADATA=")
+			cf.rejected(name, operation, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/go-ini/ini"
+)
+
+// credentials are the user and group, from the User and Group keys,
+// that a component's scripts run as instead of ephemerad's own.
+type credentials struct {
+	user  string
+	group string
+	cred  *syscall.Credential
+}
+
+func parseCredentials(section *ini.Section) (*credentials, error) {
+	c := &credentials{
+		user:  section.Key("User").MustString(""),
+		group: section.Key("Group").MustString(""),
+	}
+	if c.user == "" && c.group == "" {
+		return c, nil
+	}
+	c.cred = &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if c.user != "" {
+		u, err := user.Lookup(c.user)
+		if err != nil {
+			return nil, errors.New("User " + err.Error())
+		}
+		c.cred.Uid, err = parseID(u.Uid)
+		if err != nil {
+			return nil, err
+		}
+		c.cred.Gid, err = parseID(u.Gid)
+		if err != nil {
+			return nil, err
+		}
+		// Drop ephemerad's supplementary groups for the user's.
+		gids, err := u.GroupIds()
+		if err != nil {
+			return nil, errors.New("User " + err.Error())
+		}
+		for _, gid := range gids {
+			id, err := parseID(gid)
+			if err != nil {
+				return nil, err
+			}
+			c.cred.Groups = append(c.cred.Groups, id)
+		}
+	}
+	if c.group != "" {
+		g, err := user.LookupGroup(c.group)
+		if err != nil {
+			return nil, errors.New("Group " + err.Error())
+		}
+		c.cred.Gid, err = parseID(g.Gid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid user or group id " + id)
+	}
+	return uint32(n), nil
+}
+
+// chown gives the user and group scripts run as ownership of path,
+// such as the script's temporary directory.
+func (c *credentials) chown(path string) error {
+	if c.cred == nil {
+		return nil
+	}
+	return os.Chown(path, int(c.cred.Uid), int(c.cred.Gid))
+}
+
+func (c *credentials) Equal(other *credentials) bool {
+	return c.user == other.user && c.group == other.group
+}
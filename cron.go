@@ -0,0 +1,159 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values a single field of a cron-like
+// expression matches.
+type cronField struct {
+	any    bool
+	values map[int]bool
+}
+
+type cronRange struct {
+	min, max int
+	names    []string
+}
+
+var cronRanges = [5]cronRange{
+	{min: 0, max: 59},
+	{min: 0, max: 23},
+	{min: 1, max: 31},
+	{min: 1, max: 12, names: []string{"", "jan", "feb", "mar", "apr",
+		"may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{min: 0, max: 6, names: []string{"sun", "mon", "tue", "wed", "thu",
+		"fri", "sat"}},
+}
+
+func (r *cronRange) value(in string) (int, error) {
+	for i, name := range r.names {
+		if name != "" && strings.EqualFold(in, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(in)
+	if err != nil {
+		return 0, errors.New("invalid value " + in)
+	}
+	if v < r.min || v > r.max {
+		return 0, errors.New("value " + in + " out of range")
+	}
+	return v, nil
+}
+
+func parseCronField(in string, r *cronRange) (cronField, error) {
+	if in == "*" {
+		return cronField{any: true}, nil
+	}
+	f := cronField{values: make(map[int]bool)}
+	for _, term := range strings.Split(in, ",") {
+		step := 1
+		if i := strings.Index(term, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(term[i+1:])
+			if err != nil || step <= 0 {
+				return f, errors.New("invalid step in " + term)
+			}
+			term = term[:i]
+		}
+		lo, hi := r.min, r.max
+		if term != "*" {
+			bounds := strings.SplitN(term, "-", 2)
+			var err error
+			lo, err = r.value(bounds[0])
+			if err != nil {
+				return f, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = r.value(bounds[1])
+				if err != nil {
+					return f, err
+				}
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+func (f cronField) matches(v int) bool {
+	return f.any || f.values[v]
+}
+
+// cronExpr is a cron-like expression of the form 'minute hour
+// day-of-month month day-of-week'. It is used both to restrict when a
+// component may be activated, where e.g. '* 1-5 * * mon-fri' allows
+// activation between 01:00 and 05:59 on weekdays, and to schedule
+// oneshot components.
+type cronExpr struct {
+	spec   string
+	fields [5]cronField
+}
+
+func parseCronExpr(spec string) (*cronExpr, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, errors.New("must have 5 fields: " + spec)
+	}
+	e := &cronExpr{spec: spec}
+	for i, part := range parts {
+		f, err := parseCronField(part, &cronRanges[i])
+		if err != nil {
+			return nil, errors.New(spec + ": " + err.Error())
+		}
+		e.fields[i] = f
+	}
+	return e, nil
+}
+
+// contains reports whether t falls within a minute matched by the
+// expression. A nil expression contains all times.
+func (e *cronExpr) contains(t time.Time) bool {
+	if e == nil {
+		return true
+	}
+	if !e.fields[0].matches(t.Minute()) ||
+		!e.fields[1].matches(t.Hour()) ||
+		!e.fields[3].matches(int(t.Month())) {
+		return false
+	}
+	dom, dow := e.fields[2], e.fields[4]
+	// As with cron, when both days are restricted either may match.
+	if !dom.any && !dow.any {
+		return dom.matches(t.Day()) || dow.matches(int(t.Weekday()))
+	}
+	return dom.matches(t.Day()) && dow.matches(int(t.Weekday()))
+}
+
+// next returns the start of the first minute after t matched by the
+// expression, looking no more than a year ahead.
+func (e *cronExpr) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(1, 0, 0)
+	for ; t.Before(end); t = t.Add(time.Minute) {
+		if e.contains(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (e *cronExpr) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.spec
+}
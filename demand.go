@@ -0,0 +1,36 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+// OnDemand reports whether the component should be activated by the
+// first Config, State or RPC request it receives while inactive,
+// rather than by an explicit activation, as set by its OnDemand key.
+func (c *Component) OnDemand() bool {
+	return c.onDemand
+}
+
+// OnRequest registers a function to be called before the component
+// serves each Config, State or RPC request, such as to activate an
+// OnDemand component. If it fails the request fails with its error,
+// or for Config/Get and State/Get returns nothing, without running
+// the script.
+func OnRequest(fn func(*Component) error) Opt {
+	return func(c *Component) {
+		c.onRequest = fn
+	}
+}
+
+// request accounts for a Config, State or RPC request about to be
+// served, calling the component's OnRequest function.
+func (r *runner) request() error {
+	r.activity.touch()
+	return r.onRequest()
+}
+
+func (c *Component) requested() error {
+	if c.onRequest == nil {
+		return nil
+	}
+	return c.onRequest(c)
+}
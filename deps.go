@@ -0,0 +1,68 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strings"
+)
+
+const systemdPrefix = "systemd:"
+
+// parseAfter parses the space separated After key. Entries of the
+// form 'systemd:unit' name platform services that must be active
+// before the component is activated, and other entries name managed
+// components whose activation, if under way, it waits for.
+func parseAfter(name, s string) (units, comps []string, err error) {
+	for _, dep := range strings.Fields(s) {
+		if !strings.HasPrefix(dep, systemdPrefix) {
+			if strings.Contains(dep, ":") {
+				return nil, nil, errors.New(
+					"unsupported After dependency " + dep)
+			}
+			if dep == name {
+				return nil, nil, errors.New(
+					"component can't come After itself")
+			}
+			comps = append(comps, dep)
+			continue
+		}
+		unit := strings.TrimPrefix(dep, systemdPrefix)
+		if unit == "" {
+			return nil, nil, errors.New("After dependency " + dep +
+				" has no unit")
+		}
+		units = append(units, unit)
+	}
+	return units, comps, nil
+}
+
+// parseRequires parses the space separated Requires key, naming the
+// managed components that are activated before the component is.
+func parseRequires(name, s string) ([]string, error) {
+	var comps []string
+	for _, dep := range strings.Fields(s) {
+		if strings.Contains(dep, ":") {
+			return nil, errors.New("unsupported Requires dependency " +
+				dep + ", only components may be required")
+		}
+		if dep == name {
+			return nil, errors.New("component can't Require itself")
+		}
+		comps = append(comps, dep)
+	}
+	return comps, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// DropInSuffix is appended to an instance file's name to form the
+// directory holding its drop-in fragments.
+const DropInSuffix = ".d"
+
+// dropIns returns the fragments, *.conf files in the instance file's
+// drop-in directory, in the order they are applied.
+func dropIns(instanceFile string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(
+		instanceFile+DropInSuffix, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -6,34 +6,21 @@ package ephemera
 import (
 	"bytes"
 	"encoding/json"
-	"log"
-	"log/syslog"
+	"errors"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/danos/mgmterror"
 	"github.com/go-ini/ini"
 	"jsouthworth.net/go/dyn"
 )
 
-var (
-	elog *log.Logger
-	dlog *log.Logger
-)
-
-func init() {
-	var err error
-	elog, err = syslog.NewLogger(syslog.LOG_ERR, 0)
-	if err != nil {
-		elog = log.New(os.Stderr, "", 0)
-	}
-	dlog, err = syslog.NewLogger(syslog.LOG_DEBUG, 0)
-	if err != nil {
-		dlog = log.New(os.Stdout, "", 0)
-	}
-}
-
 type encodedString []byte
 
 func (s *encodedString) UnmarshalJSON(data []byte) error {
@@ -61,14 +48,25 @@ func (s encodedString) MarshalRFC7951() ([]byte, error) {
 }
 
 type config struct {
-	compName  string
+	runner    *runner
 	modelName string
+	cache     *configCache
 	get       string
 	set       string
 	check     string
+
+	// primed is the output of Config/Get run by Refresh, answering
+	// the next Get in place of running the script again.
+	mu     sync.Mutex
+	primed encodedString
 }
 
-func configNew(compName, modelName string, section *ini.Section) *config {
+func configNew(
+	r *runner,
+	cache *configCache,
+	modelName string,
+	section *ini.Section,
+) *config {
 	getKey := section.Key("Config/Get")
 	setKey := section.Key("Config/Set")
 	chkKey := section.Key("Config/Check")
@@ -76,8 +74,9 @@ func configNew(compName, modelName string, section *ini.Section) *config {
 		return nil
 	}
 	return &config{
-		compName:  compName,
+		runner:    r,
 		modelName: modelName,
+		cache:     cache,
 		get:       getKey.MustString(""),
 		set:       setKey.MustString(""),
 		check:     chkKey.MustString(""),
@@ -85,73 +84,73 @@ func configNew(compName, modelName string, section *ini.Section) *config {
 }
 
 func (c *config) Get() encodedString {
-	if c.get == "" {
-		//TODO: read/write cache from/to disk
+	buf, err := c.getConfig()
+	if err != nil {
 		return []byte{}
 	}
-	getArgs := strings.Split(c.get, " ")
-	stdErr := bytes.NewBuffer(nil)
-	cmd := exec.Command(getArgs[0], getArgs[1:]...)
-	cmd.Stderr = stdErr
-	cmd.Env = genEnvironment(c.compName, c.modelName, "Config/Get")
+	return buf
+}
 
-	buf, err := cmd.Output()
+// getConfig returns the model's configuration as Get does, along with
+// why it couldn't be got.
+func (c *config) getConfig() (encodedString, error) {
+	err := c.runner.request()
 	if err != nil {
-		merr := unpackError(stdErr)
-		elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-		return []byte{}
+		return nil, err
 	}
-	return buf
+	if c.get == "" {
+		if !featureEnabled(FeatureConfigCache) {
+			return []byte{}, nil
+		}
+		buf, err := c.cache.load(c.modelName)
+		if err != nil {
+			c.runner.logs.elog().Println("config cache:", err)
+		}
+		if buf == nil {
+			return []byte{}, nil
+		}
+		return buf, nil
+	}
+	if buf, ok := c.takePrimed(); ok {
+		return buf, nil
+	}
+	return c.runner.output(c.modelName, "Config/Get", c.get, nil)
 }
 
 func (c *config) Set(in encodedString) error {
-	if c.set == "" {
-		return nil
+	err := c.runner.request()
+	if err != nil {
+		return err
 	}
-	stdIn := bytes.NewBuffer([]byte(in))
-	stdErr := bytes.NewBuffer(nil)
-
-	setArgs := strings.Split(c.set, " ")
-	cmd := exec.Command(setArgs[0], setArgs[1:]...)
-	cmd.Stdin = stdIn
-	cmd.Stderr = stdErr
-	cmd.Env = genEnvironment(c.compName, c.modelName, "Config/Set")
-
-	out, err := cmd.Output()
-	if len(out) != 0 {
-		dlog.Printf("Output for %s\n%s\n", cmd.Env, string(out))
+	// The configuration is about to change, so what Refresh got is
+	// no longer current.
+	c.prime(nil)
+	if c.set != "" {
+		err := c.runner.run(c.modelName, "Config/Set", c.set, in)
+		if err != nil {
+			return err
+		}
 	}
-	if err != nil {
-		merr := unpackError(stdErr)
-		elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-		return merr
+	if c.get == "" && featureEnabled(FeatureConfigCache) {
+		// The backend has applied the configuration, so failing
+		// to cache it only affects what Get returns.
+		err := c.cache.store(c.modelName, in)
+		if err != nil {
+			c.runner.logs.elog().Println("config cache:", err)
+		}
 	}
 	return nil
 }
 
 func (c *config) Check(in encodedString) error {
+	err := c.runner.request()
+	if err != nil {
+		return err
+	}
 	if c.check == "" {
 		return nil
 	}
-	stdIn := bytes.NewBuffer([]byte(in))
-	stdErr := bytes.NewBuffer(nil)
-
-	checkArgs := strings.Split(c.check, " ")
-	cmd := exec.Command(checkArgs[0], checkArgs[1:]...)
-	cmd.Stdin = stdIn
-	cmd.Stderr = stdErr
-	cmd.Env = genEnvironment(c.compName, c.modelName, "Config/Check")
-
-	out, err := cmd.Output()
-	if len(out) != 0 {
-		dlog.Printf("Output for %s\n%s\n", cmd.Env, string(out))
-	}
-	if err != nil {
-		merr := unpackError(stdErr)
-		elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-		return merr
-	}
-	return nil
+	return c.runner.run(c.modelName, "Config/Check", c.check, in)
 }
 
 func (c *config) Equal(other interface{}) bool {
@@ -162,64 +161,138 @@ func (c *config) Equal(other interface{}) bool {
 		c.check == oc.check
 }
 
+const defaultStateNegativeTTL = time.Second
+
 type state struct {
-	compName  string
+	runner    *runner
 	modelName string
 	get       string
+	// negativeTTL is how long a failure of State/Get is remembered,
+	// answering polls with no state without running the script.
+	negativeTTL time.Duration
+	// refreshInterval is how often State/Get is run in the
+	// background while the component is active, polls being answered
+	// with its last output.
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	failedUntil time.Time
+	cached      encodedString
+	cachedAt    time.Time
+	refreshDone chan struct{}
+	// refreshExited is closed once the refresh loop has returned.
+	refreshExited chan struct{}
 }
 
-func stateNew(compName, modelName string, section *ini.Section) *state {
+func stateNew(r *runner, modelName string, section *ini.Section) *state {
 	getKey := section.Key("State/Get")
 	if getKey == nil {
 		return nil
 	}
 	return &state{
-		compName:  compName,
+		runner:    r,
 		modelName: modelName,
 		get:       getKey.MustString(""),
+		negativeTTL: section.Key("State/Get/NegativeTTL").
+			MustDuration(defaultStateNegativeTTL),
+		refreshInterval: section.Key("State/RefreshInterval").
+			MustDuration(0),
 	}
 }
 
 func (c *state) Get() encodedString {
-	if c.get == "" {
+	buf, err := c.getState()
+	if err != nil {
 		return []byte{}
 	}
-	getArgs := strings.Split(c.get, " ")
-	stdErr := bytes.NewBuffer(nil)
-	cmd := exec.Command(getArgs[0], getArgs[1:]...)
-	cmd.Stderr = stdErr
-	cmd.Env = genEnvironment(c.compName, c.modelName, "State/Get")
+	return buf
+}
 
-	buf, err := cmd.Output()
+// getState returns the model's state as Get does, along with why it
+// couldn't be got.
+func (c *state) getState() (encodedString, error) {
+	err := c.runner.request()
 	if err != nil {
-		merr := unpackError(stdErr)
-		elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-		return []byte{}
+		return nil, err
 	}
-	return buf
+	if c.get == "" {
+		return []byte{}, nil
+	}
+	if buf, ok := c.fresh(); ok {
+		return buf, nil
+	}
+	if c.recentlyFailed() {
+		return nil, errors.New("State/Get failed within its " +
+			"NegativeTTL of " + c.negativeTTL.String())
+	}
+	buf, err := c.runner.output(c.modelName, "State/Get", c.get, nil)
+	if err != nil {
+		c.failed()
+		return nil, err
+	}
+	c.store(buf)
+	return buf, nil
+}
+
+func (c *state) recentlyFailed() bool {
+	if !featureEnabled(FeatureStateNegativeCache) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.failedUntil)
+}
+
+func (c *state) failed() {
+	if c.negativeTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.failedUntil = time.Now().Add(c.negativeTTL)
+	c.mu.Unlock()
+}
+
+// forget drops any remembered failure or output of State/Get.
+func (c *state) forget() {
+	c.mu.Lock()
+	c.failedUntil = time.Time{}
+	c.cached = nil
+	c.cachedAt = time.Time{}
+	c.mu.Unlock()
 }
 
 func (c *state) Equal(other interface{}) bool {
 	os, isState := other.(*state)
 	return isState &&
-		c.get == os.get
+		c.get == os.get &&
+		c.negativeTTL == os.negativeTTL &&
+		c.refreshInterval == os.refreshInterval
 }
 
 type rpc struct {
-	compName  string
+	runner    *runner
 	modelName string
 	modules   map[string]map[string]string
+	// options holds per RPC settings keyed by module/name/option,
+	// from keys of the form RPC/module/name/option.
+	options map[string]string
+	cache   *rpcCache
 }
 
-func rpcNew(compName, modelName string, section *ini.Section) *rpc {
+func rpcNew(r *runner, modelName string, section *ini.Section) *rpc {
 	modules := make(map[string]map[string]string)
+	options := make(map[string]string)
 	for _, key := range section.Keys() {
 		if !strings.HasPrefix(key.Name(), "RPC/") {
 			continue
 		}
 		parts := strings.Split(key.Name(), "/")
+		if len(parts) == 4 {
+			options[strings.Join(parts[1:], "/")] = key.String()
+			continue
+		}
 		if len(parts) != 3 {
-			dlog.Println("skipping", parts)
+			r.logs.dlog().Println("skipping", parts)
 			continue
 		}
 		module, name := parts[1], parts[2]
@@ -234,30 +307,58 @@ func rpcNew(compName, modelName string, section *ini.Section) *rpc {
 		return nil
 	}
 	return &rpc{
-		compName:  compName,
+		runner:    r,
 		modelName: modelName,
 		modules:   modules,
+		options:   options,
+		cache:     rpcCacheNew(),
 	}
 }
 
+func (r *rpc) option(module, name, option string) string {
+	return r.options[strings.Join([]string{module, name, option}, "/")]
+}
+
+func (r *rpc) cacheTTL(module, name string) time.Duration {
+	ttl, err := time.ParseDuration(r.option(module, name, "CacheTTL"))
+	if err != nil {
+		return 0
+	}
+	return ttl
+}
+
 func (r *rpc) genRpc(module, name, rpc string) interface{} {
+	return r.call(module, name, rpc)
+}
+
+// call returns the function running the RPC module/name with the
+// script rpc.
+func (r *rpc) call(
+	module, name, rpc string,
+) func(meta, in encodedString) (encodedString, error) {
+	ttl := r.cacheTTL(module, name)
 	return func(meta, in encodedString) (encodedString, error) {
-		stdIn := bytes.NewBuffer([]byte(in))
-		stdErr := bytes.NewBuffer(nil)
-
-		args := strings.Split(rpc, " ")
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdin = stdIn
-		cmd.Stderr = stdErr
-		cmd.Env = genEnvironment(r.compName, r.modelName,
-			strings.Join([]string{"RPC", module, name}, "/"))
-		cmd.Env = append(cmd.Env, "VCI_RPC_METADATA="+string(meta))
-
-		out, err := cmd.Output()
+		err := r.runner.request()
+		if err != nil {
+			return []byte{}, err
+		}
+		var key rpcCacheKey
+		cached := ttl > 0 && featureEnabled(FeatureRPCCache)
+		if cached {
+			key = r.cache.key(module, name, in)
+			out, ok := r.cache.get(key)
+			if ok {
+				return out, nil
+			}
+		}
+		out, err := r.runner.output(r.modelName,
+			strings.Join([]string{"RPC", module, name}, "/"),
+			rpc, in, "VCI_RPC_METADATA="+string(meta))
 		if err != nil {
-			merr := unpackError(stdErr)
-			elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-			return []byte{}, merr
+			return []byte{}, err
+		}
+		if cached {
+			r.cache.put(key, out, ttl)
 		}
 		return out, nil
 	}
@@ -279,9 +380,15 @@ func (r *rpc) genRpcs() map[string]map[string]interface{} {
 
 func (r *rpc) Equal(other interface{}) bool {
 	or, isRPC := other.(*rpc)
-	if !isRPC || len(or.modules) != len(r.modules) {
+	if !isRPC || len(or.modules) != len(r.modules) ||
+		len(or.options) != len(r.options) {
 		return false
 	}
+	for key, value := range r.options {
+		if or.options[key] != value {
+			return false
+		}
+	}
 	for mod, names := range r.modules {
 		oNames, ok := or.modules[mod]
 		if !ok {
@@ -308,13 +415,47 @@ func (r *rpc) Equal(other interface{}) bool {
 }
 
 type Model struct {
-	name string
+	name       string
+	enabled    bool
+	statePaths []string
+	timeouts   map[string]time.Duration
 
 	config *config
 	state  *state
 	rpc    *rpc
 }
 
+// StatePaths are the YANG paths whose state the model serves, as
+// declared by its StatePaths key, so that telemetry subscriptions can
+// be mapped to the component serving them.
+func (c *Model) StatePaths() []string {
+	return c.statePaths
+}
+
+// Warm runs the model's State/Get straight away, ahead of the first
+// poll, so that a telemetry subscription to one of its StatePaths
+// isn't held up by a slow backend. Its output is cached for models
+// with a State/RefreshInterval, and a failure is remembered for its
+// NegativeTTL.
+func (c *Model) Warm() error {
+	s := c.state
+	if !c.enabled || s == nil || s.get == "" {
+		return nil
+	}
+	buf, err := s.runner.output(s.modelName, "State/Get", s.get, nil)
+	if err != nil {
+		s.failed()
+		return err
+	}
+	s.store(buf)
+	return nil
+}
+
+// Enabled reports whether the model should be registered on the bus.
+func (c *Model) Enabled() bool {
+	return c.enabled
+}
+
 func (c *Model) Config() (interface{}, bool) {
 	return c.config, c.config != nil
 }
@@ -327,48 +468,351 @@ func (c *Model) RPC() (map[string]map[string]interface{}, bool) {
 	return c.rpc.genRpcs(), c.rpc != nil
 }
 
+// RPCNames returns the names of the RPCs of module the model
+// implements in sorted order.
+func (c *Model) RPCNames(module string) []string {
+	if c.rpc == nil {
+		return nil
+	}
+	names := make([]string, 0, len(c.rpc.modules[module]))
+	for name := range c.rpc.modules[module] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RPCDescription says what the RPC module/name does, as given by its
+// RPC/module/name/Description key.
+func (c *Model) RPCDescription(module, name string) string {
+	if c.rpc == nil {
+		return ""
+	}
+	return c.rpc.option(module, name, "Description")
+}
+
+// RPCDocURL locates the documentation of the RPC module/name, as given
+// by its RPC/module/name/DocURL key.
+func (c *Model) RPCDocURL(module, name string) string {
+	if c.rpc == nil {
+		return ""
+	}
+	return c.rpc.option(module, name, "DocURL")
+}
+
+// RPCModules returns the names of the modules whose RPCs the model
+// implements in sorted order.
+func (c *Model) RPCModules() []string {
+	if c.rpc == nil {
+		return nil
+	}
+	modules := make([]string, 0, len(c.rpc.modules))
+	for module := range c.rpc.modules {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return modules
+}
+
 func (c *Model) Equal(other interface{}) bool {
 	om, isModel := other.(*Model)
 	return isModel &&
 		c.name == om.name &&
+		c.enabled == om.enabled &&
+		equalStrings(c.statePaths, om.statePaths) &&
+		equalTimeouts(c.timeouts, om.timeouts) &&
 		dyn.Equal(c.config, om.config) &&
 		dyn.Equal(c.state, om.state) &&
 		dyn.Equal(c.rpc, om.rpc)
 }
 
-func modelNew(compName, name string, section *ini.Section) *Model {
-	m := &Model{name: name}
-	m.config = configNew(compName, name, section)
-	m.state = stateNew(compName, name, section)
-	m.rpc = rpcNew(compName, name, section)
+func modelNew(
+	r *runner,
+	cache *configCache,
+	name string,
+	section *ini.Section,
+) *Model {
+	m := &Model{
+		name:       name,
+		enabled:    section.Key("Enabled").MustBool(true),
+		statePaths: strings.Fields(section.Key("StatePaths").String()),
+		timeouts:   parseOperationTimeouts(section),
+	}
+	if r.modelTimeouts == nil {
+		r.modelTimeouts = make(map[string]map[string]time.Duration)
+	}
+	r.modelTimeouts[name] = m.timeouts
+	m.config = configNew(r, cache, name, section)
+	m.state = stateNew(r, name, section)
+	m.rpc = rpcNew(r, name, section)
 	return m
 }
 
+// Type describes how a component is run when it is activated.
+type Type int
+
+const (
+	// TypeSimple components run Start and then serve their models
+	// on the bus until deactivated.
+	TypeSimple Type = iota
+	// TypeOneshot components run Start to completion on activation
+	// and are not considered running afterwards.
+	TypeOneshot
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeSimple:
+		return "simple"
+	case TypeOneshot:
+		return "oneshot"
+	default:
+		return "unknown"
+	}
+}
+
+func parseType(s string) (Type, error) {
+	switch s {
+	case "", "simple":
+		return TypeSimple, nil
+	case "oneshot":
+		return TypeOneshot, nil
+	default:
+		return TypeSimple, errors.New("unknown component Type " + s)
+	}
+}
+
 type Component struct {
 	instanceFile string
+	keystoreDir  string
+	lockDir      string
+	cacheDir     string
 	name         string
+	owner        string
+	runner       *runner
+	breaker      *breaker
+	tracer       *tracer
+	stats        *opStats
+	strict       bool
+
+	formatVersion int
+	enabled       bool
+	typ           Type
+	start         string
+	stop          string
+	failurePolicy FailurePolicy
+	activeOnly    bool
+	onActive      string
+	onStandby     string
+	activeWindow  *cronExpr
+	schedule      *schedule
+	afterUnits    []string
+	afterComps    []string
+	requires      []string
+	assets        map[string]string
+	params        *scriptParams
+	sessions      bool
+	sessionLease  time.Duration
+	idleTimeout   time.Duration
+	onDemand      bool
+	bus           string
+	models        map[string]*Model
+
+	restart         RestartPolicy
+	restartDelay    time.Duration
+	restartMaxDelay time.Duration
+	restartOnChange bool
+
+	deprecatedSince    string
+	replacedBy         string
+	redirectActivation bool
 
-	start  string
-	stop   string
-	models map[string]*Model
+	description string
+	docURL      string
+
+	bases   []string
+	dropIns []string
+
+	resolveCommands bool
+	logs            *loggers
+	onRequest       func(*Component) error
 }
 
 func (c *Component) instantiate() error {
-	cfg, err := ini.Load(c.instanceFile)
+	cfg, sources, err := loadInstance(c.instanceFile)
+	if err != nil {
+		return err
+	}
+	c.bases = sources.bases
+	c.dropIns = sources.dropIns
+	c.formatVersion, err = migrate(cfg)
+	if err != nil {
+		return err
+	}
+	if c.strict {
+		err = InstanceSchema.validate(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	err = expandLegacyWrappers(cfg, c.logs, c.strict)
 	if err != nil {
 		return err
 	}
 	c.name = cfg.Section("Component").Key("Name").MustString("")
+	c.owner = cfg.Section("Component").Key("Owner").MustString("")
+	c.description = cfg.Section("Component").Key("Description").
+		MustString("")
+	c.docURL = cfg.Section("Component").Key("DocURL").MustString("")
+	c.deprecatedSince = cfg.Section("Component").Key("DeprecatedSince").
+		MustString("")
+	c.replacedBy = cfg.Section("Component").Key("ReplacedBy").
+		MustString("")
+	c.redirectActivation = cfg.Section("Component").
+		Key("RedirectActivation").MustBool(false)
+	if c.redirectActivation && c.replacedBy == "" {
+		return errors.New("RedirectActivation requires ReplacedBy")
+	}
 	c.start = cfg.Section("Component").Key("Start").MustString("")
 	c.stop = cfg.Section("Component").Key("Stop").MustString("")
+	c.enabled = cfg.Section("Component").Key("Enabled").MustBool(true)
+	c.typ, err = parseType(cfg.Section("Component").Key("Type").String())
+	if err != nil {
+		return err
+	}
+	c.activeOnly = cfg.Section("Component").Key("ActiveOnly").MustBool(false)
+	c.onActive = cfg.Section("Component").Key("OnActive").MustString("")
+	c.onStandby = cfg.Section("Component").Key("OnStandby").MustString("")
+	c.activeWindow, err = parseCronExpr(
+		cfg.Section("Component").Key("ActiveWindow").String())
+	if err != nil {
+		return errors.New("ActiveWindow " + err.Error())
+	}
+	c.schedule, err = parseSchedule(cfg.Section("Component"))
+	if err != nil {
+		return err
+	}
+	c.afterUnits, c.afterComps, err = parseAfter(c.name,
+		cfg.Section("Component").Key("After").String())
+	if err != nil {
+		return err
+	}
+	c.requires, err = parseRequires(c.name,
+		cfg.Section("Component").Key("Requires").String())
+	if err != nil {
+		return err
+	}
+	c.assets, err = parseAssets(c.instanceFile,
+		cfg.Section("Component").Key("Assets").String())
+	if err != nil {
+		return err
+	}
+	c.sessions = cfg.Section("Component").Key("Sessions").MustBool(false)
+	c.sessionLease = cfg.Section("Component").Key("SessionLease").
+		MustDuration(defaultSessionLease)
+	c.idleTimeout = cfg.Section("Component").Key("IdleTimeout").
+		MustDuration(0)
+	c.onDemand = cfg.Section("Component").Key("OnDemand").MustBool(false)
+	c.bus = cfg.Section("Component").Key("Bus").String()
+	c.restart, err = parseRestartPolicy(
+		cfg.Section("Component").Key("Restart").String())
+	if err != nil {
+		return err
+	}
+	c.restartDelay = cfg.Section("Component").Key("RestartSec").
+		MustDuration(defaultRestartDelay)
+	c.restartMaxDelay = cfg.Section("Component").Key("RestartMaxSec").
+		MustDuration(defaultRestartMaxDelay)
+	c.restartOnChange = cfg.Section("Component").Key("RestartOnChange").
+		MustBool(false)
+	c.params, err = parseParameters(
+		cfg.Section("Component").Key("Parameters").String())
+	if err != nil {
+		return err
+	}
+	if c.schedule != nil && c.typ != TypeOneshot {
+		return errors.New(
+			"OnCalendar and Interval require Type=oneshot")
+	}
+	if c.onDemand && c.typ == TypeOneshot {
+		return errors.New("OnDemand requires Type=simple")
+	}
+	if c.restart != RestartNo && c.typ == TypeOneshot {
+		return errors.New("Restart requires Type=simple")
+	}
+	if c.restartOnChange && c.typ == TypeOneshot {
+		return errors.New("RestartOnChange requires Type=simple")
+	}
+	c.failurePolicy, err = parseFailurePolicy(
+		cfg.Section("Component").Key("OnRepeatedFailure").String())
+	if err != nil {
+		return err
+	}
+	threshold := 0
+	if c.failurePolicy != FailureIgnore {
+		threshold = defaultFailureThreshold
+	}
+	c.breaker.threshold = cfg.Section("Component").
+		Key("FailureThreshold").MustInt(threshold)
+	c.breaker.cooldown = cfg.Section("Component").
+		Key("FailureCooldown").MustDuration(defaultFailureCooldown)
+	maxOps := cfg.Section("Component").Key("MaxConcurrentOps").MustInt(0)
+	maxQueued := cfg.Section("Component").Key("MaxQueuedOps").MustInt(0)
+	protocolVersion, err := negotiateProtocolVersion(
+		cfg.Section("Component"))
+	if err != nil {
+		return err
+	}
+	c.runner = &runner{
+		compName:        c.name,
+		protocolVersion: protocolVersion,
+		breaker:         c.breaker,
+		trace:           c.tracer,
+		stats:           c.stats,
+		usage:           &scriptUsage{},
+		activity:        &activity{},
+		onRequest:       c.requested,
+		limiter:         opLimiterNew(maxOps, maxQueued),
+		timeouts:        parseTimeouts(cfg.Section("Component")),
+		logs:            c.logs,
+		lock: componentLockNew(c.lockDir, c.name,
+			cfg.Section("Component").Key("AutoLock").MustBool(false)),
+	}
+	c.runner.limiter.backpressure = cfg.Section("Component").
+		Key("BackpressureThreshold").MustInt(0)
+	c.runner.credentials, err = parseCredentials(cfg.Section("Component"))
+	if err != nil {
+		return err
+	}
+	c.runner.ambientCaps, err = parseCapabilities(
+		cfg.Section("Component").Key("AmbientCapabilities").String())
+	if err != nil {
+		return err
+	}
+	c.runner.secrets, err = secretEnvNew(c.keystoreDir,
+		cfg.Section("Component"))
+	if err != nil {
+		return err
+	}
+	logFile := cfg.Section("Component").Key("LogFile").MustString("")
+	if logFile != "" {
+		c.runner.log = &scriptLog{
+			path: logFile,
+			logs: c.logs,
+			maxSize: cfg.Section("Component").Key("LogFileMaxSize").
+				MustInt64(defaultLogFileMaxSize),
+		}
+	}
+	cache := configCacheNew(c.cacheDir, c.name)
 	for _, section := range cfg.Sections() {
 		if !strings.HasPrefix(section.Name(), "Model ") {
 			continue
 		}
 		modelName := strings.Split(section.Name(), " ")[1]
-		c.models[modelName] = modelNew(c.name, modelName, section)
+		c.models[modelName] = modelNew(c.runner, cache, modelName,
+			section)
 	}
-	return nil
+	return c.validate(cfg)
 }
 
 func (c *Component) Name() string {
@@ -379,61 +823,372 @@ func (c *Component) Models() map[string]*Model {
 	return c.models
 }
 
+// ModelNames returns the names of the component's models in sorted
+// order, the order in which they are registered on the bus.
+func (c *Component) ModelNames() []string {
+	names := make([]string, 0, len(c.models))
+	for name := range c.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CircuitState reports whether the component's scripts are currently
+// being short-circuited due to repeated failures.
+func (c *Component) CircuitState() CircuitState {
+	return c.breaker.State()
+}
+
+// ResetCircuit closes the component's circuit, forgetting the failures
+// counted so far, so that its scripts are run again at once, as when it
+// is restarted because the circuit opened.
+func (c *Component) ResetCircuit() {
+	c.breaker.reset()
+}
+
+// FailurePolicy is what the component asked to happen to it once its
+// circuit opens.
+func (c *Component) FailurePolicy() FailurePolicy {
+	return c.failurePolicy
+}
+
 func (c *Component) Equal(other interface{}) bool {
 	oc, isComponent := other.(*Component)
 	return isComponent &&
 		c.name == oc.name &&
+		c.formatVersion == oc.formatVersion &&
+		c.runner.protocolVersion == oc.runner.protocolVersion &&
+		c.owner == oc.owner &&
+		c.description == oc.description &&
+		c.docURL == oc.docURL &&
+		c.deprecatedSince == oc.deprecatedSince &&
+		c.replacedBy == oc.replacedBy &&
+		c.redirectActivation == oc.redirectActivation &&
 		c.start == oc.start &&
 		c.stop == oc.stop &&
+		c.enabled == oc.enabled &&
+		c.typ == oc.typ &&
+		c.breaker.threshold == oc.breaker.threshold &&
+		c.breaker.cooldown == oc.breaker.cooldown &&
+		c.failurePolicy == oc.failurePolicy &&
+		c.runner.log.Equal(oc.runner.log) &&
+		c.runner.limiter.maxInFlight == oc.runner.limiter.maxInFlight &&
+		c.runner.limiter.maxQueued == oc.runner.limiter.maxQueued &&
+		c.runner.limiter.backpressure ==
+			oc.runner.limiter.backpressure &&
+		c.runner.timeouts == oc.runner.timeouts &&
+		c.runner.lock.Equal(oc.runner.lock) &&
+		equalCapabilities(c.runner.ambientCaps, oc.runner.ambientCaps) &&
+		c.runner.credentials.Equal(oc.runner.credentials) &&
+		c.runner.secrets.Equal(oc.runner.secrets) &&
+		c.activeOnly == oc.activeOnly &&
+		c.onActive == oc.onActive &&
+		c.onStandby == oc.onStandby &&
+		c.activeWindow.String() == oc.activeWindow.String() &&
+		c.schedule.Equal(oc.schedule) &&
+		equalStrings(c.afterUnits, oc.afterUnits) &&
+		equalStrings(c.afterComps, oc.afterComps) &&
+		equalStrings(c.requires, oc.requires) &&
+		equalAssets(c.assets, oc.assets) &&
+		c.params.Equal(oc.params) &&
+		c.sessions == oc.sessions &&
+		c.sessionLease == oc.sessionLease &&
+		c.idleTimeout == oc.idleTimeout &&
+		c.onDemand == oc.onDemand &&
+		c.bus == oc.bus &&
+		c.restart == oc.restart &&
+		c.restartDelay == oc.restartDelay &&
+		c.restartMaxDelay == oc.restartMaxDelay &&
+		c.restartOnChange == oc.restartOnChange &&
 		c.equalModels(oc)
 }
 
 func (c *Component) Start() error {
-	if c.start == "" {
+	if c.start != "" {
+		in, env := c.params.input()
+		err := c.runner.run("", "Start", c.start, in, env...)
+		if err != nil {
+			return err
+		}
+	}
+	c.startStateRefresh()
+	return nil
+}
+
+// SetParameters sets the parameters subsequent runs of the Start
+// script are given on standard input and in the environment. Each
+// must be declared by the component's Parameters key.
+func (c *Component) SetParameters(params map[string]string) error {
+	return c.params.set(params)
+}
+
+// Parameters returns the parameters last set with SetParameters.
+func (c *Component) Parameters() map[string]string {
+	return c.params.get()
+}
+
+func (c *Component) Stop() error {
+	return c.StopWithParameters(nil)
+}
+
+// StopWithParameters runs the Stop script given params, like those of
+// SetParameters, so that it can limit what it cleans up, for example
+// to the session started by the caller.
+func (c *Component) StopWithParameters(params map[string]string) error {
+	err := c.params.check(params)
+	if err != nil {
+		return err
+	}
+	c.stopStateRefresh()
+	if c.stop == "" {
 		return nil
 	}
-	startArgs := strings.Split(c.start, " ")
-	stdErr := bytes.NewBuffer(nil)
-	cmd := exec.Command(startArgs[0], startArgs[1:]...)
-	cmd.Stderr = stdErr
-	cmd.Env = genEnvironment(c.name, "", "Start")
+	in, env := encodeParameters(params)
+	return c.runner.run("", "Stop", c.stop, in, env...)
+}
+
+// Owner names the subsystem or feature that installed the component,
+// as given by its Owner key.
+func (c *Component) Owner() string {
+	return c.owner
+}
+
+// Description says what the component does, as given by its
+// Description key.
+func (c *Component) Description() string {
+	return c.description
+}
+
+// DocURL locates the component's documentation, as given by its
+// DocURL key.
+func (c *Component) DocURL() string {
+	return c.docURL
+}
 
-	buf, err := cmd.Output()
-	if len(buf) != 0 {
-		dlog.Printf("Output for %s\n%s\n", cmd.Env, string(buf))
+// Bus names the bus the component's models are registered on, as
+// given by its Bus key. It is empty for the default, system, bus.
+func (c *Component) Bus() string {
+	return c.bus
+}
+
+// Deprecated reports whether the component is deprecated, either
+// since DeprecatedSince or in favour of its replacement.
+func (c *Component) Deprecated() bool {
+	return c.deprecatedSince != "" || c.replacedBy != ""
+}
+
+// DeprecatedSince is the release, or date, the component was
+// deprecated in.
+func (c *Component) DeprecatedSince() string {
+	return c.deprecatedSince
+}
+
+// ReplacedBy names the component replacing this one.
+func (c *Component) ReplacedBy() string {
+	return c.replacedBy
+}
+
+// RedirectActivation reports whether requests to activate the
+// component should activate its replacement instead.
+func (c *Component) RedirectActivation() bool {
+	return c.redirectActivation
+}
+
+// InstanceFile is the file the component was loaded from.
+func (c *Component) InstanceFile() string {
+	return c.instanceFile
+}
+
+// DropIns are the fragments merged over the instance file and its
+// bases, in the order they were applied.
+func (c *Component) DropIns() []string {
+	return c.dropIns
+}
+
+// Bases are the instance files the component's instance file inherits
+// from through BaseInstance, the most distant first.
+func (c *Component) Bases() []string {
+	return c.bases
+}
+
+// Sessions reports whether each activation of the component starts a
+// separate session, such as a packet capture, on the running
+// component.
+func (c *Component) Sessions() bool {
+	return c.sessions
+}
+
+// SessionLease is how long a session lasts unless it is renewed.
+func (c *Component) SessionLease() time.Duration {
+	return c.sessionLease
+}
+
+// StartSession runs the Start script for the session id, given params
+// like those of SetParameters and the id as EPHEMERA_SESSION_ID.
+func (c *Component) StartSession(id string, params map[string]string) error {
+	err := c.params.check(params)
+	if err != nil || c.start == "" {
+		return err
 	}
+	in, env := sessionParameters(id, params)
+	return c.runner.run("", "Start", c.start, in, env...)
+}
 
-	if err == nil {
-		return nil
+// StopSession runs the Stop script to tear down the session id.
+func (c *Component) StopSession(id string, params map[string]string) error {
+	err := c.params.check(params)
+	if err != nil || c.stop == "" {
+		return err
 	}
+	in, env := sessionParameters(id, params)
+	return c.runner.run("", "Stop", c.stop, in, env...)
+}
 
-	merr := unpackError(stdErr)
-	elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-	return merr
+// Operations returns how many of the component's scripts are running
+// and how many operations are waiting for MaxConcurrentOps to allow
+// them to run.
+func (c *Component) Operations() (inFlight, queued int) {
+	return c.runner.limiter.counts()
 }
 
-func (c *Component) Stop() error {
-	if c.stop == "" {
-		return nil
+// Usage returns the resources used by the component's scripts since
+// it was loaded.
+func (c *Component) Usage() ScriptUsage {
+	return c.runner.usage.get()
+}
+
+// Overloaded reports whether at least BackpressureThreshold
+// operations are waiting to run, in which case scripts are started
+// with EPHEMERA_BACKPRESSURE=1.
+func (c *Component) Overloaded() bool {
+	return c.runner.limiter.overloaded()
+}
+
+// Stats returns counts and latencies of the invocations of each of
+// the component's operations, keyed by operation such as "Start" or
+// "RPC/module/name".
+func (c *Component) Stats() map[string]OperationStats {
+	return c.stats.get()
+}
+
+// SetTrace turns recording of every script invocation, with its full
+// input, output and timing, on or off. Turning it off discards the
+// recorded invocations.
+func (c *Component) SetTrace(enabled bool) {
+	c.tracer.setEnabled(enabled)
+}
+
+// Tracing reports whether script invocations are being recorded.
+func (c *Component) Tracing() bool {
+	return c.tracer.isEnabled()
+}
+
+// Trace returns the most recent script invocations recorded while
+// tracing was enabled, oldest first.
+func (c *Component) Trace() []TraceEntry {
+	return c.tracer.get()
+}
+
+// Logs returns up to the last n lines of script output captured in
+// the component's LogFile.
+func (c *Component) Logs(n int) ([]string, error) {
+	if c.runner.log == nil {
+		err := mgmterror.NewOperationFailedApplicationError()
+		err.Message = "component " + c.name + " has no LogFile"
+		return nil, err
 	}
-	stopArgs := strings.Split(c.stop, " ")
-	stdErr := bytes.NewBuffer(nil)
-	cmd := exec.Command(stopArgs[0], stopArgs[1:]...)
-	cmd.Stderr = stdErr
-	cmd.Env = genEnvironment(c.name, "", "Stop")
+	return c.runner.log.tail(n)
+}
+
+// FormatVersion is the instance file format version the component
+// was written for. Files written for older versions are migrated to
+// CurrentFormatVersion as they are loaded.
+func (c *Component) FormatVersion() int {
+	return c.formatVersion
+}
 
-	buf, err := cmd.Output()
-	if len(buf) != 0 {
-		dlog.Printf("Output for %s\n%s\n", cmd.Env, string(buf))
+// ProtocolVersion is the version of the script environment contract
+// negotiated with the component's ProtocolVersion key.
+func (c *Component) ProtocolVersion() int {
+	return c.runner.protocolVersion
+}
+
+// Enabled reports whether the instance file allows the component to
+// be activated.
+func (c *Component) Enabled() bool {
+	return c.enabled
+}
+
+// Type is how the component is run when activated.
+func (c *Component) Type() Type {
+	return c.typ
+}
+
+// ActiveOnly reports whether the component should only be active
+// while this router is the active member of an HA pair.
+func (c *Component) ActiveOnly() bool {
+	return c.activeOnly
+}
+
+// AppTagPolicyDenied is the error-app-tag of the error activating a
+// component fails with when its policy doesn't allow it.
+const AppTagPolicyDenied = "policy-denied"
+
+// CheckActiveWindow returns a policy error if the component may not
+// be activated at time t due to its ActiveWindow.
+func (c *Component) CheckActiveWindow(t time.Time) error {
+	if c.activeWindow.contains(t) {
+		return nil
 	}
+	err := mgmterror.NewAccessDeniedApplicationError()
+	err.AppTag = AppTagPolicyDenied
+	err.Message = message(MsgOutsideActiveWindow,
+		"component", c.name, "window", c.activeWindow.String())
+	return err
+}
 
-	if err == nil {
+// AfterUnits are the systemd units that must be active before the
+// component is activated.
+func (c *Component) AfterUnits() []string {
+	return c.afterUnits
+}
+
+// AfterComponents are the managed components whose activation, if it
+// is under way, the component's activation waits for.
+func (c *Component) AfterComponents() []string {
+	return c.afterComps
+}
+
+// Requires are the managed components activated before the component
+// is. They are also ordered before it, as if listed in After.
+func (c *Component) Requires() []string {
+	return c.requires
+}
+
+// NextRun returns when ephemerad should next run the component on
+// its own after a run at, or the daemon starting at, time t. It
+// returns false if the component has no schedule.
+func (c *Component) NextRun(t time.Time) (time.Time, bool) {
+	return c.schedule.next(t)
+}
+
+// BecomeActive runs the component's OnActive script, if it has one,
+// when the router transitions to the HA active state.
+func (c *Component) BecomeActive() error {
+	if c.onActive == "" {
 		return nil
 	}
+	return c.runner.run("", "OnActive", c.onActive, nil)
+}
 
-	merr := unpackError(stdErr)
-	elog.Printf("Error for %s: %s / %s\n", cmd.Env, merr, err)
-	return merr
+// BecomeStandby runs the component's OnStandby script, if it has one,
+// when the router transitions to the HA standby state.
+func (c *Component) BecomeStandby() error {
+	if c.onStandby == "" {
+		return nil
+	}
+	return c.runner.run("", "OnStandby", c.onStandby, nil)
 }
 
 func (c *Component) equalModels(other *Component) bool {
@@ -453,11 +1208,204 @@ func (c *Component) equalModels(other *Component) bool {
 	return true
 }
 
-func genEnvironment(compName, modelName, operation string) []string {
+// runner executes the scripts named in an instance file on behalf of
+// a component and its models.
+type runner struct {
+	compName        string
+	protocolVersion int
+	breaker         *breaker
+	trace           *tracer
+	stats           *opStats
+	usage           *scriptUsage
+	activity        *activity
+	onRequest       func() error
+	limiter         *opLimiter
+	timeouts        Timeouts
+	modelTimeouts   map[string]map[string]time.Duration
+	lock            *componentLock
+	log             *scriptLog
+	ambientCaps     []uintptr
+	credentials     *credentials
+	secrets         *secretEnv
+	logs            *loggers
+}
+
+// sysProcAttr describes the credentials and capabilities the
+// component's scripts are run with.
+func (r *runner) sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		AmbientCaps: r.ambientCaps,
+		Credential:  r.credentials.cred,
+		// Each script leads its own process group so that
+		// processes it leaves behind can be attributed to it.
+		Setpgid: true,
+	}
+}
+
+func (r *runner) execute(
+	modelName, operation, command string,
+	in []byte,
+	env ...string,
+) ([]byte, *scriptEvent, error) {
+	begin := time.Now()
+	ev := &scriptEvent{
+		logs:      r.logs,
+		compName:  r.compName,
+		modelName: modelName,
+		operation: operation,
+		environ: append(genEnvironment(r.compName, modelName, operation,
+			r.protocolVersion), env...),
+		begin:    begin,
+		exitCode: -1,
+	}
+	timeout := r.timeout(modelName, operation)
+	err := r.breaker.allow()
+	if err != nil {
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	// If the script isn't run its result can't decide a trial.
+	recorded := false
+	defer func() {
+		if !recorded {
+			r.breaker.release()
+		}
+	}()
+
+	err = r.limiter.acquire(r.compName)
+	if err != nil {
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	defer r.limiter.release()
+	if r.limiter.overloaded() {
+		ev.environ = append(ev.environ, "EPHEMERA_BACKPRESSURE=1")
+	}
+
+	args, err := splitCommand(command)
+	if err != nil {
+		err = commandError(operation, err)
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	stdErr := bytes.NewBuffer(nil)
+	cmd := exec.Command(args[0], args[1:]...)
+	if in != nil {
+		cmd.Stdin = bytes.NewBuffer(in)
+	}
+	cmd.Stderr = stdErr
+	cmd.SysProcAttr = r.sysProcAttr()
+	secrets, err := r.secrets.environment()
+	if err != nil {
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	tmpDir, err := scriptTempDir(r.compName, r.credentials)
+	if err != nil {
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	defer os.RemoveAll(tmpDir)
+	// Secrets are kept out of ev.environ as that is logged.
+	cmd.Env = append(append([]string{}, ev.environ...), secrets...)
+	cmd.Env = append(cmd.Env, "EPHEMERA_TMPDIR="+tmpDir,
+		"EPHEMERA_LOCKFILE="+r.lock.path)
+
+	unlock, err := r.lock.acquire(operation, timeout)
+	if err != nil {
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	defer unlock()
+
+	// stdout and stderr are always piped, stdin only if there is
+	// input.
+	pipes := 2
+	if in != nil {
+		pipes++
+	}
+	err = resources.acquire(r.compName, pipes)
+	if err != nil {
+		ev.logError(err, err)
+		r.finished(modelName, operation, begin, in, nil, nil, err)
+		return nil, ev, err
+	}
+	stdOut := bytes.NewBuffer(nil)
+	cmd.Stdout = stdOut
+	timedOut := false
+	err = procs.start(r.compName, cmd)
+	if err == nil {
+		killed := killAfter(cmd, timeout)
+		err = procs.wait(cmd)
+		timedOut = killed()
+		r.usage.record(cmd.ProcessState)
+		if cmd.ProcessState != nil {
+			ev.exitCode = cmd.ProcessState.ExitCode()
+		}
+	}
+	if timedOut {
+		err = timeoutError(operation, timeout)
+	}
+	out := stdOut.Bytes()
+	resources.release(r.compName, pipes)
+	r.log.write(ev.environ, out, stdErr.Bytes(), err)
+	r.finished(modelName, operation, begin, in, out, stdErr.Bytes(),
+		err)
+	if err != nil {
+		merr := unpackError(stdErr)
+		if timedOut {
+			merr = err
+		}
+		ev.logError(merr, err)
+		r.breaker.record(false)
+		recorded = true
+		return out, ev, merr
+	}
+	r.breaker.record(true)
+	recorded = true
+	return out, ev, nil
+}
+
+// output runs a script whose standard output is the result of the
+// operation.
+func (r *runner) output(
+	modelName, operation, command string,
+	in []byte,
+	env ...string,
+) ([]byte, error) {
+	out, _, err := r.execute(modelName, operation, command, in, env...)
+	return out, err
+}
+
+// run runs a script for its side effects, logging anything it writes
+// to standard output.
+func (r *runner) run(
+	modelName, operation, command string,
+	in []byte,
+	env ...string,
+) error {
+	out, ev, err := r.execute(modelName, operation, command, in, env...)
+	if len(out) != 0 {
+		ev.logOutput(out)
+	}
+	return err
+}
+
+func genEnvironment(
+	compName, modelName, operation string,
+	protocolVersion int,
+) []string {
 	return []string{
 		"VCI_COMPONENT_NAME=" + compName,
 		"VCI_MODEL_NAME=" + modelName,
 		"EPHEMERA_MESSAGE=" + operation,
+		"EPHEMERA_PROTOCOL_VERSION=" + strconv.Itoa(protocolVersion),
 	}
 }
 
@@ -480,9 +1428,57 @@ func From(file string) Opt {
 	}
 }
 
+// WithKeystore sets the directory that SecretEnv keystore: sources
+// are read from.
+func WithKeystore(dir string) Opt {
+	return func(c *Component) {
+		c.keystoreDir = dir
+	}
+}
+
+// WithLockDir sets the directory holding the files components'
+// advisory locks are taken on.
+func WithLockDir(dir string) Opt {
+	return func(c *Component) {
+		c.lockDir = dir
+	}
+}
+
+// WithConfigCacheDir sets the directory holding the configuration
+// cached for models without a Config/Get script.
+func WithConfigCacheDir(dir string) Opt {
+	return func(c *Component) {
+		c.cacheDir = dir
+	}
+}
+
+// Strict rejects instance files containing sections or keys that are
+// not described by InstanceSchema, or values of the wrong type.
+func Strict() Opt {
+	return func(c *Component) {
+		c.strict = true
+	}
+}
+
+// OnCircuitChange registers a function to be called whenever the
+// component's circuit opens or closes.
+func OnCircuitChange(fn func(*Component, CircuitState)) Opt {
+	return func(c *Component) {
+		c.breaker.onChange = func(state CircuitState) {
+			fn(c, state)
+		}
+	}
+}
+
 func New(opts ...Opt) (*Component, error) {
 	c := &Component{
-		models: make(map[string]*Model),
+		keystoreDir: defaultKeystoreDir,
+		lockDir:     defaultLockDir,
+		cacheDir:    defaultConfigCacheDir,
+		models:      make(map[string]*Model),
+		breaker:     &breaker{},
+		tracer:      &tracer{size: defaultTraceSize},
+		stats:       &opStats{},
 	}
 	for _, opt := range opts {
 		opt(c)
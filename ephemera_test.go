@@ -4,8 +4,22 @@
 package ephemera
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+	"github.com/danos/mgmterror"
+	"github.com/go-ini/ini"
+	"golang.org/x/sys/unix"
 )
 
 func TestNew(t *testing.T) {
@@ -313,6 +327,30 @@ func TestRunErrorStateGet(t *testing.T) {
 		t.Fatalf("got:\n%s\nexpected:\n%s\n", out, expected)
 	}
 }
+
+func TestStateNegativeCache(t *testing.T) {
+	defer withFeatures(FeatureStateNegativeCache)()
+	c, err := New(From("testdata/testrunerr.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := c.Models()["net.vyatta.eng.vci.ephemeral.testrunerr.v1"]
+	s := m.state
+	s.Get()
+	s.Get()
+	if count := c.Stats()["State/Get"].Count; count != 1 {
+		t.Fatalf("failure was not cached, State/Get ran %d times", count)
+	}
+	s.negativeTTL = 0
+	s.failedUntil = time.Time{}
+	s.Get()
+	s.Get()
+	if count := c.Stats()["State/Get"].Count; count != 3 {
+		t.Fatalf("failure was cached with no TTL, State/Get ran %d times",
+			count)
+	}
+}
+
 func TestRunErrorRPC(t *testing.T) {
 	c, err := New(From("testdata/testrunerr.instance"))
 	if err != nil {
@@ -480,3 +518,2593 @@ func TestEqual(t *testing.T) {
 		t.Fatal("c != c")
 	}
 }
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []CircuitState
+	c, err := New(From("testdata/testbreaker.instance"),
+		OnCircuitChange(func(_ *Component, state CircuitState) {
+			transitions = append(transitions, state)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	okConf, _ := c.Models()["net.vyatta.eng.vci.ephemeral.testbreaker.ok"].
+		Config()
+	errConf, _ := c.Models()["net.vyatta.eng.vci.ephemeral.testbreaker.err"].
+		Config()
+
+	for i := 0; i < 2; i++ {
+		if errConf.(*config).Set(encodedString("")) == nil {
+			t.Fatal("expected error did not occur")
+		}
+	}
+	if c.CircuitState() != CircuitOpen {
+		t.Fatalf("circuit should be open not %s", c.CircuitState())
+	}
+
+	err = okConf.(*config).Set(encodedString(""))
+	if err == nil || !strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("expected short-circuit error, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	err = okConf.(*config).Set(encodedString(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CircuitState() != CircuitClosed {
+		t.Fatalf("circuit should be closed not %s", c.CircuitState())
+	}
+	if len(transitions) != 3 ||
+		transitions[0] != CircuitOpen ||
+		transitions[1] != CircuitHalfOpen ||
+		transitions[2] != CircuitClosed {
+		t.Fatalf("unexpected transitions %v", transitions)
+	}
+
+	for i := 0; i < 2; i++ {
+		errConf.(*config).Set(encodedString(""))
+	}
+	c.ResetCircuit()
+	if c.CircuitState() != CircuitClosed {
+		t.Fatalf("circuit should be closed not %s", c.CircuitState())
+	}
+	err = okConf.(*config).Set(encodedString(""))
+	if err != nil {
+		t.Fatal("a reset circuit should let scripts run, got", err)
+	}
+}
+
+func TestCircuitHalfOpen(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: 10 * time.Millisecond}
+	b.record(false)
+	if b.allow() == nil {
+		t.Fatal("an open circuit should refuse calls")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if b.allow() != nil {
+		t.Fatal("the trial call should be let through")
+	}
+	if b.State() != CircuitHalfOpen {
+		t.Fatalf("circuit should be half-open not %s", b.State())
+	}
+	if b.allow() == nil {
+		t.Fatal("only one trial call should be let through")
+	}
+	b.record(false)
+	if b.State() != CircuitOpen || b.allow() == nil {
+		t.Fatal("a failed trial should open the circuit again")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if b.allow() != nil {
+		t.Fatal("the trial call should be let through")
+	}
+	b.release()
+	if b.allow() != nil {
+		t.Fatal("a released trial should be made by the next call")
+	}
+	b.record(true)
+	if b.State() != CircuitClosed {
+		t.Fatalf("circuit should be closed not %s", b.State())
+	}
+	if b.allow() != nil || b.allow() != nil {
+		t.Fatal("a closed circuit should let every call through")
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	c, err := New(From("testdata/testpolicy.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FailurePolicy() != FailureDeactivate {
+		t.Fatalf("policy should be deactivate not %s", c.FailurePolicy())
+	}
+	if c.breaker.threshold != defaultFailureThreshold {
+		t.Fatalf("threshold should default to %d not %d",
+			defaultFailureThreshold, c.breaker.threshold)
+	}
+
+	c, err = New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FailurePolicy() != FailureIgnore || c.breaker.threshold != 0 {
+		t.Fatal("breaker should be disabled by default")
+	}
+}
+
+func TestHATransitions(t *testing.T) {
+	c, err := New(From("testdata/testha.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.ActiveOnly() {
+		t.Fatal("component should be active only")
+	}
+	err = c.BecomeActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.BecomeStandby()
+	if err == nil {
+		t.Fatal("didn't get expected error")
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "logs", "testlog.log")
+	instance := filepath.Join(dir, "testlog.instance")
+	err := ioutil.WriteFile(instance, []byte(`[Component]
+Name=net.vyatta.eng.vci.ephemeral.testlog
+LogFile=`+logFile+`
+LogFileMaxSize=400
+Start=/bin/sh testdata/testrun
+Stop=/bin/sh testdata/testrunstderr
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "Message: Start") {
+		t.Fatalf("log is missing script output:\n%s", buf)
+	}
+
+	c.Stop()
+	buf, err = ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "stderr:\n"+
+		"net.vyatta.eng.vci.ephemeral.testlog::Stop\n") {
+		t.Fatalf("log is missing script error output:\n%s", buf)
+	}
+	if _, err := os.Stat(logFile + ".1"); err != nil {
+		t.Fatal("log was not rotated:", err)
+	}
+
+	lines, err := c.Logs(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 ||
+		lines[1] != "net.vyatta.eng.vci.ephemeral.testlog::Stop" {
+		t.Fatalf("unexpected log tail %q", lines)
+	}
+	c, err = New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Logs(2); err == nil {
+		t.Fatal("components without a LogFile should have no logs")
+	}
+}
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := parseCapabilities("NET_ADMIN cap_net_raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalCapabilities(caps,
+		[]uintptr{unix.CAP_NET_ADMIN, unix.CAP_NET_RAW}) {
+		t.Fatalf("unexpected capabilities %v", caps)
+	}
+	_, err = parseCapabilities("NET_ADMIN BOGUS")
+	if err == nil {
+		t.Fatal("didn't get expected error")
+	}
+}
+
+func TestSecretEnv(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "token"),
+		[]byte("s3cret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := filepath.Join(dir, "testsecret.instance")
+	err = ioutil.WriteFile(instance, []byte(`[Component]
+Name=net.vyatta.eng.vci.ephemeral.testsecret
+SecretEnv/TOKEN=keystore:token
+
+[Model net.vyatta.eng.vci.ephemeral.testsecret.v1]
+State/Get=/bin/sh -c env
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(From(instance), WithKeystore(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.Models()["net.vyatta.eng.vci.ephemeral.testsecret.v1"].State()
+	out := string(s.(*state).Get())
+	if !strings.Contains(out, "TOKEN=s3cret\n") {
+		t.Fatalf("secret was not injected:\n%s", out)
+	}
+
+	err = os.Chmod(filepath.Join(dir, "token"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = string(s.(*state).Get())
+	if out != "" {
+		t.Fatalf("secret readable by others was used:\n%s", out)
+	}
+}
+
+func TestActiveWindow(t *testing.T) {
+	w, err := parseCronExpr("*/15 1-5 * * mon-fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		time     time.Time
+		expected bool
+	}{
+		// Monday 2021-06-07
+		{time.Date(2021, 6, 7, 1, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, 6, 7, 1, 31, 0, 0, time.UTC), false},
+		{time.Date(2021, 6, 7, 6, 0, 0, 0, time.UTC), false},
+		// Sunday 2021-06-06
+		{time.Date(2021, 6, 6, 2, 0, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		if w.contains(test.time) != test.expected {
+			t.Errorf("%s: expected %v", test.time, test.expected)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * * * bob"} {
+		_, err := parseCronExpr(spec)
+		if err == nil {
+			t.Errorf("%q: didn't get expected error", spec)
+		}
+	}
+}
+
+func TestRPCCache(t *testing.T) {
+	defer withFeatures(FeatureRPCCache)()
+	c, err := New(From("testdata/testcache.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcs, _ := c.Models()["net.vyatta.eng.vci.ephemeral.testcache.v1"].RPC()
+	call := func(name, in string) string {
+		rpc := rpcs["test"][name].(func(meta, in encodedString) (encodedString, error))
+		out, err := rpc(encodedString("{}"), encodedString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	if call("cached", "{}") != call("cached", "{}") {
+		t.Fatal("cached result was not reused")
+	}
+	if call("cached", "{}") == call("cached", `{"a":1}`) {
+		t.Fatal("cached result was reused for different input")
+	}
+	if call("uncached", "{}") == call("uncached", "{}") {
+		t.Fatal("uncached result was reused")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	c, err := New(From("testdata/testdisabled.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Enabled() {
+		t.Fatal("component should be disabled")
+	}
+	if !c.Models()["net.vyatta.eng.vci.ephemeral.testdisabled.v1"].Enabled() {
+		t.Fatal("v1 model should be enabled")
+	}
+	if c.Models()["net.vyatta.eng.vci.ephemeral.testdisabled.v2"].Enabled() {
+		t.Fatal("v2 model should be disabled")
+	}
+}
+
+func TestType(t *testing.T) {
+	c, err := New(From("testdata/testoneshot.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type() != TypeOneshot {
+		t.Fatalf("type should be oneshot not %s", c.Type())
+	}
+	c, err = New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type() != TypeSimple {
+		t.Fatalf("type should be simple not %s", c.Type())
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	c, err := New(From("testdata/testschedule.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	next, ok := c.NextRun(from)
+	if !ok || !next.Equal(time.Date(2021, time.June, 1, 2, 30, 0, 0,
+		time.UTC)) {
+		t.Fatalf("unexpected calendar run %s", next)
+	}
+	from = time.Date(2021, time.June, 1, 3, 0, 0, 0, time.UTC)
+	next, ok = c.NextRun(from)
+	if !ok || !next.Equal(from.Add(6*time.Hour)) {
+		t.Fatalf("unexpected interval run %s", next)
+	}
+
+	c, err = New(From("testdata/testoneshot.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.NextRun(from); ok {
+		t.Fatal("unscheduled component should have no next run")
+	}
+
+	s := &schedule{interval: time.Minute, randomizedDelay: time.Minute}
+	for i := 0; i < 100; i++ {
+		next, _ := s.next(from)
+		if next.Before(from.Add(time.Minute)) ||
+			!next.Before(from.Add(2*time.Minute)) {
+			t.Fatalf("randomized run %s out of range", next)
+		}
+	}
+}
+
+func TestAfterUnits(t *testing.T) {
+	c, err := New(From("testdata/testafter.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := c.AfterUnits()
+	if len(units) != 2 || units[0] != "network-online.target" ||
+		units[1] != "vyatta-dataplane.service" {
+		t.Fatalf("unexpected units %v", units)
+	}
+	for _, after := range []string{"systemd:", "unit:other.service",
+		c.Name()} {
+		_, _, err := parseAfter(c.Name(), after)
+		if err == nil {
+			t.Fatalf("After=%s should be rejected", after)
+		}
+	}
+}
+
+func TestComponentDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	write := func(keys string) {
+		err := ioutil.WriteFile(instance, []byte("[Component]\n"+
+			"Name=net.vyatta.eng.vci.ephemeral.testdeps\n"+
+			keys+
+			"[Model net.vyatta.eng.vci.ephemeral.testdeps.v1]\n"+
+			"RPC/test/ping=/bin/true\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("After=systemd:network-online.target " +
+		"net.vyatta.eng.vci.ephemeral.testa\n" +
+		"Requires=net.vyatta.eng.vci.ephemeral.testb\n")
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if units := c.AfterUnits(); len(units) != 1 ||
+		units[0] != "network-online.target" {
+		t.Fatalf("unexpected units %v", units)
+	}
+	if comps := c.AfterComponents(); len(comps) != 1 ||
+		comps[0] != "net.vyatta.eng.vci.ephemeral.testa" {
+		t.Fatalf("unexpected After components %v", comps)
+	}
+	if comps := c.Requires(); len(comps) != 1 ||
+		comps[0] != "net.vyatta.eng.vci.ephemeral.testb" {
+		t.Fatalf("unexpected Requires components %v", comps)
+	}
+	for _, requires := range []string{"systemd:network-online.target",
+		"net.vyatta.eng.vci.ephemeral.testdeps"} {
+		write("Requires=" + requires + "\n")
+		_, err = New(From(instance))
+		if err == nil {
+			t.Fatalf("Requires=%s should be rejected", requires)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	_, err := New(From("testdata/teststrict.instance"))
+	if err != nil {
+		t.Fatal("non-strict parsing should ignore unknown keys:", err)
+	}
+	_, err = New(From("testdata/teststrict.instance"), Strict())
+	if err == nil {
+		t.Fatal("strict parsing should fail")
+	}
+	for _, want := range []string{
+		"[Component] Enabled",
+		"unknown key Stat",
+		"unknown section [Modle",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("%q should mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "RPC/") {
+		t.Fatalf("%q should accept the RPC keys", err)
+	}
+
+	for _, file := range []string{
+		"testdata/testrun.instance",
+		"testdata/testcache.instance",
+		"testdata/testschedule.instance",
+	} {
+		c, err := New(From(file), Strict())
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		if c.FormatVersion() != 1 {
+			t.Fatal("format version should default to 1")
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	published, err := ioutil.ReadFile("schema/instance-v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := InstanceSchema.JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(generated)+"\n" {
+		t.Fatal("schema/instance-v1.json is out of date")
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	_, err := New(From("testdata/testfuture.instance"))
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatal("newer format versions should be refused:", err)
+	}
+
+	// Pretend the current format is version 2, in which Start was
+	// renamed Begin, to check older files are migrated.
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = []func(*ini.File) error{
+		func(cfg *ini.File) error {
+			sect := cfg.Section("Component")
+			sect.NewKey("Begin", sect.Key("Start").String())
+			sect.DeleteKey("Start")
+			return nil
+		},
+	}
+	cfg, err := ini.Load("testdata/testrun.instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := cfg.Section("Component").Key("Start").String()
+	v, err := migrateTo(cfg, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1 {
+		t.Fatalf("declared version should be 1 not %d", v)
+	}
+	sect := cfg.Section("Component")
+	if sect.HasKey("Start") || sect.Key("Begin").String() != start ||
+		sect.Key("FormatVersion").String() != "2" {
+		t.Fatal("instance file was not migrated")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	c, err := New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	if len(c.Trace()) != 0 {
+		t.Fatal("nothing should be traced until tracing is enabled")
+	}
+
+	c.SetTrace(true)
+	c.tracer.size = 2
+	c.Start()
+	c.Stop()
+	c.Start()
+	trace := c.Trace()
+	if len(trace) != 2 {
+		t.Fatalf("trace should hold 2 entries not %d", len(trace))
+	}
+	if trace[0].Operation != "Stop" || trace[1].Operation != "Start" {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if !strings.Contains(trace[1].Output, "Message: Start") {
+		t.Fatalf("trace is missing script output %q", trace[1].Output)
+	}
+
+	c.SetTrace(false)
+	if len(c.Trace()) != 0 {
+		t.Fatal("disabling tracing should discard the trace")
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	SetResourceLimits(ResourceLimits{
+		MaxProcesses:          2,
+		MaxComponentProcesses: 1,
+	})
+	defer SetResourceLimits(ResourceLimits{})
+
+	if err := resources.acquire("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := resources.acquire("a", 2); err == nil {
+		t.Fatal("component limit should be enforced")
+	}
+	if err := resources.acquire("b", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := resources.acquire("c", 2); err == nil {
+		t.Fatal("global limit should be enforced")
+	}
+	usage := Resources()
+	if usage.Processes != 2 || usage.Pipes != 5 ||
+		usage.Components["a"] != 1 || usage.Components["b"] != 1 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	resources.release("a", 2)
+	resources.release("b", 3)
+	usage = Resources()
+	if usage.Processes != 0 || usage.Pipes != 0 ||
+		len(usage.Components) != 0 {
+		t.Fatalf("resources were not released %+v", usage)
+	}
+}
+
+func TestScriptProcessGroups(t *testing.T) {
+	c, err := New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs.mu.Lock()
+	defer procs.mu.Unlock()
+	if len(procs.running) != 0 {
+		t.Fatal("finished scripts should not be running")
+	}
+	pgid := procs.order[len(procs.order)-1]
+	if procs.groups[pgid] != c.Name() {
+		t.Fatalf("process group %d should belong to %s not %q",
+			pgid, c.Name(), procs.groups[pgid])
+	}
+}
+
+func TestOpLimiter(t *testing.T) {
+	l := opLimiterNew(1, 1)
+	if err := l.acquire("test"); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire("test") }()
+	for {
+		l.mu.Lock()
+		queued := l.queued
+		l.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.acquire("test"); err == nil {
+		t.Fatal("operations beyond the queue limit should be refused")
+	}
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	l.release()
+	if l.inFlight != 0 || l.queued != 0 {
+		t.Fatalf("limiter not idle: %d in flight, %d queued",
+			l.inFlight, l.queued)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	l := opLimiterNew(1, 0)
+	l.backpressure = 1
+	if err := l.acquire("test"); err != nil {
+		t.Fatal(err)
+	}
+	if l.overloaded() {
+		t.Fatal("should not be overloaded with nothing queued")
+	}
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire("test") }()
+	for !l.overloaded() {
+		time.Sleep(time.Millisecond)
+	}
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	if l.overloaded() {
+		t.Fatal("should not be overloaded once the queue drains")
+	}
+	l.release()
+}
+
+func TestOperations(t *testing.T) {
+	c, err := New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.runner.limiter.acquire(c.Name())
+	inFlight, queued := c.Operations()
+	if inFlight != 1 || queued != 0 {
+		t.Fatalf("unexpected counts %d in flight %d queued",
+			inFlight, queued)
+	}
+	c.runner.limiter.release()
+	c.Start()
+	inFlight, _ = c.Operations()
+	if inFlight != 0 {
+		t.Fatal("finished operations should not be in flight")
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	c, err := New(From("testdata/testtimeout.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	err = c.Stop()
+	if err == nil {
+		t.Fatal("Stop should have timed out")
+	}
+	if !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("unexpected error %q", err)
+	}
+	merr, ok := err.(*mgmterror.OperationFailedApplicationError)
+	if !ok || merr.AppTag != AppTagTimeout {
+		t.Fatalf("expected the %s app-tag, got %#v", AppTagTimeout, err)
+	}
+	if time.Since(begin) > 5*time.Second {
+		t.Fatal("Stop was not killed when it timed out")
+	}
+
+	SetDefaultTimeouts(Timeouts{Start: 50 * time.Millisecond,
+		Stop: time.Hour})
+	defer SetDefaultTimeouts(Timeouts{})
+	err = c.Start()
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Start should have used the default timeout, got %v",
+			err)
+	}
+	err = c.Stop()
+	if err == nil || !strings.Contains(err.Error(), "after 50ms") {
+		t.Fatalf("StopTimeout should override the default, got %v",
+			err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	c, err := New(From("testdata/testtimeout.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetMessages(map[string]string{
+		MsgScriptTimeout: "{operation} a expiré après {timeout}",
+		"unknown-code":   "ignored",
+	})
+	defer SetMessages(nil)
+	err = c.Stop()
+	if err == nil ||
+		!strings.HasSuffix(err.Error(), "Stop a expiré après 50ms") {
+		t.Fatalf("expected the translated message, got %v", err)
+	}
+	merr, ok := err.(*mgmterror.OperationFailedApplicationError)
+	if !ok || merr.AppTag != AppTagTimeout {
+		t.Fatalf("translation changed the app-tag: %#v", err)
+	}
+	msg := message(MsgOutsideActiveWindow, "component", "a",
+		"window", "* * * * *")
+	if msg != "component a may only be activated during its "+
+		"active window (* * * * *)" {
+		t.Fatalf("untranslated code should stay in English, got %q", msg)
+	}
+}
+
+func TestKillAfter(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 5 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	killed := KillAfter(cmd, 50*time.Millisecond)
+	cmd.Wait()
+	if !killed() {
+		t.Fatal("the command should have been killed")
+	}
+	if time.Since(begin) > 2*time.Second {
+		t.Fatal("the command's process group was not killed")
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	c, err := New(From("testdata/testtimeout.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := c.Models()["net.vyatta.eng.vci.ephemeral.testtimeout.v1"]
+	err = model.config.Set(nil)
+	if err == nil || !strings.Contains(err.Error(), "after 50ms") {
+		t.Fatalf("Config/Set/Timeout should override SetTimeout, got %v",
+			err)
+	}
+	rpcs, _ := model.RPC()
+	slow := rpcs["test"]["slow"].(func(
+		meta, in encodedString) (encodedString, error))
+	_, err = slow(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "after 50ms") {
+		t.Fatalf("RPC timeout was not applied, got %v", err)
+	}
+	if c.runner.timeout("", "Config/Set") != time.Hour {
+		t.Fatal("other models should use the component's timeout")
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected int
+		err      bool
+	}{
+		{"", 1, false},
+		{"1", 1, false},
+		{"99", CurrentProtocolVersion, false},
+		{"0", 0, true},
+		{"one", 0, true},
+	} {
+		cfg := ini.Empty()
+		if test.value != "" {
+			cfg.Section("Component").NewKey("ProtocolVersion",
+				test.value)
+		}
+		v, err := negotiateProtocolVersion(cfg.Section("Component"))
+		if (err != nil) != test.err || v != test.expected {
+			t.Errorf("ProtocolVersion=%s: got %d, %v", test.value,
+				v, err)
+		}
+	}
+
+	env := genEnvironment("comp", "model", "Start", 1)
+	found := false
+	for _, v := range env {
+		found = found || v == "EPHEMERA_PROTOCOL_VERSION=1"
+	}
+	if !found {
+		t.Fatal("EPHEMERA_PROTOCOL_VERSION not in", env)
+	}
+}
+
+func TestScriptTempDir(t *testing.T) {
+	c, err := New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		out, err := c.runner.output("", "Start",
+			"/bin/sh testdata/testtmpdir", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := strings.TrimSpace(string(out))
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("%s was not removed", dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	if dirs[0] == dirs[1] {
+		t.Fatal("invocations should not share a temp directory")
+	}
+}
+
+func TestAutoLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := New(From("testdata/testlock.instance"), WithLockDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := componentLockNew(dir, c.Name(), true)
+	unlock, err := lock.acquire("Start", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lock.acquire("Stop", 100*time.Millisecond)
+	if merr, ok := err.(*mgmterror.OperationFailedApplicationError); !ok ||
+		merr.AppTag != AppTagTimeout {
+		t.Fatalf("expected a timeout waiting for the lock, got %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- c.Start() }()
+	select {
+	case <-done:
+		t.Fatal("Start should wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c, err := New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := c.Snapshot()
+	for key, expected := range map[string]string{
+		"Component/Start":   "/bin/sh testdata/testrun",
+		"Component/Enabled": "true",
+		"Component/Type":    "simple",
+		"Model net.vyatta.eng.vci.ephemeral.testrun.v1/RPC/test/rpc1": "/bin/sh testdata/testrun",
+	} {
+		if snap[key] != expected {
+			t.Errorf("%s: expected %q got %q", key, expected, snap[key])
+		}
+	}
+	if _, ok := snap["Component/OnActive"]; ok {
+		t.Error("keys with no value should be omitted")
+	}
+}
+
+func TestAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte(
+		"[Component]\nName=net.vyatta.eng.vci.ephemeral.testassets\n"+
+			"Assets=tracked\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name),
+			[]byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = New(From(instance))
+	if err == nil || !strings.Contains(err.Error(), "asset tracked") {
+		t.Fatal("missing assets should be an error:", err)
+	}
+	write("tracked", "one")
+	write("untracked", "one")
+	a, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("untracked", "two")
+	b, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(b) {
+		t.Fatal("changing an untracked file should not change the component")
+	}
+	write("tracked", "two")
+	b, err = New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(b) {
+		t.Fatal("changing an asset should change the component")
+	}
+}
+
+func TestStats(t *testing.T) {
+	c, err := New(From("testdata/testrunerr.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := c.runner
+	r.run("", "Config/Check", "/bin/sh testdata/testrunerr", nil)
+	r.run("", "Config/Check", "/bin/sh testdata/testrunerr", nil)
+	st := c.Stats()["Config/Check"]
+	if st.Count != 2 || st.Failures != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if st.MaxDuration == 0 || st.TotalDuration < st.MaxDuration {
+		t.Fatalf("unexpected durations %+v", st)
+	}
+}
+
+func TestStatePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-statepaths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte(
+		"[Component]\nName=net.vyatta.eng.vci.ephemeral.teststatepaths\n"+
+			"[Model net.vyatta.eng.vci.ephemeral.teststatepaths.v1]\n"+
+			"State/Get=/bin/true\n"+
+			"StatePaths=/test:a/test:b  /test:c\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := c.Models()["net.vyatta.eng.vci.ephemeral.teststatepaths.v1"]
+	paths := model.StatePaths()
+	if !equalStrings(paths, []string{"/test:a/test:b", "/test:c"}) {
+		t.Fatalf("unexpected state paths %v", paths)
+	}
+	other, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equal(other) {
+		t.Fatal("components with the same state paths should be equal")
+	}
+	other.Models()["net.vyatta.eng.vci.ephemeral.teststatepaths.v1"].
+		statePaths = []string{"/test:c"}
+	if c.Equal(other) {
+		t.Fatal("components with different state paths should differ")
+	}
+}
+
+func TestWarm(t *testing.T) {
+	defer withFeatures(FeatureStateRefresh)()
+	dir, err := ioutil.TempDir("", "ephemera-warm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testwarm\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testwarm.v1]\n"+
+		"State/Get=/bin/echo {}\n"+
+		"State/RefreshInterval=1h\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := c.Models()["net.vyatta.eng.vci.ephemeral.testwarm.v1"]
+	err = m.Warm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.state.Get()
+	if count := c.Stats()["State/Get"].Count; count != 1 {
+		t.Fatalf("warmed state was not served, State/Get ran %d times",
+			count)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	c, err := New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Usage() != (ScriptUsage{}) {
+		t.Fatal("no usage should be recorded before scripts run")
+	}
+	c.runner.run("", "Config/Check", "/bin/sh testdata/testrun", nil)
+	first := c.Usage()
+	if first.PeakRSS == 0 || first.TotalRSS != first.PeakRSS {
+		t.Fatalf("unexpected usage after one script %+v", first)
+	}
+	c.runner.run("", "Config/Check", "/bin/sh testdata/testrun", nil)
+	second := c.Usage()
+	if second.TotalRSS <= first.TotalRSS ||
+		second.PeakRSS > second.TotalRSS {
+		t.Fatalf("unexpected usage after two scripts %+v", second)
+	}
+	if second.UserTime < first.UserTime ||
+		second.SystemTime < first.SystemTime {
+		t.Fatalf("CPU time should accumulate %+v %+v", first, second)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	for _, test := range []struct {
+		command  string
+		expected []string
+		err      bool
+	}{
+		{"/bin/true", []string{"/bin/true"}, false},
+		{"  /bin/echo  a\tb ", []string{"/bin/echo", "a", "b"}, false},
+		{`/bin/echo "a b" 'c d'`, []string{"/bin/echo", "a b", "c d"},
+			false},
+		{`/bin/echo a\ b`, []string{"/bin/echo", "a b"}, false},
+		{`/bin/echo "a\"b\n" 'a\b'`,
+			[]string{"/bin/echo", `a"b\n`, `a\b`}, false},
+		{`/bin/echo x""y ''`, []string{"/bin/echo", "xy", ""}, false},
+		{`/bin/echo "a`, nil, true},
+		{`/bin/echo a\`, nil, true},
+		{"   ", nil, true},
+	} {
+		words, err := splitCommand(test.command)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.command, err)
+			continue
+		}
+		if !equalStrings(words, test.expected) {
+			t.Errorf("%q: expected %q got %q", test.command,
+				test.expected, words)
+		}
+	}
+}
+
+func TestStartParameters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "start")
+	err = ioutil.WriteFile(script, []byte("cat > "+out+"\n"+
+		"echo \" $EPHEMERA_PARAM_PORT $EPHEMERA_PARAM_TARGET_IF\" >> "+
+		out+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte(
+		"[Component]\nName=net.vyatta.eng.vci.ephemeral.testparams\n"+
+			"Type=oneshot\nParameters=port target-if\n"+
+			"Start=/bin/sh "+script+"\nStop=/bin/sh "+script+"\n"),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.SetParameters(map[string]string{"unknown": "x"})
+	if err == nil {
+		t.Fatal("undeclared parameters should be rejected")
+	}
+	err = c.SetParameters(map[string]string{
+		"port":      "8080",
+		"target-if": "dp0s1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := c.Parameters()
+	if len(params) != 2 || params["port"] != "8080" {
+		t.Fatal("unexpected parameters", params)
+	}
+	params["port"] = "9090"
+	if c.Parameters()["port"] != "8080" {
+		t.Fatal("parameters changed through a copy")
+	}
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"port":"8080","target-if":"dp0s1"} 8080 dp0s1` + "\n"
+	if string(buf) != expected {
+		t.Fatalf("expected %q got %q", expected, buf)
+	}
+
+	err = c.StopWithParameters(map[string]string{"unknown": "x"})
+	if err == nil {
+		t.Fatal("undeclared Stop parameters should be rejected")
+	}
+	err = c.StopWithParameters(map[string]string{"port": "9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"port":"9090"} 9090 ` + "\n"
+	if string(buf) != expected {
+		t.Fatalf("expected %q got %q", expected, buf)
+	}
+
+	_, err = parseParameters("port bad/name")
+	if err == nil {
+		t.Fatal("invalid parameter names should be rejected")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	cfg, err := ini.Load([]byte("[Component]\nUser=root\nGroup=root\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := parseCredentials(cfg.Section("Component"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.cred == nil || creds.cred.Uid != 0 || creds.cred.Gid != 0 {
+		t.Fatalf("unexpected credentials %+v", creds.cred)
+	}
+
+	cfg, err = ini.Load([]byte("[Component]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err = parseCredentials(cfg.Section("Component"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.cred != nil {
+		t.Fatal("scripts should run as ephemerad without User or Group")
+	}
+
+	cfg, err = ini.Load([]byte(
+		"[Component]\nUser=ephemera-no-such-user\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseCredentials(cfg.Section("Component"))
+	if err == nil {
+		t.Fatal("unknown users should be rejected")
+	}
+}
+
+func TestConfigCache(t *testing.T) {
+	defer withFeatures(FeatureConfigCache)()
+	dir, err := ioutil.TempDir("", "ephemera-configcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte(
+		"[Component]\nName=net.vyatta.eng.vci.ephemeral.testcache\n"+
+			"[Model net.vyatta.eng.vci.ephemeral.testcache.v1]\n"+
+			"Config/Set=/bin/true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := func() *config {
+		c, err := New(From(instance),
+			WithConfigCacheDir(filepath.Join(dir, "cache")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Models()["net.vyatta.eng.vci.ephemeral.testcache.v1"].
+			config
+	}
+
+	conf := load()
+	if out := string(conf.Get()); out != "" {
+		t.Fatalf("nothing should be cached yet, got %q", out)
+	}
+	expected := `{"test:value":"a"}`
+	err = conf.Set(encodedString(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(conf.Get()); out != expected {
+		t.Fatalf("expected %q got %q", expected, out)
+	}
+	conf = load()
+	if out := string(conf.Get()); out != expected {
+		t.Fatalf("cache did not survive reloading, got %q", out)
+	}
+	err = conf.Set(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(load().Get()); out != "" {
+		t.Fatalf("empty configuration should clear the cache, got %q",
+			out)
+	}
+
+	const model = "net.vyatta.eng.vci.ephemeral.testcache.v1"
+	c, err := New(From(instance),
+		WithConfigCacheDir(filepath.Join(dir, "cache")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.RestoreCachedConfig(model, []byte(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := c.CachedConfig()
+	if err != nil || string(cached[model]) != expected {
+		t.Fatalf("expected %q to be cached, got %q (%v)", expected,
+			cached[model], err)
+	}
+	if out := string(load().Get()); out != expected {
+		t.Fatalf("restored configuration should be returned, got %q",
+			out)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "script")
+	err = ioutil.WriteFile(script, []byte(
+		"echo \"$EPHEMERA_MESSAGE $EPHEMERA_SESSION_ID "+
+			"$EPHEMERA_PARAM_TARGET\" >> "+out+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte(
+		"[Component]\nName=net.vyatta.eng.vci.ephemeral.testsessions\n"+
+			"Sessions=true\nSessionLease=5m\nParameters=target\n"+
+			"Start=/bin/sh "+script+"\nStop=/bin/sh "+script+"\n"),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Sessions() || c.SessionLease() != 5*time.Minute {
+		t.Fatalf("unexpected sessions %t lease %s", c.Sessions(),
+			c.SessionLease())
+	}
+	err = c.StartSession("s1", map[string]string{"target": "dp0s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StopSession("s1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartSession("s2", map[string]string{"unknown": "x"})
+	if err == nil {
+		t.Fatal("undeclared session parameters should be rejected")
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Start s1 dp0s1\nStop s1 \n"
+	if string(buf) != expected {
+		t.Fatalf("expected %q got %q", expected, buf)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte(
+		"[Component]\nName=net.vyatta.eng.vci.ephemeral.testowner\n"+
+			"Owner=security\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Owner() != "security" {
+		t.Fatalf("unexpected owner %q", c.Owner())
+	}
+	if c.InstanceFile() != instance {
+		t.Fatalf("unexpected instance file %q", c.InstanceFile())
+	}
+	if c.Snapshot()["Component/Owner"] != "security" {
+		t.Fatal("owner missing from snapshot")
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-deprecation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(keys string) string {
+		instance := filepath.Join(dir, "instance")
+		err := ioutil.WriteFile(instance, []byte(
+			"[Component]\nName=net.vyatta.eng.vci.ephemeral.testold\n"+
+				keys), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance
+	}
+
+	c, err := New(From(write("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Deprecated() {
+		t.Fatal("component should not be deprecated")
+	}
+
+	c, err = New(From(write("DeprecatedSince=2105\n" +
+		"ReplacedBy=net.vyatta.eng.vci.ephemeral.testnew\n" +
+		"RedirectActivation=true\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Deprecated() || c.DeprecatedSince() != "2105" ||
+		c.ReplacedBy() != "net.vyatta.eng.vci.ephemeral.testnew" ||
+		!c.RedirectActivation() {
+		t.Fatal("deprecation keys were not read")
+	}
+
+	_, err = New(From(write("RedirectActivation=true\n")))
+	if err == nil {
+		t.Fatal("RedirectActivation without ReplacedBy should fail")
+	}
+}
+
+func TestDropIns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-dropins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base, err := ioutil.ReadFile("testdata/test.instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := filepath.Join(dir, "test.instance")
+	err = ioutil.WriteFile(instance, base, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.DropIns()) != 0 {
+		t.Fatal("unexpected drop-ins", plain.DropIns())
+	}
+
+	dropInDir := instance + DropInSuffix
+	err = os.Mkdir(dropInDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments := map[string]string{
+		"20-replace.conf": "[Model net.vyatta.eng.vci.ephemeral.test.v1]\n" +
+			"RPC/test/rpc4=/bin/false\n",
+		"10-add.conf": "[Model net.vyatta.eng.vci.ephemeral.test.v1]\n" +
+			"RPC/test/rpc4=/bin/true\n" +
+			"RPC/test/rpc5=/bin/true\n",
+		"ignored.txt": "[Model net.vyatta.eng.vci.ephemeral.test.v1]\n" +
+			"RPC/test/rpc6=/bin/true\n",
+	}
+	for name, content := range fragments {
+		err = ioutil.WriteFile(filepath.Join(dropInDir, name),
+			[]byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dropInDir, "10-add.conf"),
+		filepath.Join(dropInDir, "20-replace.conf"),
+	}
+	if strings.Join(c.DropIns(), " ") != strings.Join(expected, " ") {
+		t.Fatal("unexpected drop-ins", c.DropIns())
+	}
+	if plain.Equal(c) {
+		t.Fatal("drop-ins should change the component")
+	}
+	model := c.Models()["net.vyatta.eng.vci.ephemeral.test.v1"]
+	rpcs, ok := model.RPC()
+	if !ok {
+		t.Fatal("model should have RPCs")
+	}
+	for _, name := range []string{"rpc1", "rpc4", "rpc5"} {
+		if _, ok := rpcs["test"][name]; !ok {
+			t.Fatal("missing RPC", name)
+		}
+	}
+	if _, ok := rpcs["test"]["rpc6"]; ok {
+		t.Fatal("only .conf fragments should be merged")
+	}
+	if model.rpc.modules["test"]["rpc4"] != "/bin/false" {
+		t.Fatal("later fragments should replace earlier keys")
+	}
+}
+
+func TestBaseInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	root := write("base/root.instance", "[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.root\n"+
+		"Start=/bin/true\n"+
+		"Stop=/bin/true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.test.v1]\n"+
+		"RPC/test/rpc1=/bin/true\n")
+	dropIn := write("base/root.instance.d/10-rpc.conf",
+		"[Model net.vyatta.eng.vci.ephemeral.test.v1]\n"+
+			"RPC/test/rpc2=/bin/true\n")
+	family := write("base/family.instance", "[Component]\n"+
+		"BaseInstance=root.instance\n"+
+		"Stop=/bin/false\n")
+	instance := write("ospf.instance", "[Component]\n"+
+		"BaseInstance=base/family.instance\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.ospf\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.test.v1]\n"+
+		"RPC/test/rpc1=/bin/false\n")
+
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "net.vyatta.eng.vci.ephemeral.ospf" {
+		t.Fatal("name not overridden:", c.Name())
+	}
+	if c.start != "/bin/true" || c.stop != "/bin/false" {
+		t.Fatal("unexpected scripts", c.start, c.stop)
+	}
+	if strings.Join(c.Bases(), " ") != root+" "+family {
+		t.Fatal("unexpected bases", c.Bases())
+	}
+	if strings.Join(c.DropIns(), " ") != dropIn {
+		t.Fatal("unexpected drop-ins", c.DropIns())
+	}
+	modules := c.Models()["net.vyatta.eng.vci.ephemeral.test.v1"].
+		rpc.modules
+	if modules["test"]["rpc1"] != "/bin/false" ||
+		modules["test"]["rpc2"] != "/bin/true" {
+		t.Fatal("unexpected RPCs", modules)
+	}
+
+	write("base/root.instance", "[Component]\n"+
+		"BaseInstance=../ospf.instance\n")
+	_, err = New(From(instance))
+	if err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Fatal("expected a BaseInstance loop error, got", err)
+	}
+}
+
+// withFeatures enables the named features, returning a function that
+// disables them again.
+func withFeatures(names ...string) func() {
+	for _, name := range names {
+		SetFeature(name, true)
+	}
+	return func() {
+		for _, name := range names {
+			SetFeature(name, false)
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	defer SetFeature(FeatureRPCCache, false)
+	for name, enabled := range Features() {
+		if enabled {
+			t.Fatalf("%s should be disabled by default", name)
+		}
+	}
+	err := SetFeature(FeatureRPCCache, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !FeatureEnabled(FeatureRPCCache) {
+		t.Fatal("rpc-cache should be enabled")
+	}
+	if SetFeature("no-such-feature", true) == nil {
+		t.Fatal("unknown features should be refused")
+	}
+
+	features, err := ParseFeatures(" rpc-cache, -config-cache,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || !features[FeatureRPCCache] ||
+		features[FeatureConfigCache] {
+		t.Fatal("unexpected features", features)
+	}
+	_, err = ParseFeatures("-no-such-feature")
+	if err == nil {
+		t.Fatal("unknown features should be refused")
+	}
+}
+
+func TestLegacyWrapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.legacy\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.legacy.v1]\n"+
+		"LegacyWrapper=/lib/legacy/wrapper -v\n"+
+		"LegacyActions=validate commit get-state\n"+
+		"LegacyRPCs=test/rpc1\n"+
+		"Config/Set=/lib/legacy/commit\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance), Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := c.Models()["net.vyatta.eng.vci.ephemeral.legacy.v1"]
+	if model.config.check != "/lib/legacy/wrapper -v --action=validate" {
+		t.Fatal("unexpected Config/Check", model.config.check)
+	}
+	if model.config.set != "/lib/legacy/commit" {
+		t.Fatal("the model's own Config/Set should be kept")
+	}
+	if model.config.get != "" {
+		t.Fatal("get-config isn't a listed action")
+	}
+	if model.state.get != "/lib/legacy/wrapper -v --action=get-state" {
+		t.Fatal("unexpected State/Get", model.state.get)
+	}
+	if model.rpc.modules["test"]["rpc1"] !=
+		"/lib/legacy/wrapper -v --action=rpc1" {
+		t.Fatal("unexpected RPC", model.rpc.modules)
+	}
+
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.legacy\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.legacy.v1]\n"+
+		"LegacyWrapper=/lib/legacy/wrapper\n"+
+		"LegacyActions=validate frobnicate\n"+
+		"LegacyRPCs=rpc1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(From(instance), Strict())
+	if err == nil || !strings.Contains(err.Error(), "frobnicate") ||
+		!strings.Contains(err.Error(), "rpc1") {
+		t.Fatal("strict loading should refuse unknown actions and "+
+			"malformed RPCs, got", err)
+	}
+	errors := &testLogger{}
+	_, err = New(From(instance), WithLogger(errors, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errors.msgs) != 2 {
+		t.Fatal("expected both problems to be logged, got", errors.msgs)
+	}
+}
+
+func TestCallAPI(t *testing.T) {
+	defer withFeatures(FeatureConfigCache, FeatureRPCCache)()
+	c, err := New(From("testdata/testcache.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(name, in string) string {
+		out, err := c.CallRPC("test", name, []byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+	if call("cached", "{}") != call("cached", "{}") {
+		t.Fatal("cached result was not reused")
+	}
+	if call("uncached", "{}") == call("uncached", "{}") {
+		t.Fatal("uncached result was reused")
+	}
+	_, err = c.CallRPC("test", "missing", []byte("{}"))
+	if err == nil {
+		t.Fatal("calling a missing RPC should fail")
+	}
+
+	dir, err := ioutil.TempDir("", "ephemera-call")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testcall\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testcall.v1]\n"+
+		"Config/Check=/bin/false\n"+
+		"Config/Set=/bin/true\n"+
+		"State/Get=/bin/sh testdata/testrandom\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testcall.v2]\n"+
+		"Config/Get=/bin/false\n"+
+		"State/Get=/bin/false\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testcall.v3]\n"+
+		"Enabled=false\n"+
+		"State/Get=/bin/sh testdata/testrandom\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = New(From(instance),
+		WithConfigCacheDir(filepath.Join(dir, "config")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const model = "net.vyatta.eng.vci.ephemeral.testcall.v1"
+	if c.CheckConfig(model, []byte(`{"a":1}`)) == nil {
+		t.Fatal("Config/Check should have failed")
+	}
+	err = c.SetConfig(model, []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := c.GetConfig(model)
+	if err != nil || string(config) != `{"a":1}` {
+		t.Fatal("unexpected configuration", string(config), err)
+	}
+	state, err := c.GetState(model)
+	if err != nil || len(state) == 0 {
+		t.Fatal("unexpected state", string(state), err)
+	}
+	_, err = c.GetState("net.vyatta.eng.vci.ephemeral.testcall.v4")
+	if err == nil {
+		t.Fatal("getting state of a missing model should fail")
+	}
+	_, err = c.GetConfig("net.vyatta.eng.vci.ephemeral.testcall.v2")
+	if err == nil {
+		t.Fatal("a failing Config/Get should return its error")
+	}
+	_, err = c.GetState("net.vyatta.eng.vci.ephemeral.testcall.v2")
+	if err == nil {
+		t.Fatal("a failing State/Get should return its error")
+	}
+	_, err = c.GetState("net.vyatta.eng.vci.ephemeral.testcall.v3")
+	if err == nil {
+		t.Fatal("getting state of a disabled model should fail")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-validation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(content string) string {
+		instance := filepath.Join(dir, "instance")
+		err := ioutil.WriteFile(instance, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance
+	}
+
+	_, err = New(From(write("[Component]\n" +
+		"Start=/bin/true 'unterminated\n" +
+		"[Model  spaced]\n" +
+		"RPC/test=/bin/true\n")))
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatal("expected validation errors, got", err)
+	}
+	if len(errs) != 4 {
+		t.Fatal("expected four problems, got", errs)
+	}
+
+	instance := write("[Component]\n" +
+		"Name=net.vyatta.eng.vci.ephemeral.testvalidate\n" +
+		"Start=/nonexistent/start\n" +
+		"Stop=true\n")
+	_, err = New(From(instance))
+	if err != nil {
+		t.Fatal("commands should only be resolved when asked", err)
+	}
+	_, err = New(From(instance), ResolveCommands())
+	errs, ok = err.(ValidationErrors)
+	if !ok || len(errs) != 1 ||
+		!strings.HasPrefix(errs[0].Error(), "Start:") {
+		t.Fatal("expected Start not to resolve, got", err)
+	}
+
+	_, err = New(From(write("[Component]\n" +
+		"Name=net.vyatta.eng.vci.ephemeral.testvalidate\n" +
+		"[Model net.vyatta.eng.vci.ephemeral.testvalidate.v1]\n" +
+		"RPC/test/status=/bin/true\n" +
+		"RPC/test/status/CacheTTL=ten seconds\n")))
+	errs, ok = err.(ValidationErrors)
+	if !ok || len(errs) != 1 ||
+		!strings.Contains(errs[0].Error(), "CacheTTL") {
+		t.Fatal("expected a malformed CacheTTL to be refused, got", err)
+	}
+}
+
+type testLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+	l.mu.Unlock()
+}
+
+func (l *testLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, fmt.Sprintln(v...))
+	l.mu.Unlock()
+}
+
+func TestWithLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testlogger\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testlogger.v1]\n"+
+		"LegacyWrapper=/bin/true\n"+
+		"LegacyActions=bogus\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors, debug := &testLogger{}, &testLogger{}
+	_, err = New(From(instance), WithLogger(errors, debug))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errors.msgs) != 1 ||
+		!strings.Contains(errors.msgs[0], "bogus") {
+		t.Fatal("expected the error to be logged, got", errors.msgs)
+	}
+	if len(debug.msgs) != 0 {
+		t.Fatal("unexpected debug messages", debug.msgs)
+	}
+}
+
+func TestWithLoggerJournal(t *testing.T) {
+	prevEnabled, prevSend := journalEnabled, journalSend
+	defer func() { journalEnabled, journalSend = prevEnabled, prevSend }()
+	journalEnabled = func() bool { return true }
+	var sent []string
+	journalSend = func(msg string, _ journal.Priority,
+		_ map[string]string) error {
+		sent = append(sent, msg)
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "ephemera-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "fail")
+	err = ioutil.WriteFile(script,
+		[]byte("#!/bin/sh\necho oops >&2\nexit 3\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testjournal\n"+
+		"Start=/bin/echo started\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testjournal.v1]\n"+
+		"RPC/test/fail="+script+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors, debug := &testLogger{}, &testLogger{}
+	c, err := New(From(instance), WithLogger(errors, debug))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CallRPC("test", "fail", []byte("{}"))
+	if err == nil {
+		t.Fatal("expected the RPC to fail")
+	}
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Fatal("expected nothing sent to the journal, got", sent)
+	}
+	if len(errors.msgs) != 1 ||
+		!strings.Contains(errors.msgs[0], "oops") {
+		t.Fatal("expected the script's error to be logged, got",
+			errors.msgs)
+	}
+	if len(debug.msgs) != 1 ||
+		!strings.Contains(debug.msgs[0], "started") {
+		t.Fatal("expected the script's output to be logged, got",
+			debug.msgs)
+	}
+}
+
+type testStructuredLogger struct {
+	testLogger
+	records []ScriptRecord
+}
+
+func (l *testStructuredLogger) LogScript(rec ScriptRecord) {
+	l.mu.Lock()
+	l.records = append(l.records, rec)
+	l.mu.Unlock()
+}
+
+func TestStructuredLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-structured")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "fail")
+	err = ioutil.WriteFile(script,
+		[]byte("#!/bin/sh\necho oops >&2\nexit 3\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.teststructured\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.teststructured.v1]\n"+
+		"RPC/test/fail="+script+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors := &testStructuredLogger{}
+	c, err := New(From(instance), WithLogger(errors, &testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CallRPC("test", "fail", []byte("{}"))
+	if err == nil {
+		t.Fatal("expected the RPC to fail")
+	}
+	if len(errors.records) != 1 {
+		t.Fatal("expected one record, got", errors.records)
+	}
+	rec := errors.records[0]
+	if rec.Component != "net.vyatta.eng.vci.ephemeral.teststructured" ||
+		rec.Model != "net.vyatta.eng.vci.ephemeral.teststructured.v1" ||
+		rec.Operation != "RPC/test/fail" ||
+		rec.ExitCode != 3 ||
+		!strings.Contains(rec.Message, "oops") {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if len(errors.msgs) != 0 {
+		t.Fatal("unexpected messages", errors.msgs)
+	}
+}
+
+func TestModelOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testorder\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testorder.v3]\n"+
+		"RPC/zeta/go=/bin/true\n"+
+		"RPC/alpha/go=/bin/true\n"+
+		"RPC/mu/go=/bin/true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testorder.v1]\n"+
+		"State/Get=/bin/true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testorder.v2]\n"+
+		"State/Get=/bin/true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := c.ModelNames()
+	expected := []string{
+		"net.vyatta.eng.vci.ephemeral.testorder.v1",
+		"net.vyatta.eng.vci.ephemeral.testorder.v2",
+		"net.vyatta.eng.vci.ephemeral.testorder.v3",
+	}
+	if strings.Join(models, " ") != strings.Join(expected, " ") {
+		t.Fatal("unexpected model order", models)
+	}
+	modules := c.Models()[expected[2]].RPCModules()
+	if strings.Join(modules, " ") != "alpha mu zeta" {
+		t.Fatal("unexpected RPC module order", modules)
+	}
+	if c.Models()[expected[0]].RPCModules() != nil {
+		t.Fatal("model without RPCs reported modules")
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, contents string) string {
+		file := filepath.Join(dir, name)
+		err := ioutil.WriteFile(file, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	hash := func(file string) string {
+		c, err := New(From(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Hash()
+	}
+	a := hash(write("a", "[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testhash\n"+
+		"Start=/bin/true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testhash.v1]\n"+
+		"State/Get=/bin/true\n"))
+	// The same definition, laid out differently.
+	b := hash(write("b", "; comment\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testhash.v1]\n"+
+		"State/Get = /bin/true\n"+
+		"[Component]\n"+
+		"Start=/bin/true\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testhash\n"))
+	c := hash(write("c", "[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testhash\n"+
+		"Start=/bin/false\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testhash.v1]\n"+
+		"State/Get=/bin/true\n"))
+	if len(a) != 64 {
+		t.Fatal("unexpected hash", a)
+	}
+	if a != b {
+		t.Fatal("equivalent definitions hashed differently", a, b)
+	}
+	if a == c {
+		t.Fatal("different definitions hashed alike")
+	}
+	// Only the documentation differs.
+	d := hash(write("d", "[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testhash\n"+
+		"Description=Tests hashing\n"+
+		"DocURL=https://example.com/testhash\n"+
+		"Start=/bin/true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testhash.v1]\n"+
+		"State/Get=/bin/true\n"))
+	if a != d {
+		t.Fatal("documentation changed the hash", a, d)
+	}
+}
+
+func TestOnScriptFinished(t *testing.T) {
+	var operations []string
+	var failures int
+	c, err := New(From("testdata/testcache.instance"),
+		OnScriptFinished(func(
+			_ *Component,
+			operation string,
+			_ time.Duration,
+			err error,
+		) {
+			operations = append(operations, operation)
+			if err != nil {
+				failures++
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CallRPC("test", "uncached", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(operations) != 1 || operations[0] != "RPC/test/uncached" {
+		t.Fatal("unexpected operations", operations)
+	}
+	if failures != 0 {
+		t.Fatal("unexpected failures", failures)
+	}
+}
+
+func TestDocumentation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testdoc\n"+
+		"Description=Makes toast\n"+
+		"DocURL=https://example.com/toaster\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testdoc.v1]\n"+
+		"RPC/toaster/make-toast=/bin/true\n"+
+		"RPC/toaster/make-toast/Description=Toasts one slice\n"+
+		"RPC/toaster/make-toast/DocURL=https://example.com/toast\n"+
+		"RPC/toaster/cancel-toast=/bin/true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance), Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Description() != "Makes toast" ||
+		c.DocURL() != "https://example.com/toaster" {
+		t.Fatal("unexpected documentation", c.Description(), c.DocURL())
+	}
+	m := c.Models()["net.vyatta.eng.vci.ephemeral.testdoc.v1"]
+	names := m.RPCNames("toaster")
+	if strings.Join(names, " ") != "cancel-toast make-toast" {
+		t.Fatal("unexpected RPC names", names)
+	}
+	if m.RPCDescription("toaster", "make-toast") != "Toasts one slice" ||
+		m.RPCDocURL("toaster", "make-toast") != "https://example.com/toast" {
+		t.Fatal("unexpected RPC documentation")
+	}
+	if m.RPCDescription("toaster", "cancel-toast") != "" {
+		t.Fatal("undocumented RPC has a description")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testidle\n"+
+		"IdleTimeout=15m\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testidle.v1]\n"+
+		"RPC/test/ping=/bin/true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IdleTimeout() != 15*time.Minute {
+		t.Fatal("unexpected idle timeout", c.IdleTimeout())
+	}
+	if !c.LastUsed().IsZero() {
+		t.Fatal("expected no use yet, got", c.LastUsed())
+	}
+	before := time.Now()
+	_, err = c.CallRPC("test", "ping", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LastUsed().Before(before) {
+		t.Fatal("expected the RPC to count as use, got", c.LastUsed())
+	}
+}
+
+func TestOnRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-demand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testdemand\n"+
+		"OnDemand=true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testdemand.v1]\n"+
+		"RPC/test/ping=/bin/true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := errors.New("refused")
+	var requests int
+	var result error
+	c, err := New(From(instance), OnRequest(func(*Component) error {
+		requests++
+		return result
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.OnDemand() {
+		t.Fatal("expected the component to be OnDemand")
+	}
+	_, err = c.CallRPC("test", "ping", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = refused
+	_, err = c.CallRPC("test", "ping", []byte("{}"))
+	if err != refused {
+		t.Fatal("expected the request to be refused, got", err)
+	}
+	if requests != 2 {
+		t.Fatal("expected 2 requests, got", requests)
+	}
+	if c.Stats()["RPC/test/ping"].Count != 1 {
+		t.Fatal("expected the refused request not to run the script")
+	}
+
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testdemand\n"+
+		"Type=oneshot\n"+
+		"OnDemand=true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(From(instance))
+	if err == nil {
+		t.Fatal("expected OnDemand oneshot components to be rejected")
+	}
+}
+
+func TestBus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-bus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, bus string) string {
+		instance := filepath.Join(dir, name)
+		err := ioutil.WriteFile(instance, []byte("[Component]\n"+
+			"Name=net.vyatta.eng.vci.ephemeral.testbus\n"+
+			bus+
+			"[Model net.vyatta.eng.vci.ephemeral.testbus.v1]\n"+
+			"RPC/test/ping=/bin/true\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance
+	}
+	system, err := New(From(write("system", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system.Bus() != "" {
+		t.Fatal("expected the system bus, got", system.Bus())
+	}
+	test, err := New(From(write("test", "Bus=test\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Bus() != "test" {
+		t.Fatal("expected bus test, got", test.Bus())
+	}
+	if test.Equal(system) {
+		t.Fatal("expected components on different buses to differ")
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, keys string) string {
+		instance := filepath.Join(dir, name)
+		err := ioutil.WriteFile(instance, []byte("[Component]\n"+
+			"Name=net.vyatta.eng.vci.ephemeral.testrestart\n"+
+			keys+
+			"[Model net.vyatta.eng.vci.ephemeral.testrestart.v1]\n"+
+			"RPC/test/ping=/bin/true\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance
+	}
+	c, err := New(From(write("default", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Restart() != RestartNo {
+		t.Fatal("expected no restart policy, got", c.Restart())
+	}
+	if c.RestartDelay() != defaultRestartDelay ||
+		c.RestartMaxDelay() != defaultRestartMaxDelay {
+		t.Fatal("expected the default restart delays, got",
+			c.RestartDelay(), c.RestartMaxDelay())
+	}
+	c, err = New(From(write("restart", "Restart=on-failure\n"+
+		"RestartSec=2s\nRestartMaxSec=1m\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Restart() != RestartOnFailure {
+		t.Fatal("expected on-failure, got", c.Restart())
+	}
+	if c.RestartDelay() != 2*time.Second ||
+		c.RestartMaxDelay() != time.Minute {
+		t.Fatal("unexpected restart delays",
+			c.RestartDelay(), c.RestartMaxDelay())
+	}
+	_, err = New(From(write("bad", "Restart=always\n")))
+	if err == nil {
+		t.Fatal("expected an unknown Restart policy to be rejected")
+	}
+	_, err = New(From(write("oneshot", "Type=oneshot\n"+
+		"Restart=on-failure\n")))
+	if err == nil {
+		t.Fatal("expected Restart to be rejected for a oneshot")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	defer withFeatures(FeatureStateNegativeCache, FeatureStateRefresh)()
+	c, err := New(From("testdata/testcache.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcs, _ := c.Models()["net.vyatta.eng.vci.ephemeral.testcache.v1"].RPC()
+	rpc := rpcs["test"]["cached"].(func(meta, in encodedString) (encodedString, error))
+	before, err := rpc(encodedString("{}"), encodedString("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Refresh(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := rpc(encodedString("{}"), encodedString("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) == string(after) {
+		t.Fatal("cached result was reused after a refresh")
+	}
+
+	c, err = New(From("testdata/testrunerr.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Models()["net.vyatta.eng.vci.ephemeral.testrunerr.v1"].state
+	s.Get()
+	err = c.Refresh(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Get()
+	if count := c.Stats()["State/Get"].Count; count != 2 {
+		t.Fatalf("failure was remembered after a refresh, "+
+			"State/Get ran %d times", count)
+	}
+	err = c.Refresh(true)
+	if err == nil {
+		t.Fatal("expected the failing scripts' errors")
+	}
+	s.Get()
+	if count := c.Stats()["State/Get"].Count; count != 3 {
+		t.Fatalf("failure was not remembered after repopulating, "+
+			"State/Get ran %d times", count)
+	}
+
+	c, err = New(From("testdata/testrun.instance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := c.Models()["net.vyatta.eng.vci.ephemeral.testrun.v1"].config
+	err = c.Refresh(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	populated := conf.Get()
+	if count := c.Stats()["Config/Get"].Count; count != 1 {
+		t.Fatalf("populated configuration was not used, "+
+			"Config/Get ran %d times", count)
+	}
+	if string(populated) != string(conf.Get()) {
+		t.Fatal("populated configuration differs from Config/Get's")
+	}
+	if count := c.Stats()["Config/Get"].Count; count != 2 {
+		t.Fatalf("populated configuration was used more than once, "+
+			"Config/Get ran %d times", count)
+	}
+}
+
+func TestRestartOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-restartonchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := filepath.Join(dir, "instance")
+	write := func(keys string) {
+		err := ioutil.WriteFile(instance, []byte("[Component]\n"+
+			"Name=net.vyatta.eng.vci.ephemeral.testrestartonchange\n"+
+			keys+
+			"[Model net.vyatta.eng.vci.ephemeral.testrestartonchange.v1]\n"+
+			"RPC/test/ping=/bin/true\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("")
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RestartOnChange() {
+		t.Fatal("expected RestartOnChange to default to false")
+	}
+	write("RestartOnChange=true\n")
+	restarted, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.RestartOnChange() {
+		t.Fatal("expected RestartOnChange to be set")
+	}
+	if restarted.Equal(c) {
+		t.Fatal("expected RestartOnChange to distinguish components")
+	}
+	write("Type=oneshot\nRestartOnChange=true\n")
+	_, err = New(From(instance))
+	if err == nil {
+		t.Fatal("expected RestartOnChange to be rejected for a oneshot")
+	}
+}
+
+func TestStateRefresh(t *testing.T) {
+	defer withFeatures(FeatureStateRefresh)()
+	ticks := make(chan time.Time)
+	prevAfter := refreshAfter
+	refreshAfter = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+	defer func() { refreshAfter = prevAfter }()
+	dir := t.TempDir()
+	write := func(start string) string {
+		instance := filepath.Join(dir, "instance")
+		err := ioutil.WriteFile(instance, []byte("[Component]\n"+
+			"Name=net.vyatta.eng.vci.ephemeral.teststaterefresh\n"+
+			"Start="+start+"\n"+
+			"[Model net.vyatta.eng.vci.ephemeral.teststaterefresh.v1]\n"+
+			"State/Get=/bin/echo {}\n"+
+			"State/RefreshInterval=20ms\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance
+	}
+	refreshing := func(s *state) bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.refreshDone != nil
+	}
+
+	c, err := New(From(write("/bin/false")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Models()["net.vyatta.eng.vci.ephemeral.teststaterefresh.v1"].state
+	if c.Start() == nil {
+		t.Fatal("expected Start to fail")
+	}
+	if refreshing(s) {
+		t.Fatal("state is refreshed although Start failed")
+	}
+
+	c, err = New(From(write("/bin/true")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = c.Models()["net.vyatta.eng.vci.ephemeral.teststaterefresh.v1"].state
+	count := func() uint64 {
+		return c.Stats()["State/Get"].Count
+	}
+	s.Get()
+	s.Get()
+	if count() != 1 {
+		t.Fatalf("refreshed state was not served, State/Get ran %d times",
+			count())
+	}
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The loop only waits for the next tick once the refresh the
+	// last one started has finished.
+	ticks <- time.Now()
+	ticks <- time.Now()
+	if count() < 2 {
+		t.Fatalf("state was not refreshed, State/Get ran %d times",
+			count())
+	}
+	err = c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := count()
+	select {
+	case ticks <- time.Now():
+		t.Fatal("state is still refreshed after the component stopped")
+	default:
+	}
+	if count() != stopped {
+		t.Fatal("state was refreshed after the component stopped")
+	}
+	s.Get()
+	if count() != stopped+1 {
+		t.Fatal("state cached while active was served after stopping")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemera-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "/bin/sh " + path
+	}
+	check := script("check", "in=$(cat)\n"+
+		"[ \"$in\" != \"{\" ] && exit 0\n"+
+		"echo '{\"error-type\":\"application\","+
+		"\"error-tag\":\"invalid-value\","+
+		"\"error-message\":\"malformed\"}' >&2\n"+
+		"exit 1\n")
+	instance := filepath.Join(dir, "instance")
+	err = ioutil.WriteFile(instance, []byte("[Component]\n"+
+		"Name=net.vyatta.eng.vci.ephemeral.testconformance\n"+
+		"Start=/bin/true\n"+
+		"[Model net.vyatta.eng.vci.ephemeral.testconformance.v1]\n"+
+		"Config/Get="+script("get", "echo '{\"a\":1}'\n")+"\n"+
+		"Config/Check="+check+"\n"+
+		"Config/Set="+check+"\n"+
+		"State/Get="+script("state", "echo not json\n")+"\n"+
+		"RPC/test/plain="+script("plain", "echo oops >&2; exit 1\n")+
+		"\n"+
+		"RPC/test/slow="+script("slow", "sleep 5\n")+"\n"+
+		"RPC/test/slow/Timeout=50ms\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(From(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(map[string]error)
+	for _, chk := range c.Conformance() {
+		results[chk.Operation+": "+chk.Check] = chk.Err
+	}
+	passed := []string{
+		"Start: succeeds",
+		"Config/Get: outputs a JSON object",
+		"Config/Check: succeeds",
+		"Config/Check: rejects malformed input",
+		"Config/Check: reports errors as an mgmterror",
+		"Config/Set: succeeds",
+		"State/Get: succeeds",
+		"RPC/test/plain: rejects malformed input",
+	}
+	for _, name := range passed {
+		err, ok := results[name]
+		if !ok || err != nil {
+			t.Errorf("%s should have passed, got %v", name, err)
+		}
+	}
+	failed := []string{
+		"State/Get: outputs a JSON object",
+		"RPC/test/plain: reports errors as an mgmterror",
+		"RPC/test/slow: completes within its timeout",
+		"RPC/test/slow: rejects malformed input",
+	}
+	for _, name := range failed {
+		if results[name] == nil {
+			t.Errorf("%s should have failed", name)
+		}
+	}
+	if _, ok := results["Stop: succeeds"]; ok {
+		t.Error("Stop isn't declared, so it shouldn't be checked")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Features gate subsystems so that platform integrators can enable
+// them one at a time as they are adopted.
+const (
+	// FeatureRPCCache caches RPC results for RPCs with a CacheTTL.
+	FeatureRPCCache = "rpc-cache"
+	// FeatureConfigCache caches the last configuration set on models
+	// without a Config/Get script.
+	FeatureConfigCache = "config-cache"
+	// FeatureStateNegativeCache remembers State/Get failures for the
+	// model's NegativeTTL.
+	FeatureStateNegativeCache = "state-negative-cache"
+	// FeatureStateRefresh refreshes the state of models with a
+	// State/RefreshInterval in the background.
+	FeatureStateRefresh = "state-refresh"
+	// FeatureSupervision has ephemerad act on failures by itself,
+	// restarting the listeners of components with Restart=on-failure
+	// and applying their OnRepeatedFailure policy.
+	FeatureSupervision = "supervision"
+	// FeatureScriptProtocol runs the scripts of components declaring
+	// a ProtocolVersion newer than 1 with the newest version both
+	// understand, rather than version 1.
+	FeatureScriptProtocol = "script-protocol"
+)
+
+// Every feature is disabled until it is enabled.
+var features = struct {
+	mu      sync.RWMutex
+	enabled map[string]bool
+}{
+	enabled: map[string]bool{
+		FeatureRPCCache:           false,
+		FeatureConfigCache:        false,
+		FeatureStateNegativeCache: false,
+		FeatureStateRefresh:       false,
+		FeatureSupervision:        false,
+		FeatureScriptProtocol:     false,
+	},
+}
+
+// FeatureNames returns the names of the known features, sorted.
+func FeatureNames() []string {
+	features.mu.RLock()
+	defer features.mu.RUnlock()
+	names := make([]string, 0, len(features.enabled))
+	for name := range features.enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Features returns whether each known feature is enabled.
+func Features() map[string]bool {
+	features.mu.RLock()
+	defer features.mu.RUnlock()
+	out := make(map[string]bool, len(features.enabled))
+	for name, enabled := range features.enabled {
+		out[name] = enabled
+	}
+	return out
+}
+
+// SetFeature enables or disables the named feature.
+func SetFeature(name string, enabled bool) error {
+	features.mu.Lock()
+	defer features.mu.Unlock()
+	if _, ok := features.enabled[name]; !ok {
+		return errors.New("unknown feature " + name)
+	}
+	features.enabled[name] = enabled
+	return nil
+}
+
+// ParseFeatures reads a comma separated list of features, where a
+// feature prefixed by '-' is disabled and any other is enabled.
+func ParseFeatures(s string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		enabled := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if _, ok := Features()[name]; !ok {
+			return nil, errors.New("unknown feature " + name)
+		}
+		out[name] = enabled
+	}
+	return out, nil
+}
+
+// FeatureEnabled reports whether the named feature is enabled.
+func FeatureEnabled(name string) bool {
+	return featureEnabled(name)
+}
+
+func featureEnabled(name string) bool {
+	features.mu.RLock()
+	defer features.mu.RUnlock()
+	return features.enabled[name]
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"sync"
+	"time"
+)
+
+// activity records when a component last served a Config, State or
+// RPC request.
+type activity struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (a *activity) touch() {
+	a.mu.Lock()
+	a.last = time.Now()
+	a.mu.Unlock()
+}
+
+func (a *activity) get() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+// IdleTimeout is how long the component may go without a Config,
+// State or RPC request before it should be deactivated, as given by
+// its IdleTimeout key. Zero means it is never deactivated for being
+// idle.
+func (c *Component) IdleTimeout() time.Duration {
+	return c.idleTimeout
+}
+
+// LastUsed is when the component last served a Config, State or RPC
+// request, including those answered from a cache, or the zero time
+// if it hasn't since it was loaded.
+func (c *Component) LastUsed() time.Time {
+	return c.runner.activity.get()
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/go-ini/ini"
+)
+
+// instanceSources are the files an instance is read from.
+type instanceSources struct {
+	// files are all of the files in the order they are merged.
+	files []string
+	// bases are the instance files inherited from, the most distant
+	// first.
+	bases   []string
+	dropIns []string
+}
+
+// resolveSources finds the files making up instanceFile. An instance
+// file naming another in its BaseInstance key, relative to its own
+// directory unless absolute, inherits everything from it, along with
+// that file's own base and drop-ins, and then overrides it.
+func resolveSources(
+	instanceFile string,
+	seen map[string]bool,
+) (*instanceSources, error) {
+	if seen[instanceFile] {
+		return nil, errors.New("BaseInstance loop through " +
+			instanceFile)
+	}
+	seen[instanceFile] = true
+	fragments, err := dropIns(instanceFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ini.Load(instanceFile, toSources(fragments)...)
+	if err != nil {
+		return nil, err
+	}
+	sources := &instanceSources{}
+	base := cfg.Section("Component").Key("BaseInstance").MustString("")
+	if base != "" {
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(filepath.Dir(instanceFile), base)
+		}
+		sources, err = resolveSources(base, seen)
+		if err != nil {
+			return nil, errors.New(instanceFile + ": " + err.Error())
+		}
+		sources.bases = append(sources.bases, base)
+	}
+	sources.files = append(sources.files, instanceFile)
+	sources.files = append(sources.files, fragments...)
+	sources.dropIns = append(sources.dropIns, fragments...)
+	return sources, nil
+}
+
+// loadInstance reads the instance file merged over its bases and
+// with its drop-in fragments merged over it, so that each file's keys
+// add to or replace those of the files before it.
+func loadInstance(instanceFile string) (*ini.File, *instanceSources, error) {
+	sources, err := resolveSources(instanceFile, make(map[string]bool))
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := ini.Load(sources.files[0],
+		toSources(sources.files[1:])...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, sources, nil
+}
+
+func toSources(files []string) []interface{} {
+	out := make([]interface{}, len(files))
+	for i, file := range files {
+		out[i] = file
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// journalEnabled and journalSend are the systemd journal, which tests
+// may replace.
+var (
+	journalEnabled = journal.Enabled
+	journalSend    = journal.Send
+)
+
+// scriptEvent identifies a single script invocation so that it can
+// be logged with structured fields when running under systemd, for
+// example 'journalctl COMPONENT=net.vyatta.eng.vci.example', unless
+// the component was given its own loggers.
+type scriptEvent struct {
+	logs      *loggers
+	compName  string
+	modelName string
+	operation string
+	environ   []string
+	begin     time.Time
+	// exitCode is the script's exit status once it has exited.
+	exitCode int
+}
+
+func (e *scriptEvent) record(exitCode int, msg string) ScriptRecord {
+	return ScriptRecord{
+		Component: e.compName,
+		Model:     e.modelName,
+		Operation: e.operation,
+		Duration:  time.Since(e.begin),
+		ExitCode:  exitCode,
+		Message:   msg,
+	}
+}
+
+func (e *scriptEvent) fields(exitCode int) map[string]string {
+	vars := map[string]string{
+		"COMPONENT": e.compName,
+		"OPERATION": e.operation,
+	}
+	if e.modelName != "" {
+		vars["MODEL"] = e.modelName
+	}
+	if exitCode >= 0 {
+		vars["EXIT_CODE"] = strconv.Itoa(exitCode)
+	}
+	return vars
+}
+
+func (e *scriptEvent) logError(merr, err error) {
+	exitCode := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	}
+	if sl, ok := e.logs.elog().(StructuredLogger); ok {
+		sl.LogScript(e.record(exitCode, merr.Error()))
+		return
+	}
+	if e.logs.ownErrors() || !journalEnabled() {
+		e.logs.elog().Printf("Error for %s: %s / %s\n", e.environ, merr, err)
+		return
+	}
+	journalSend("Error for "+e.operation+": "+merr.Error(),
+		journal.PriErr, e.fields(exitCode))
+}
+
+func (e *scriptEvent) logOutput(out []byte) {
+	if sl, ok := e.logs.dlog().(StructuredLogger); ok {
+		sl.LogScript(e.record(e.exitCode, string(out)))
+		return
+	}
+	if e.logs.ownDebug() || !journalEnabled() {
+		e.logs.dlog().Printf("Output for %s\n%s\n", e.environ, string(out))
+		return
+	}
+	journalSend("Output for "+e.operation+"\n"+string(out),
+		journal.PriDebug, e.fields(-1))
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// defaultLegacyActions are the actions a legacy component wrapper is
+// assumed to implement when its model doesn't list them.
+const defaultLegacyActions = "validate commit get-config get-state"
+
+// legacyActions maps the actions of legacy Vyatta component wrappers,
+// which are run as "wrapper --action=<action>", to the model
+// operations they implement.
+var legacyActions = map[string]string{
+	"validate":   "Config/Check",
+	"commit":     "Config/Set",
+	"get-config": "Config/Get",
+	"get-state":  "State/Get",
+}
+
+// expandLegacyWrappers lets a model be implemented by an existing
+// wrapper script rather than a script per operation. A model naming
+// the wrapper in its LegacyWrapper key gets the operations listed in
+// LegacyActions, and the RPCs, as module/name, listed in LegacyRPCs,
+// each run as the wrapper with --action=<action>, or --action=<name>
+// for RPCs. Operations the model sets itself are left alone. Unknown
+// actions and malformed RPCs are skipped with an error logged, or
+// refused, all together, if strict is set.
+func expandLegacyWrappers(cfg *ini.File, logs *loggers, strict bool) error {
+	var problems []string
+	skip := func(problem string) {
+		if strict {
+			problems = append(problems, problem)
+			return
+		}
+		logs.elog().Println("skipping", problem)
+	}
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "Model ") {
+			continue
+		}
+		wrapper := section.Key("LegacyWrapper").MustString("")
+		if wrapper == "" {
+			continue
+		}
+		set := func(key, action string) {
+			if section.HasKey(key) {
+				return
+			}
+			section.Key(key).SetValue(wrapper + " --action=" + action)
+		}
+		actions := section.Key("LegacyActions").
+			MustString(defaultLegacyActions)
+		for _, action := range strings.Fields(actions) {
+			operation, ok := legacyActions[action]
+			if !ok {
+				skip("unknown legacy action " + action + " in [" +
+					section.Name() + "]")
+				continue
+			}
+			set(operation, action)
+		}
+		for _, rpc := range strings.Fields(
+			section.Key("LegacyRPCs").MustString("")) {
+			parts := strings.Split(rpc, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				skip("malformed legacy RPC " + rpc + " in [" +
+					section.Name() + "], expected module/name")
+				continue
+			}
+			set("RPC/"+rpc, parts[1])
+		}
+	}
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/danos/mgmterror"
+)
+
+const defaultLockDir = "/run/ephemera/lock"
+
+// componentLock is an advisory lock, taken with flock(2) on a file
+// managed by ephemera, that a component's scripts can use to
+// serialise access to resources they share. The file is exported to
+// scripts as EPHEMERA_LOCKFILE, for example for use with flock(1).
+// With AutoLock ephemera holds the lock itself while running Start,
+// Stop and Config/Set.
+type componentLock struct {
+	path string
+	auto bool
+}
+
+func componentLockNew(lockDir, compName string, auto bool) *componentLock {
+	return &componentLock{
+		path: filepath.Join(lockDir, compName+".lock"),
+		auto: auto,
+	}
+}
+
+func (l *componentLock) locks(operation string) bool {
+	if !l.auto {
+		return false
+	}
+	switch operation {
+	case "Start", "Stop", "Config/Set":
+		return true
+	default:
+		return false
+	}
+}
+
+// defaultLockWait is how long acquire waits for the lock for an
+// operation without a timeout.
+const defaultLockWait = time.Minute
+
+// lockRetryInterval is how often acquire tries again to take a lock
+// another holder has.
+const lockRetryInterval = 50 * time.Millisecond
+
+// acquire takes the lock, if operation should be run holding it,
+// waiting for any other holder to release it for at most the
+// operation's timeout, or defaultLockWait if it has none. The
+// returned function releases the lock. The lock directory is always
+// created so that scripts can take the lock themselves.
+func (l *componentLock) acquire(
+	operation string,
+	timeout time.Duration,
+) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(l.path), 0755)
+	if !l.locks(operation) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultLockWait
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK {
+			break
+		}
+		if time.Now().After(deadline) {
+			err = lockTimeoutError(operation, timeout)
+			break
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (l *componentLock) Equal(other interface{}) bool {
+	ol, isLock := other.(*componentLock)
+	return isLock && l.path == ol.path && l.auto == ol.auto
+}
+
+// lockTimeoutError is the error an operation fails with when the lock
+// it must hold couldn't be taken in time. It is coded as a timeout.
+func lockTimeoutError(operation string, timeout time.Duration) error {
+	err := mgmterror.NewOperationFailedApplicationError()
+	err.AppTag = AppTagTimeout
+	err.Message = message(MsgLockTimeout,
+		"operation", operation, "timeout", timeout.String())
+	return err
+}
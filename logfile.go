@@ -0,0 +1,118 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultLogFileMaxSize = 1024 * 1024
+
+// scriptLog appends the output of every script run for a component to
+// a file. Once the file would grow beyond maxSize it is moved aside
+// to path.1, replacing any previous one, and a new file started.
+type scriptLog struct {
+	path    string
+	maxSize int64
+	logs    *loggers
+
+	mu sync.Mutex
+}
+
+func (l *scriptLog) write(environ []string, out, errOut []byte, err error) {
+	if l == nil {
+		return
+	}
+
+	var rec bytes.Buffer
+	fmt.Fprintf(&rec, "%s %s", time.Now().Format(time.RFC3339),
+		strings.Join(environ, " "))
+	if err != nil {
+		fmt.Fprintf(&rec, " failed: %s", err)
+	}
+	rec.WriteByte('\n')
+	writeStream(&rec, "stdout", out)
+	writeStream(&rec, "stderr", errOut)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	werr := l.append(rec.Bytes())
+	if werr != nil {
+		l.logs.elog().Printf("Error writing %s: %s\n", l.path, werr)
+	}
+}
+
+func writeStream(rec *bytes.Buffer, name string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	rec.WriteString(name + ":\n")
+	rec.Write(data)
+	if data[len(data)-1] != '\n' {
+		rec.WriteByte('\n')
+	}
+}
+
+func (l *scriptLog) append(rec []byte) error {
+	err := os.MkdirAll(filepath.Dir(l.path), 0755)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(l.path)
+	if err == nil && fi.Size()+int64(len(rec)) > l.maxSize {
+		err = os.Rename(l.path, l.path+".1")
+		if err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(rec)
+	cerr := f.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// tail returns up to the last n lines captured, drawing on the
+// rotated file when the current one is too short.
+func (l *scriptLog) tail(n int) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lines []string
+	for _, path := range []string{l.path + ".1", l.path} {
+		buf, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines,
+			strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")...)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+func (l *scriptLog) Equal(other interface{}) bool {
+	ol, isLog := other.(*scriptLog)
+	if l == nil || ol == nil {
+		return isLog && l == ol
+	}
+	return l.path == ol.path && l.maxSize == ol.maxSize
+}
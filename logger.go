@@ -0,0 +1,107 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"log"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Logger receives ephemera's log messages. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
+// ScriptRecord describes the error or output of a script invocation.
+type ScriptRecord struct {
+	Component string
+	Model     string
+	Operation string
+	Duration  time.Duration
+	// ExitCode is the script's exit status, or -1 if it didn't
+	// exit, such as when it couldn't be started.
+	ExitCode int
+	Message  string
+}
+
+// StructuredLogger is a Logger that also takes script errors and
+// output as records, so that they can be logged with their fields,
+// such as in JSON for log pipelines to index.
+type StructuredLogger interface {
+	Logger
+	LogScript(ScriptRecord)
+}
+
+// defaultLoggers log errors and debugging output to syslog, falling
+// back to stderr and stdout. They are only created when first used,
+// so that components given their own loggers never touch syslog.
+var defaultLoggers struct {
+	once       sync.Once
+	elog, dlog Logger
+}
+
+func initDefaultLoggers() {
+	defaultLoggers.once.Do(func() {
+		var err error
+		defaultLoggers.elog, err = syslog.NewLogger(syslog.LOG_ERR, 0)
+		if err != nil {
+			defaultLoggers.elog = log.New(os.Stderr, "", 0)
+		}
+		defaultLoggers.dlog, err = syslog.NewLogger(syslog.LOG_DEBUG, 0)
+		if err != nil {
+			defaultLoggers.dlog = log.New(os.Stdout, "", 0)
+		}
+	})
+}
+
+// loggers are where a component's messages are logged. Unset
+// loggers, and a nil *loggers, use the defaults.
+type loggers struct {
+	errors Logger
+	debug  Logger
+}
+
+func (l *loggers) elog() Logger {
+	if l != nil && l.errors != nil {
+		return l.errors
+	}
+	initDefaultLoggers()
+	return defaultLoggers.elog
+}
+
+// ownErrors reports whether errors go to a logger given to
+// WithLogger rather than the default.
+func (l *loggers) ownErrors() bool {
+	return l != nil && l.errors != nil
+}
+
+// ownDebug reports whether debugging output goes to a logger given to
+// WithLogger rather than the default.
+func (l *loggers) ownDebug() bool {
+	return l != nil && l.debug != nil
+}
+
+func (l *loggers) dlog() Logger {
+	if l != nil && l.debug != nil {
+		return l.debug
+	}
+	initDefaultLoggers()
+	return defaultLoggers.dlog
+}
+
+// WithLogger logs the component's errors to errors and its debugging
+// output to debug instead of to syslog. Either may be nil to keep the
+// default. Script errors and output are given to a StructuredLogger
+// as records, and otherwise logged through the logger given. Only the
+// defaults send them to the systemd journal, with structured fields,
+// when it is available.
+func WithLogger(errors, debug Logger) Opt {
+	return func(c *Component) {
+		c.logs = &loggers{errors: errors, debug: debug}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"strings"
+	"sync"
+)
+
+// Message codes identify the operator-facing errors ephemera returns
+// itself, so that their messages can be translated with SetMessages.
+// Their error-app-tags, which automation matches on, never change.
+const (
+	// MsgOutsideActiveWindow refuses activation outside the
+	// component's ActiveWindow, with the AppTagPolicyDenied tag.
+	MsgOutsideActiveWindow = "outside-active-window"
+	// MsgScriptTimeout fails an operation whose script timed out,
+	// with the AppTagTimeout tag.
+	MsgScriptTimeout = "script-timeout"
+	// MsgLockTimeout fails an operation that couldn't take the
+	// component's lock in time, with the AppTagTimeout tag.
+	MsgLockTimeout = "lock-timeout"
+)
+
+// defaultMessages are the messages used for codes without a
+// translation. {name} in a message is replaced by the argument of
+// that name.
+var defaultMessages = map[string]string{
+	MsgOutsideActiveWindow: "component {component} may only be " +
+		"activated during its active window ({window})",
+	MsgScriptTimeout: "{operation} timed out after {timeout}",
+	MsgLockTimeout: "{operation} timed out after {timeout} waiting " +
+		"for the component lock",
+}
+
+var translations struct {
+	sync.Mutex
+	messages map[string]string
+}
+
+// SetMessages translates the messages of ephemera's operator-facing
+// errors, keyed by message code, replacing any translations set
+// before. Codes it doesn't mention keep their English message, and
+// codes ephemera doesn't know are ignored, so a catalog can be shared
+// with its callers.
+func SetMessages(messages map[string]string) {
+	copied := make(map[string]string, len(messages))
+	for code, msg := range messages {
+		copied[code] = msg
+	}
+	translations.Lock()
+	translations.messages = copied
+	translations.Unlock()
+}
+
+// message returns the message for code with its arguments, given as
+// name and value pairs, filled in.
+func message(code string, args ...string) string {
+	translations.Lock()
+	msg, ok := translations.messages[code]
+	translations.Unlock()
+	if !ok {
+		msg = defaultMessages[code]
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		msg = strings.ReplaceAll(msg, "{"+args[i]+"}", args[i+1])
+	}
+	return msg
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/go-ini/ini"
+)
+
+// migrations rewrites an instance file written for an older format
+// version into the next one. migrations[v-1] takes a file from
+// version v to version v+1, so there is always one fewer entry than
+// CurrentFormatVersion.
+var migrations []func(*ini.File) error
+
+// declaredFormatVersion returns the format version an instance file declares,
+// which is 1 for files that predate the FormatVersion key.
+func declaredFormatVersion(cfg *ini.File) (int, error) {
+	key := cfg.Section("Component").Key("FormatVersion")
+	if key.String() == "" {
+		return 1, nil
+	}
+	v, err := key.Int()
+	if err != nil || v < 1 {
+		return 0, errors.New("invalid FormatVersion " + key.String())
+	}
+	return v, nil
+}
+
+// migrate brings an instance file up to CurrentFormatVersion, refusing
+// those written for a newer version than this release understands
+// rather than misinterpreting them. It returns the version the file
+// declared.
+func migrate(cfg *ini.File) (int, error) {
+	return migrateTo(cfg, CurrentFormatVersion)
+}
+
+func migrateTo(cfg *ini.File, target int) (int, error) {
+	v, err := declaredFormatVersion(cfg)
+	if err != nil {
+		return 0, err
+	}
+	if v > target {
+		return v, errors.New("instance file FormatVersion " +
+			strconv.Itoa(v) + " is newer than the newest supported " +
+			"version " + strconv.Itoa(target) +
+			"; install a version of the instance file for this " +
+			"release or upgrade ephemera")
+	}
+	for from := v; from < target; from++ {
+		err = migrations[from-1](cfg)
+		if err != nil {
+			return v, errors.New("migrating instance file from " +
+				"FormatVersion " + strconv.Itoa(from) + ": " +
+				err.Error())
+		}
+	}
+	cfg.Section("Component").Key("FormatVersion").
+		SetValue(strconv.Itoa(target))
+	return v, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/danos/mgmterror"
+)
+
+// opLimiter bounds how many of a component's scripts run at once.
+// Operations beyond maxInFlight wait their turn, unless maxQueued are
+// already waiting in which case they fail immediately as busy. Zero
+// means no limit for either. Once backpressure operations are
+// queued the component is overloaded and scripts are asked to reduce
+// the work they do; zero means never.
+type opLimiter struct {
+	maxInFlight  int
+	maxQueued    int
+	backpressure int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inFlight int
+	queued   int
+}
+
+func opLimiterNew(maxInFlight, maxQueued int) *opLimiter {
+	l := &opLimiter{maxInFlight: maxInFlight, maxQueued: maxQueued}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *opLimiter) acquire(compName string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxInFlight <= 0 || l.inFlight < l.maxInFlight {
+		l.inFlight++
+		return nil
+	}
+	if l.maxQueued > 0 && l.queued >= l.maxQueued {
+		err := mgmterror.NewInUseApplicationError()
+		err.Message = "component " + compName + " is busy, " +
+			strconv.Itoa(l.queued) + " operations already queued"
+		return err
+	}
+	l.queued++
+	for l.inFlight >= l.maxInFlight {
+		l.cond.Wait()
+	}
+	l.queued--
+	l.inFlight++
+	return nil
+}
+
+func (l *opLimiter) counts() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, l.queued
+}
+
+func (l *opLimiter) overloaded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.backpressure > 0 && l.queued >= l.backpressure
+}
+
+func (l *opLimiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.cond.Signal()
+}
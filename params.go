@@ -0,0 +1,124 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danos/mgmterror"
+)
+
+const defaultSessionLease = time.Hour
+
+var parameterName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// scriptParams holds the parameters a component's Start script is run
+// with, as given by whoever last activated the component. Only the
+// names declared by the component's Parameters key are accepted, for
+// Start and for the one-off parameters of Stop.
+type scriptParams struct {
+	declared []string
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func parseParameters(s string) (*scriptParams, error) {
+	declared := strings.Fields(s)
+	for _, name := range declared {
+		if !parameterName.MatchString(name) {
+			return nil, errors.New("invalid parameter name " + name)
+		}
+	}
+	sort.Strings(declared)
+	return &scriptParams{declared: declared}, nil
+}
+
+func (p *scriptParams) isDeclared(name string) bool {
+	i := sort.SearchStrings(p.declared, name)
+	return i < len(p.declared) && p.declared[i] == name
+}
+
+func (p *scriptParams) check(values map[string]string) error {
+	for name := range values {
+		if !p.isDeclared(name) {
+			err := mgmterror.NewInvalidValueApplicationError()
+			err.Message = "unknown parameter " + name
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *scriptParams) set(values map[string]string) error {
+	err := p.check(values)
+	if err != nil {
+		return err
+	}
+	copied := make(map[string]string, len(values))
+	for name, value := range values {
+		copied[name] = value
+	}
+	p.mu.Lock()
+	p.values = copied
+	p.mu.Unlock()
+	return nil
+}
+
+// get returns a copy of the parameters last set.
+func (p *scriptParams) get() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[string]string, len(p.values))
+	for name, value := range p.values {
+		out[name] = value
+	}
+	return out
+}
+
+// input returns the parameters last set as Start's input.
+func (p *scriptParams) input() ([]byte, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return encodeParameters(p.values)
+}
+
+// encodeParameters returns values as a script's standard input, a
+// JSON object mapping names to values, and its environment, with each
+// parameter as EPHEMERA_PARAM_<NAME>. There is no input if there are
+// no values.
+func encodeParameters(values map[string]string) ([]byte, []string) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	in, _ := json.Marshal(values)
+	var env []string
+	for name, value := range values {
+		env = append(env, "EPHEMERA_PARAM_"+
+			strings.ToUpper(strings.Replace(name, "-", "_", -1))+
+			"="+value)
+	}
+	sort.Strings(env)
+	return in, env
+}
+
+// sessionParameters returns params as a session's script input, along
+// with the session's id as EPHEMERA_SESSION_ID.
+func sessionParameters(
+	id string,
+	params map[string]string,
+) ([]byte, []string) {
+	in, env := encodeParameters(params)
+	return in, append(env, "EPHEMERA_SESSION_ID="+id)
+}
+
+func (p *scriptParams) Equal(other *scriptParams) bool {
+	return equalStrings(p.declared, other.declared)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// maxProcessGroups bounds how many process groups of finished scripts
+// are remembered for attributing orphans.
+const maxProcessGroups = 1024
+
+// scriptProcs tracks the scripts ephemera has started. Each script
+// leads its own process group, which any processes it leaves behind
+// remain in, so that a process reaper can tell its own direct
+// children apart from adopted orphans and attribute the orphans to
+// the component whose script created them.
+type scriptProcs struct {
+	mu      sync.Mutex
+	running map[int]bool
+	groups  map[int]string
+	order   []int
+}
+
+var procs = &scriptProcs{
+	running: make(map[int]bool),
+	groups:  make(map[int]string),
+}
+
+// start starts cmd, registering it before any reaper can see it exit.
+func (p *scriptProcs) start(compName string, cmd *exec.Cmd) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	p.running[pid] = true
+	if _, ok := p.groups[pid]; !ok {
+		p.order = append(p.order, pid)
+	}
+	p.groups[pid] = compName
+	if len(p.order) > maxProcessGroups {
+		delete(p.groups, p.order[0])
+		p.order = p.order[1:]
+	}
+	return nil
+}
+
+func (p *scriptProcs) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	p.mu.Lock()
+	delete(p.running, cmd.Process.Pid)
+	p.mu.Unlock()
+	return err
+}
+
+// IsScript reports whether pid is a script started by ephemera that
+// has not yet been waited for. Reapers must leave these alone.
+func IsScript(pid int) bool {
+	procs.mu.Lock()
+	defer procs.mu.Unlock()
+	return procs.running[pid]
+}
+
+// ProcessGroupOwner returns the name of the component whose script
+// led the process group pgid, if it is known.
+func ProcessGroupOwner(pgid int) (string, bool) {
+	procs.mu.Lock()
+	defer procs.mu.Unlock()
+	name, ok := procs.groups[pgid]
+	return name, ok
+}
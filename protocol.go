@@ -0,0 +1,40 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+
+	"github.com/go-ini/ini"
+)
+
+// CurrentProtocolVersion is the newest version of the contract between
+// ephemera and the scripts it runs: their environment, arguments and
+// the data passed on stdin and stdout. Scripts are told the version
+// in use by EPHEMERA_PROTOCOL_VERSION.
+const CurrentProtocolVersion = 1
+
+// negotiateProtocolVersion returns the protocol version to run a
+// component's scripts with. The ProtocolVersion key declares the
+// newest version the scripts understand, 1 if it is absent, and the
+// newest version both sides understand is used so that scripts
+// written for a later release still work with this one. Unless the
+// script-protocol feature is enabled version 1 is always used.
+func negotiateProtocolVersion(section *ini.Section) (int, error) {
+	key := section.Key("ProtocolVersion")
+	if key.String() == "" {
+		return 1, nil
+	}
+	v, err := key.Int()
+	if err != nil || v < 1 {
+		return 0, errors.New("invalid ProtocolVersion " + key.String())
+	}
+	if !featureEnabled(FeatureScriptProtocol) {
+		return 1, nil
+	}
+	if v > CurrentProtocolVersion {
+		return CurrentProtocolVersion, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,134 @@
+# Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+#
+# SPDX-License-Identifier: GPL-2.0-only
+
+"""Control the components ephemerad manages from Python.
+
+Each call runs 'ephemeractl call', which makes the ephemerad-v1 RPC on
+the VCI bus with RFC 7951 JSON input and prints the JSON output, so
+automation needn't parse the output of the activate and deactivate
+binaries:
+
+    import ephemerad
+
+    c = ephemerad.Client()
+    session = c.activate("net.vyatta.vci.toaster", wait_for_install=True)
+"""
+
+import json
+import subprocess
+
+MODULE = "ephemerad-v1"
+
+
+class Error(Exception):
+    """An RPC failed, or ephemeractl couldn't make it."""
+
+
+def _qualify(value):
+    return {MODULE + ":" + k: v for k, v in value.items()}
+
+
+def _unqualify(value):
+    prefix = MODULE + ":"
+    return {k[len(prefix):] if k.startswith(prefix) else k: v
+            for k, v in value.items()}
+
+
+def _parameters(params):
+    return [{"name": name, "value": str(value)}
+            for name, value in sorted(params.items())]
+
+
+class Client:
+    """Client calls ephemerad's RPCs.
+
+    ephemeractl is the command used to make the calls, and timeout,
+    in seconds, how long each may take before Error is raised.
+    """
+
+    def __init__(self, ephemeractl="ephemeractl", timeout=None):
+        self.ephemeractl = ephemeractl
+        self.timeout = timeout
+
+    def call(self, rpc, **inputs):
+        """Call the named ephemerad-v1 RPC.
+
+        The keyword arguments are its input, with '_' in place of '-'
+        in their names, and its output is returned as a dict without
+        the module's prefix. Inputs that are None are left out.
+        """
+        body = _qualify({k.replace("_", "-"): v
+                         for k, v in inputs.items() if v is not None})
+        try:
+            proc = subprocess.run(
+                [self.ephemeractl, "call", rpc],
+                input=json.dumps(body),
+                stdout=subprocess.PIPE,
+                stderr=subprocess.PIPE,
+                universal_newlines=True,
+                timeout=self.timeout)
+        except (OSError, subprocess.TimeoutExpired) as e:
+            raise Error("{}: {}".format(rpc, e)) from e
+        if proc.returncode != 0:
+            raise Error(proc.stderr.strip() or
+                        "{}: exit status {}".format(rpc, proc.returncode))
+        out = proc.stdout.strip()
+        return _unqualify(json.loads(out)) if out else {}
+
+    def activate(self, component, parameters=None, lease=None,
+                 wait_for_install=False):
+        """Activate the named component.
+
+        parameters are given to the Start script, or to the new
+        session's Start for components with sessions, lease is how
+        many seconds a new session lasts unless renewed, and
+        wait_for_install waits for the component to be installed if it
+        isn't already. For components with sessions the session started
+        is returned as a dict with 'session' and 'expires', and
+        otherwise None.
+        """
+        out = self.call(
+            "activate",
+            component=component,
+            parameter=_parameters(parameters) if parameters else None,
+            lease=lease,
+            wait_for_install=wait_for_install or None)
+        return out if out.get("session") else None
+
+    def deactivate(self, component, parameters=None, session=None):
+        """Deactivate the named component.
+
+        parameters are given to the Stop script, and session tears down
+        only that session, leaving the component and its other sessions
+        running.
+        """
+        self.call(
+            "deactivate",
+            component=component,
+            parameter=_parameters(parameters) if parameters else None,
+            session=session)
+
+    def list_components(self, owner=None):
+        """Return the components ephemerad manages, as dicts, or only
+        those belonging to owner if it is given."""
+        return self.call("list-components", owner=owner).get(
+            "component", [])
+
+    def status(self, component=None):
+        """Return the status of each component, as dicts, or only of
+        the named component if it is given."""
+        return self.call("status", component=component).get(
+            "component", [])
+
+    def refresh_state(self, component, populate=False):
+        """Drop the results the named component has cached, running
+        its Get scripts again straight away if populate is set and it
+        is active."""
+        self.call("refresh-state", component=component,
+                  populate=populate or None)
+
+    def rescan(self):
+        """Reread the instance directories, returning what was loaded,
+        rejected and ignored as duplicates."""
+        return self.call("rescan")
@@ -0,0 +1,81 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strings"
+)
+
+// Refresh drops what the component has cached from its scripts: the
+// results of RPCs declared with a CacheTTL, the failures of State/Get
+// remembered for their NegativeTTL and the state kept by models with
+// a State/RefreshInterval, so that the next request runs the script
+// again. With populate set it then runs each enabled model's
+// Config/Get and State/Get scripts straight away, so that a backend
+// that is still failing is seen, and remembered, at once. Their
+// output is kept: Config/Get's answers the next request for the
+// model's configuration, and State/Get's is the refreshed state of
+// models with a State/RefreshInterval. The errors of those scripts
+// are returned together. Populating should be left to active
+// components, as it runs their scripts.
+func (c *Component) Refresh(populate bool) error {
+	var errs []string
+	for _, name := range c.ModelNames() {
+		m := c.models[name]
+		if m.rpc != nil {
+			m.rpc.cache.clear()
+		}
+		if m.state != nil {
+			m.state.forget()
+		}
+		if m.config != nil {
+			m.config.prime(nil)
+		}
+		if !populate || !m.enabled {
+			continue
+		}
+		if m.config != nil && m.config.get != "" {
+			buf, err := c.runner.output(name, "Config/Get",
+				m.config.get, nil)
+			if err != nil {
+				errs = append(errs, name+": "+err.Error())
+			} else {
+				m.config.prime(buf)
+			}
+		}
+		if m.state != nil && m.state.get != "" {
+			buf, err := c.runner.output(name, "State/Get",
+				m.state.get, nil)
+			if err != nil {
+				m.state.failed()
+				errs = append(errs, name+": "+err.Error())
+				continue
+			}
+			m.state.store(buf)
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// prime keeps buf, the output of Config/Get, to answer the next Get,
+// or drops what was kept if buf is nil.
+func (c *config) prime(buf encodedString) {
+	c.mu.Lock()
+	c.primed = buf
+	c.mu.Unlock()
+}
+
+// takePrimed returns, and drops, the output of Config/Get kept by
+// prime, if there is any.
+func (c *config) takePrimed() (encodedString, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := c.primed
+	c.primed = nil
+	return buf, buf != nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/danos/mgmterror"
+)
+
+// ResourceLimits caps the scripts run on behalf of all components. A
+// limit of zero means no limit.
+type ResourceLimits struct {
+	// MaxProcesses is the most scripts that may run at once.
+	MaxProcesses int
+	// MaxComponentProcesses is the most scripts that may run at
+	// once for a single component.
+	MaxComponentProcesses int
+}
+
+// ResourceUsage describes the scripts running at a moment.
+type ResourceUsage struct {
+	Processes int
+	// Pipes connecting ephemera to the running scripts.
+	Pipes int
+	// Components maps component names to their running scripts.
+	Components map[string]int
+}
+
+// accounting tracks the processes and pipes held by running scripts
+// so that a misbehaving component can't exhaust the PIDs or file
+// descriptors available to the daemon.
+type accounting struct {
+	mu         sync.Mutex
+	limits     ResourceLimits
+	processes  int
+	pipes      int
+	components map[string]int
+}
+
+var resources = &accounting{components: make(map[string]int)}
+
+// SetResourceLimits sets the limits applied to subsequent script
+// invocations.
+func SetResourceLimits(limits ResourceLimits) {
+	resources.mu.Lock()
+	resources.limits = limits
+	resources.mu.Unlock()
+}
+
+// Resources returns the resources currently held by running scripts.
+func Resources() ResourceUsage {
+	resources.mu.Lock()
+	defer resources.mu.Unlock()
+	usage := ResourceUsage{
+		Processes:  resources.processes,
+		Pipes:      resources.pipes,
+		Components: make(map[string]int, len(resources.components)),
+	}
+	for name, n := range resources.components {
+		usage.Components[name] = n
+	}
+	return usage
+}
+
+// acquire accounts for a script about to be run, failing with a
+// resource-denied error if that would exceed a limit.
+func (a *accounting) acquire(compName string, pipes int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.limits.MaxProcesses > 0 &&
+		a.processes >= a.limits.MaxProcesses {
+		err := mgmterror.NewResourceDeniedApplicationError()
+		err.Message = "too many scripts running (limit " +
+			strconv.Itoa(a.limits.MaxProcesses) + ")"
+		return err
+	}
+	if a.limits.MaxComponentProcesses > 0 &&
+		a.components[compName] >= a.limits.MaxComponentProcesses {
+		err := mgmterror.NewResourceDeniedApplicationError()
+		err.Message = "too many scripts running for " + compName +
+			" (limit " + strconv.Itoa(a.limits.MaxComponentProcesses) +
+			")"
+		return err
+	}
+	a.processes++
+	a.pipes += pipes
+	a.components[compName]++
+	return nil
+}
+
+func (a *accounting) release(compName string, pipes int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.processes--
+	a.pipes -= pipes
+	a.components[compName]--
+	if a.components[compName] == 0 {
+		delete(a.components, compName)
+	}
+}
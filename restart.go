@@ -0,0 +1,75 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultRestartDelay    = time.Second
+	defaultRestartMaxDelay = 5 * time.Minute
+)
+
+// RestartPolicy says whether a component's listener should be
+// restarted if it drops off the bus without being stopped.
+type RestartPolicy int
+
+const (
+	RestartNo RestartPolicy = iota
+	RestartOnFailure
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNo:
+		return "no"
+	case RestartOnFailure:
+		return "on-failure"
+	default:
+		return "unknown"
+	}
+}
+
+func parseRestartPolicy(s string) (RestartPolicy, error) {
+	switch s {
+	case "", "no":
+		return RestartNo, nil
+	case "on-failure":
+		return RestartOnFailure, nil
+	default:
+		return RestartNo, errors.New("unknown Restart policy " + s)
+	}
+}
+
+// Restart is what the component asked to happen to its listener if it
+// drops off the bus, as given by its Restart key.
+func (c *Component) Restart() RestartPolicy {
+	return c.restart
+}
+
+// RestartDelay is how long to wait before restarting the component's
+// listener after it drops off the bus, as given by its RestartSec key.
+// The delay doubles with each consecutive restart, up to
+// RestartMaxDelay.
+func (c *Component) RestartDelay() time.Duration {
+	return c.restartDelay
+}
+
+// RestartMaxDelay is the longest the component's listener is left
+// before being restarted, as given by its RestartMaxSec key. A
+// listener that stays on the bus for this long is no longer counted
+// as failing.
+func (c *Component) RestartMaxDelay() time.Duration {
+	return c.restartMaxDelay
+}
+
+// RestartOnChange reports whether the component, if ephemerad stopped
+// it while it was running because its instance changed, should be
+// started again from its new definition, as given by its
+// RestartOnChange key.
+func (c *Component) RestartOnChange() bool {
+	return c.restartOnChange
+}
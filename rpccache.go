@@ -0,0 +1,71 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// rpcCache holds the results of RPCs declared with a CacheTTL so that
+// repeated calls with the same input are answered without running
+// the script again. Only successful results are cached.
+type rpcCache struct {
+	mu      sync.Mutex
+	entries map[rpcCacheKey]rpcCacheEntry
+}
+
+type rpcCacheKey struct {
+	module, name string
+	input        [sha256.Size]byte
+}
+
+type rpcCacheEntry struct {
+	out     encodedString
+	expires time.Time
+}
+
+func rpcCacheNew() *rpcCache {
+	return &rpcCache{
+		entries: make(map[rpcCacheKey]rpcCacheEntry),
+	}
+}
+
+func (c *rpcCache) key(module, name string, in []byte) rpcCacheKey {
+	return rpcCacheKey{
+		module: module,
+		name:   name,
+		input:  sha256.Sum256(in),
+	}
+}
+
+func (c *rpcCache) get(key rpcCacheKey) (encodedString, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.out, true
+}
+
+func (c *rpcCache) put(key rpcCacheKey, out encodedString, ttl time.Duration) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = rpcCacheEntry{out: out, expires: now.Add(ttl)}
+}
+
+// clear drops every cached result.
+func (c *rpcCache) clear() {
+	c.mu.Lock()
+	c.entries = make(map[rpcCacheKey]rpcCacheEntry)
+	c.mu.Unlock()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// schedule says when ephemerad should run a oneshot component by
+// itself. OnCalendar takes a cron-like expression and Interval a
+// duration measured from the previous run; if both are given the
+// earlier of the two is used. Each run is delayed by a random amount
+// up to RandomizedDelay so that many routers sharing an instance file
+// don't all run it at the same moment.
+type schedule struct {
+	calendar        *cronExpr
+	interval        time.Duration
+	randomizedDelay time.Duration
+}
+
+func parseSchedule(section *ini.Section) (*schedule, error) {
+	calendar, err := parseCronExpr(section.Key("OnCalendar").String())
+	if err != nil {
+		return nil, errors.New("OnCalendar " + err.Error())
+	}
+	interval := section.Key("Interval").MustDuration(0)
+	if interval < 0 {
+		return nil, errors.New("Interval must not be negative")
+	}
+	if calendar == nil && interval == 0 {
+		return nil, nil
+	}
+	return &schedule{
+		calendar:        calendar,
+		interval:        interval,
+		randomizedDelay: section.Key("RandomizedDelay").MustDuration(0),
+	}, nil
+}
+
+func (s *schedule) next(after time.Time) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	var next time.Time
+	if s.interval > 0 {
+		next = after.Add(s.interval)
+	}
+	if s.calendar != nil {
+		t, ok := s.calendar.next(after)
+		if ok && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		return next, false
+	}
+	if s.randomizedDelay > 0 {
+		next = next.Add(time.Duration(rand.Int63n(
+			int64(s.randomizedDelay))))
+	}
+	return next, true
+}
+
+func (s *schedule) Equal(other interface{}) bool {
+	osc, isSchedule := other.(*schedule)
+	if s == nil || osc == nil {
+		return isSchedule && s == osc
+	}
+	return s.calendar.String() == osc.calendar.String() &&
+		s.interval == osc.interval &&
+		s.randomizedDelay == osc.randomizedDelay
+}
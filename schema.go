@@ -0,0 +1,439 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// CurrentFormatVersion is the newest instance file format understood
+// by this package. Instance files declare the format they were
+// written for with the FormatVersion key.
+const CurrentFormatVersion = 1
+
+// KeyType is the type of value a key in an instance file takes.
+type KeyType string
+
+const (
+	KeyString   KeyType = "string"
+	KeyBoolean  KeyType = "boolean"
+	KeyInteger  KeyType = "integer"
+	KeyDuration KeyType = "duration"
+)
+
+// KeySchema describes a key that may appear in a section. Keys are
+// matched either by Name or, for families of keys such as
+// SecretEnv/NAME, by the regular expression Pattern.
+type KeySchema struct {
+	Name        string   `json:"name,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Type        KeyType  `json:"type"`
+	Enum        []string `json:"enum,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Description string   `json:"description"`
+}
+
+// SectionSchema describes a section of an instance file, matched
+// either by Name or by the regular expression Pattern.
+type SectionSchema struct {
+	Name        string      `json:"name,omitempty"`
+	Pattern     string      `json:"pattern,omitempty"`
+	Description string      `json:"description"`
+	Keys        []KeySchema `json:"keys"`
+}
+
+// Schema describes the instance file format.
+type Schema struct {
+	FormatVersion int             `json:"format-version"`
+	Sections      []SectionSchema `json:"sections"`
+}
+
+// InstanceSchema is the schema for the current instance file format.
+var InstanceSchema = Schema{
+	FormatVersion: CurrentFormatVersion,
+	Sections: []SectionSchema{
+		{
+			Name:        "Component",
+			Description: "The component and how it is run",
+			Keys: []KeySchema{
+				{Name: "FormatVersion", Type: KeyInteger, Default: "1",
+					Description: "Instance file format version"},
+				{Name: "ProtocolVersion", Type: KeyInteger, Default: "1",
+					Description: "Newest script environment contract " +
+						"version the scripts understand"},
+				{Name: "Name", Type: KeyString,
+					Description: "Component name, in reverse-DNS form"},
+				{Name: "BaseInstance", Type: KeyString,
+					Description: "Instance file inherited from, " +
+						"relative to this file's directory " +
+						"unless absolute"},
+				{Name: "Start", Type: KeyString,
+					Description: "Command run on activation"},
+				{Name: "Stop", Type: KeyString,
+					Description: "Command run on deactivation"},
+				{Name: "Owner", Type: KeyString,
+					Description: "Subsystem or feature the component " +
+						"belongs to, otherwise the package " +
+						"installing it"},
+				{Name: "Description", Type: KeyString,
+					Description: "What the component does"},
+				{Name: "DocURL", Type: KeyString,
+					Description: "Where the component is documented"},
+				{Name: "DeprecatedSince", Type: KeyString,
+					Description: "Release the component was " +
+						"deprecated in"},
+				{Name: "ReplacedBy", Type: KeyString,
+					Description: "Component replacing this one"},
+				{Name: "RedirectActivation", Type: KeyBoolean,
+					Default: "false",
+					Description: "Activate the ReplacedBy component " +
+						"instead of this one"},
+				{Name: "Enabled", Type: KeyBoolean, Default: "true",
+					Description: "Whether the component may be activated"},
+				{Name: "Type", Type: KeyString,
+					Enum: []string{"simple", "oneshot"}, Default: "simple",
+					Description: "How the component is run when activated"},
+				{Name: "ActiveOnly", Type: KeyBoolean, Default: "false",
+					Description: "Only run on the HA active router"},
+				{Name: "OnActive", Type: KeyString,
+					Description: "Command run on becoming HA active"},
+				{Name: "OnStandby", Type: KeyString,
+					Description: "Command run on becoming HA standby"},
+				{Name: "ActiveWindow", Type: KeyString,
+					Description: "Cron-like expression restricting activation"},
+				{Name: "OnCalendar", Type: KeyString,
+					Description: "Cron-like expression scheduling oneshot runs"},
+				{Name: "Interval", Type: KeyDuration,
+					Description: "Interval between scheduled oneshot runs"},
+				{Name: "RandomizedDelay", Type: KeyDuration, Default: "0s",
+					Description: "Maximum random delay of scheduled runs"},
+				{Name: "After", Type: KeyString,
+					Description: "Space separated systemd:unit " +
+						"dependencies and components whose activation " +
+						"is waited for"},
+				{Name: "Requires", Type: KeyString,
+					Description: "Space separated components " +
+						"activated before the component"},
+				{Name: "User", Type: KeyString,
+					Description: "User the component's scripts run as"},
+				{Name: "Group", Type: KeyString,
+					Description: "Group the component's scripts run as"},
+				{Name: "Parameters", Type: KeyString,
+					Description: "Space separated names of the " +
+						"parameters Start may be activated with"},
+				{Name: "Sessions", Type: KeyBoolean, Default: "false",
+					Description: "Whether each activation starts a " +
+						"separate session"},
+				{Name: "SessionLease", Type: KeyDuration, Default: "1h",
+					Description: "How long a session lasts unless " +
+						"renewed"},
+				{Name: "IdleTimeout", Type: KeyDuration, Default: "0s",
+					Description: "How long the component may go " +
+						"without a Config, State or RPC request before " +
+						"it is deactivated, 0 for never"},
+				{Name: "OnDemand", Type: KeyBoolean, Default: "false",
+					Description: "Whether the first Config, State or " +
+						"RPC request activates the component"},
+				{Name: "Bus", Type: KeyString,
+					Description: "Name of the bus, given to ephemerad " +
+						"with -bus, the component is registered on; " +
+						"the system bus if unset"},
+				{Name: "Restart", Type: KeyString,
+					Enum:    []string{"no", "on-failure"},
+					Default: "no",
+					Description: "Whether the component's listener " +
+						"is restarted if it drops off the bus"},
+				{Name: "RestartSec", Type: KeyDuration, Default: "1s",
+					Description: "Delay before the first restart, " +
+						"doubling with each consecutive restart"},
+				{Name: "RestartMaxSec", Type: KeyDuration, Default: "5m",
+					Description: "Longest delay between restarts"},
+				{Name: "RestartOnChange", Type: KeyBoolean,
+					Default: "false",
+					Description: "Whether the component, if it was " +
+						"running when its instance changed, is " +
+						"started again from its new definition"},
+				{Name: "Assets", Type: KeyString,
+					Description: "Space separated files, relative to " +
+						"the instance file, whose changes restart " +
+						"the component"},
+				{Name: "OnRepeatedFailure", Type: KeyString,
+					Enum:        []string{"ignore", "deactivate", "restart"},
+					Default:     "ignore",
+					Description: "What to do once the circuit opens"},
+				{Name: "FailureThreshold", Type: KeyInteger,
+					Description: "Consecutive failures that open the circuit"},
+				{Name: "FailureCooldown", Type: KeyDuration, Default: "30s",
+					Description: "How long the circuit stays open"},
+				{Name: "MaxConcurrentOps", Type: KeyInteger, Default: "0",
+					Description: "Most scripts run at once, 0 for no limit"},
+				{Name: "MaxQueuedOps", Type: KeyInteger, Default: "0",
+					Description: "Most operations waiting to run before " +
+						"further ones fail as busy, 0 for no limit"},
+				{Name: "BackpressureThreshold", Type: KeyInteger,
+					Default: "0",
+					Description: "Queued operations at which scripts " +
+						"are asked to reduce their work, 0 for never"},
+				{Name: "StartTimeout", Type: KeyDuration,
+					Description: "Longest Start, OnActive or OnStandby " +
+						"may run, overriding ephemerad's default"},
+				{Name: "StopTimeout", Type: KeyDuration,
+					Description: "Longest Stop may run, overriding " +
+						"ephemerad's default"},
+				{Name: "SetTimeout", Type: KeyDuration,
+					Description: "Longest Config/Set or Config/Check " +
+						"may run, overriding ephemerad's default"},
+				{Name: "GetTimeout", Type: KeyDuration,
+					Description: "Longest Config/Get or State/Get may " +
+						"run, overriding ephemerad's default"},
+				{Name: "RPCTimeout", Type: KeyDuration,
+					Description: "Longest an RPC may run, overriding " +
+						"ephemerad's default"},
+				{Name: "AutoLock", Type: KeyBoolean, Default: "false",
+					Description: "Hold the component's lock while " +
+						"running Start, Stop and Config/Set"},
+				{Name: "AmbientCapabilities", Type: KeyString,
+					Description: "Space separated capabilities for scripts"},
+				{Pattern: "^SecretEnv/[A-Za-z_][A-Za-z0-9_]*$",
+					Type: KeyString,
+					Description: "keystore:name or file:/path secret " +
+						"exposed to scripts as an environment variable"},
+				{Name: "LogFile", Type: KeyString,
+					Description: "File capturing script output"},
+				{Name: "LogFileMaxSize", Type: KeyInteger,
+					Default:     "1048576",
+					Description: "Size at which LogFile is rotated"},
+			},
+		},
+		{
+			Pattern:     "^Model [^ ]+$",
+			Description: "A model the component provides",
+			Keys: []KeySchema{
+				{Name: "Enabled", Type: KeyBoolean, Default: "true",
+					Description: "Whether the model is registered"},
+				{Name: "Config/Get", Type: KeyString,
+					Description: "Command returning the configuration"},
+				{Name: "Config/Set", Type: KeyString,
+					Description: "Command applying the configuration"},
+				{Name: "Config/Check", Type: KeyString,
+					Description: "Command validating the configuration"},
+				{Name: "State/Get", Type: KeyString,
+					Description: "Command returning the state"},
+				{Name: "State/Get/NegativeTTL", Type: KeyDuration,
+					Default: "1s",
+					Description: "How long a failure of State/Get is " +
+						"remembered instead of running it again"},
+				{Name: "State/RefreshInterval", Type: KeyDuration,
+					Default: "0s",
+					Description: "How often State/Get is run in the " +
+						"background, polls being answered with its " +
+						"last output, 0 for never"},
+				{Name: "StatePaths", Type: KeyString,
+					Description: "Space separated YANG paths whose " +
+						"state the model serves"},
+				{Name: "LegacyWrapper", Type: KeyString,
+					Description: "Legacy component wrapper run with " +
+						"--action=<action> for operations the model " +
+						"doesn't set"},
+				{Name: "LegacyActions", Type: KeyString,
+					Default: "validate commit get-config get-state",
+					Description: "Space separated actions the " +
+						"LegacyWrapper implements"},
+				{Name: "LegacyRPCs", Type: KeyString,
+					Description: "Space separated module/name RPCs " +
+						"the LegacyWrapper implements"},
+				{Pattern: "^RPC/[^/]+/[^/]+$", Type: KeyString,
+					Description: "Command implementing RPC/module/name"},
+				{Pattern: "^RPC/[^/]+/[^/]+/CacheTTL$", Type: KeyDuration,
+					Description: "How long results of the RPC are cached"},
+				{Pattern: "^RPC/[^/]+/[^/]+/Description$",
+					Type:        KeyString,
+					Description: "What the RPC does"},
+				{Pattern: "^RPC/[^/]+/[^/]+/DocURL$", Type: KeyString,
+					Description: "Where the RPC is documented"},
+				{Pattern: "^(Config/(Get|Set|Check)|State/Get)/Timeout$",
+					Type: KeyDuration,
+					Description: "Longest the operation may run, " +
+						"overriding the component's timeout"},
+				{Pattern: "^RPC/[^/]+/[^/]+/Timeout$", Type: KeyDuration,
+					Description: "Longest the RPC may run, overriding " +
+						"the component's RPCTimeout"},
+			},
+		},
+	},
+}
+
+func (s *SectionSchema) matches(name string) bool {
+	if s.Pattern != "" {
+		return regexp.MustCompile(s.Pattern).MatchString(name)
+	}
+	return s.Name == name
+}
+
+func (k *KeySchema) matches(name string) bool {
+	if k.Pattern != "" {
+		return regexp.MustCompile(k.Pattern).MatchString(name)
+	}
+	return k.Name == name
+}
+
+func (k *KeySchema) check(value string) error {
+	var err error
+	switch k.Type {
+	case KeyBoolean:
+		_, err = strconv.ParseBool(value)
+	case KeyInteger:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case KeyDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return errors.New("expected " + string(k.Type) +
+			" not " + strconv.Quote(value))
+	}
+	if len(k.Enum) == 0 {
+		return nil
+	}
+	for _, v := range k.Enum {
+		if v == value {
+			return nil
+		}
+	}
+	return errors.New("expected one of " + strings.Join(k.Enum, ", ") +
+		" not " + strconv.Quote(value))
+}
+
+func (s *Schema) section(name string) *SectionSchema {
+	for i := range s.Sections {
+		if s.Sections[i].matches(name) {
+			return &s.Sections[i]
+		}
+	}
+	return nil
+}
+
+func (s *SectionSchema) key(name string) *KeySchema {
+	for i := range s.Keys {
+		if s.Keys[i].matches(name) {
+			return &s.Keys[i]
+		}
+	}
+	return nil
+}
+
+// validate checks that every section and key in cfg is described by
+// the schema and that each value has the right type.
+func (s *Schema) validate(cfg *ini.File) error {
+	var errs []string
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DEFAULT_SECTION {
+			for _, key := range section.Keys() {
+				errs = append(errs, "key "+key.Name()+
+					" must be in a section")
+			}
+			continue
+		}
+		ss := s.section(section.Name())
+		if ss == nil {
+			errs = append(errs, "unknown section ["+
+				section.Name()+"]")
+			continue
+		}
+		for _, key := range section.Keys() {
+			ks := ss.key(key.Name())
+			if ks == nil {
+				errs = append(errs, "unknown key "+key.Name()+
+					" in ["+section.Name()+"]")
+				continue
+			}
+			err := ks.check(key.String())
+			if err != nil {
+				errs = append(errs, "["+section.Name()+"] "+
+					key.Name()+": "+err.Error())
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// JSONSchema renders the schema as a JSON Schema document describing
+// an instance file as an object of sections, each an object of keys.
+func (s *Schema) JSONSchema() ([]byte, error) {
+	doc := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$id": "https://github.com/danos/ephemera/schema/instance-v" +
+			strconv.Itoa(s.FormatVersion) + ".json",
+		"title":                "ephemera instance file",
+		"type":                 "object",
+		"additionalProperties": false,
+	}
+	props := make(map[string]interface{})
+	patternProps := make(map[string]interface{})
+	for _, ss := range s.Sections {
+		if ss.Pattern != "" {
+			patternProps[ss.Pattern] = ss.jsonSchema()
+		} else {
+			props[ss.Name] = ss.jsonSchema()
+		}
+	}
+	doc["properties"] = props
+	doc["patternProperties"] = patternProps
+	doc["required"] = []string{"Component"}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func (s *SectionSchema) jsonSchema() map[string]interface{} {
+	props := make(map[string]interface{})
+	patternProps := make(map[string]interface{})
+	for _, ks := range s.Keys {
+		if ks.Pattern != "" {
+			patternProps[ks.Pattern] = ks.jsonSchema()
+		} else {
+			props[ks.Name] = ks.jsonSchema()
+		}
+	}
+	return map[string]interface{}{
+		"description":          s.Description,
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           props,
+		"patternProperties":    patternProps,
+	}
+}
+
+func (k *KeySchema) jsonSchema() map[string]interface{} {
+	out := map[string]interface{}{
+		"description": k.Description,
+	}
+	switch k.Type {
+	case KeyDuration:
+		out["type"] = "string"
+		out["pattern"] = "^(0|([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+)$"
+	default:
+		out["type"] = string(k.Type)
+	}
+	if len(k.Enum) != 0 {
+		out["enum"] = k.Enum
+	}
+	switch {
+	case k.Default == "":
+	case k.Type == KeyBoolean:
+		out["default"], _ = strconv.ParseBool(k.Default)
+	case k.Type == KeyInteger:
+		out["default"], _ = strconv.ParseInt(k.Default, 10, 64)
+	default:
+		out["default"] = k.Default
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/go-ini/ini"
+)
+
+const defaultKeystoreDir = "/config/auth/ephemera"
+
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretEnv maps environment variable names to the location of the
+// secret that should be placed in them. Locations are either
+// 'keystore:name', relative to the platform keystore directory, or
+// 'file:/path'. Secrets are only read when a script is run so that
+// their values never appear in the instance file or in logs.
+type secretEnv struct {
+	keystoreDir string
+	sources     map[string]string
+}
+
+func secretEnvNew(keystoreDir string, section *ini.Section) (*secretEnv, error) {
+	s := &secretEnv{
+		keystoreDir: keystoreDir,
+		sources:     make(map[string]string),
+	}
+	for _, key := range section.Keys() {
+		if !strings.HasPrefix(key.Name(), "SecretEnv/") {
+			continue
+		}
+		name := strings.TrimPrefix(key.Name(), "SecretEnv/")
+		if !envNameRE.MatchString(name) {
+			return nil, errors.New("invalid environment variable " +
+				"name in " + key.Name())
+		}
+		source := key.String()
+		if !strings.HasPrefix(source, "keystore:") &&
+			!strings.HasPrefix(source, "file:") {
+			return nil, errors.New("unknown secret source for " +
+				key.Name())
+		}
+		s.sources[name] = source
+	}
+	return s, nil
+}
+
+func (s *secretEnv) environment() ([]string, error) {
+	var out []string
+	for name, source := range s.sources {
+		secret, err := s.read(source)
+		if err != nil {
+			return nil, errors.New("unable to read secret for " +
+				name + ": " + err.Error())
+		}
+		out = append(out, name+"="+secret)
+	}
+	return out, nil
+}
+
+func (s *secretEnv) read(source string) (string, error) {
+	var path string
+	switch {
+	case strings.HasPrefix(source, "keystore:"):
+		name := strings.TrimPrefix(source, "keystore:")
+		path = filepath.Join(s.keystoreDir, filepath.Clean("/"+name))
+	default:
+		path = strings.TrimPrefix(source, "file:")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Uid) != os.Geteuid() || fi.Mode().Perm()&0077 != 0 {
+		return "", errors.New(path + " is accessible by other users")
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\n"), nil
+}
+
+func (s *secretEnv) Equal(other interface{}) bool {
+	oe, isSecretEnv := other.(*secretEnv)
+	if !isSecretEnv || s.keystoreDir != oe.keystoreDir ||
+		len(s.sources) != len(oe.sources) {
+		return false
+	}
+	for name, source := range s.sources {
+		if oe.sources[name] != source {
+			return false
+		}
+	}
+	return true
+}
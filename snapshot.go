@@ -0,0 +1,171 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot describes the component as ephemera understood its
+// instance file, after migration and with defaults filled in. Keys
+// are named as in the instance file, prefixed by their section, such
+// as "Component/Start" or "Model net.vyatta.example.v1/State/Get", so
+// that snapshots taken by different releases can be compared to find
+// changes in how instance files are interpreted. Keys with no value
+// are omitted.
+func (c *Component) Snapshot() map[string]string {
+	out := make(map[string]string)
+	put := func(key, value string) {
+		if value != "" {
+			out[key] = value
+		}
+	}
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+
+	put("Component/FormatVersion", strconv.Itoa(c.formatVersion))
+	put("Component/ProtocolVersion",
+		strconv.Itoa(c.runner.protocolVersion))
+	put("Component/Name", c.name)
+	put("Component/Owner", c.owner)
+	put("Component/Description", c.description)
+	put("Component/DocURL", c.docURL)
+	put("Component/DeprecatedSince", c.deprecatedSince)
+	put("Component/ReplacedBy", c.replacedBy)
+	put("Component/RedirectActivation",
+		strconv.FormatBool(c.redirectActivation))
+	put("Component/Start", c.start)
+	put("Component/Stop", c.stop)
+	put("Component/Enabled", strconv.FormatBool(c.enabled))
+	put("Component/Type", c.typ.String())
+	put("Component/ActiveOnly", strconv.FormatBool(c.activeOnly))
+	put("Component/OnActive", c.onActive)
+	put("Component/OnStandby", c.onStandby)
+	put("Component/ActiveWindow", c.activeWindow.String())
+	if c.schedule != nil {
+		put("Component/OnCalendar", c.schedule.calendar.String())
+		put("Component/Interval", duration(c.schedule.interval))
+		put("Component/RandomizedDelay",
+			duration(c.schedule.randomizedDelay))
+	}
+	var after []string
+	for _, unit := range c.afterUnits {
+		after = append(after, systemdPrefix+unit)
+	}
+	after = append(after, c.afterComps...)
+	put("Component/After", strings.Join(after, " "))
+	put("Component/Requires", strings.Join(c.requires, " "))
+	for path, sum := range c.assets {
+		put("Component/Assets/"+path, "sha256:"+sum)
+	}
+	put("Component/OnRepeatedFailure", c.failurePolicy.String())
+	put("Component/FailureThreshold", strconv.Itoa(c.breaker.threshold))
+	put("Component/FailureCooldown", duration(c.breaker.cooldown))
+	put("Component/MaxConcurrentOps",
+		strconv.Itoa(c.runner.limiter.maxInFlight))
+	put("Component/MaxQueuedOps", strconv.Itoa(c.runner.limiter.maxQueued))
+	put("Component/BackpressureThreshold",
+		strconv.Itoa(c.runner.limiter.backpressure))
+	put("Component/StartTimeout", duration(c.runner.timeouts.Start))
+	put("Component/StopTimeout", duration(c.runner.timeouts.Stop))
+	put("Component/SetTimeout", duration(c.runner.timeouts.Set))
+	put("Component/GetTimeout", duration(c.runner.timeouts.Get))
+	put("Component/RPCTimeout", duration(c.runner.timeouts.RPC))
+	put("Component/User", c.runner.credentials.user)
+	put("Component/Group", c.runner.credentials.group)
+	put("Component/Sessions", strconv.FormatBool(c.sessions))
+	put("Component/SessionLease", duration(c.sessionLease))
+	put("Component/IdleTimeout", duration(c.idleTimeout))
+	put("Component/OnDemand", strconv.FormatBool(c.onDemand))
+	put("Component/Bus", c.bus)
+	put("Component/Restart", c.restart.String())
+	put("Component/RestartSec", duration(c.restartDelay))
+	put("Component/RestartMaxSec", duration(c.restartMaxDelay))
+	put("Component/RestartOnChange", strconv.FormatBool(c.restartOnChange))
+	put("Component/Parameters", strings.Join(c.params.declared, " "))
+	put("Component/AutoLock", strconv.FormatBool(c.runner.lock.auto))
+	put("Component/AmbientCapabilities",
+		strings.Join(capabilityNames(c.runner.ambientCaps), " "))
+	for name, source := range c.runner.secrets.sources {
+		put("Component/SecretEnv/"+name, source)
+	}
+	if c.runner.log != nil {
+		put("Component/LogFile", c.runner.log.path)
+		put("Component/LogFileMaxSize",
+			strconv.FormatInt(c.runner.log.maxSize, 10))
+	}
+
+	for name, model := range c.models {
+		prefix := "Model " + name + "/"
+		put(prefix+"Enabled", strconv.FormatBool(model.enabled))
+		put(prefix+"StatePaths", strings.Join(model.statePaths, " "))
+		if model.config != nil {
+			put(prefix+"Config/Get", model.config.get)
+			put(prefix+"Config/Set", model.config.set)
+			put(prefix+"Config/Check", model.config.check)
+		}
+		if model.state != nil {
+			put(prefix+"State/Get", model.state.get)
+			put(prefix+"State/Get/NegativeTTL",
+				duration(model.state.negativeTTL))
+			put(prefix+"State/RefreshInterval",
+				duration(model.state.refreshInterval))
+		}
+		for operation, timeout := range model.timeouts {
+			// RPC timeouts are among the RPC options below.
+			if !strings.HasPrefix(operation, "RPC/") {
+				put(prefix+operation+"/Timeout", duration(timeout))
+			}
+		}
+		if model.rpc == nil {
+			continue
+		}
+		for module, rpcs := range model.rpc.modules {
+			for rpcName, script := range rpcs {
+				put(prefix+"RPC/"+module+"/"+rpcName, script)
+			}
+		}
+		for option, value := range model.rpc.options {
+			put(prefix+"RPC/"+option, value)
+		}
+	}
+	return out
+}
+
+// Hash is a hex encoded SHA-256 digest of the component's Snapshot,
+// leaving out its documentation: the Description and DocURL of the
+// component and of its RPCs. Components with the same hash are
+// defined alike, however their instance files are laid out and
+// whatever they say about themselves, so the hash can be used to
+// detect changes that need the component restarting or to check
+// deployed instances against a release manifest.
+func (c *Component) Hash() string {
+	snap := c.Snapshot()
+	for key := range snap {
+		if isDocumentation(key) {
+			delete(snap, key)
+		}
+	}
+	// Maps are encoded with their keys sorted, so the encoding is
+	// stable.
+	buf, _ := json.Marshal(snap)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// isDocumentation reports whether the Snapshot key only documents
+// the component, rather than changing how it behaves.
+func isDocumentation(key string) bool {
+	return strings.HasSuffix(key, "/Description") ||
+		strings.HasSuffix(key, "/DocURL")
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"math/rand"
+	"time"
+)
+
+// A model with a State/RefreshInterval has its State/Get script run
+// in the background at that interval, give or take a tenth so that
+// models refreshing alike don't run together, while the component is
+// active. Polls are answered with the last output, so a slow backend
+// doesn't hold them up, for up to twice the interval, after which
+// they run the script themselves as if nothing were cached.
+
+// stateMaxStaleness is how many refresh intervals the output of
+// State/Get is served for.
+const stateMaxStaleness = 2
+
+// fresh returns the output of State/Get cached by a refresh, if it
+// was refreshed recently enough to be served.
+func (c *state) fresh() (encodedString, bool) {
+	if c.refreshInterval <= 0 || !featureEnabled(FeatureStateRefresh) {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached == nil ||
+		time.Since(c.cachedAt) > stateMaxStaleness*c.refreshInterval {
+		return nil, false
+	}
+	return c.cached, true
+}
+
+// store caches buf, the output of State/Get, if the model refreshes
+// its state.
+func (c *state) store(buf encodedString) {
+	if c.refreshInterval <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.cached = buf
+	c.cachedAt = time.Now()
+	c.mu.Unlock()
+}
+
+// refresh runs State/Get, caching its output, or remembering its
+// failure. Refreshes don't count as requests, so they don't keep the
+// component from being idle.
+func (c *state) refresh() {
+	if !featureEnabled(FeatureStateRefresh) {
+		return
+	}
+	buf, err := c.runner.output(c.modelName, "State/Get", c.get, nil)
+	if err != nil {
+		c.failed()
+		return
+	}
+	c.store(buf)
+}
+
+// refreshAfter returns a channel receiving once d has passed, and a
+// function releasing it if it is no longer wanted. It is a variable so
+// that tests can drive refreshes themselves.
+var refreshAfter = func(d time.Duration) (<-chan time.Time, func()) {
+	timer := time.NewTimer(d)
+	return timer.C, func() { timer.Stop() }
+}
+
+// refreshLoop refreshes the state at the refresh interval until done
+// is closed, closing exited once it has returned.
+func (c *state) refreshLoop(done, exited chan struct{}) {
+	defer close(exited)
+	for {
+		after, release := refreshAfter(jitter(c.refreshInterval))
+		select {
+		case <-done:
+			release()
+			return
+		case <-after:
+		}
+		c.refresh()
+	}
+}
+
+// jitter returns d give or take up to a tenth of it.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 5)
+	if spread <= 0 {
+		return d
+	}
+	return d - d/10 + time.Duration(rand.Int63n(spread))
+}
+
+// startStateRefresh starts refreshing the state of each of the
+// component's models with a State/RefreshInterval, unless it already
+// is. It is called once the component's Start script has succeeded.
+func (c *Component) startStateRefresh() {
+	if c.typ != TypeSimple {
+		return
+	}
+	for _, m := range c.models {
+		s := m.state
+		if s == nil || s.get == "" || s.refreshInterval <= 0 ||
+			!m.enabled {
+			continue
+		}
+		s.mu.Lock()
+		if s.refreshDone == nil {
+			s.refreshDone = make(chan struct{})
+			s.refreshExited = make(chan struct{})
+			go s.refreshLoop(s.refreshDone, s.refreshExited)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// stopStateRefresh stops refreshing the state of the component's
+// models, waiting for any refresh under way to finish, and drops what
+// was cached so that it isn't served once the component is active
+// again.
+func (c *Component) stopStateRefresh() {
+	for _, m := range c.models {
+		s := m.state
+		if s == nil {
+			continue
+		}
+		s.mu.Lock()
+		done, exited := s.refreshDone, s.refreshExited
+		s.refreshDone, s.refreshExited = nil, nil
+		s.mu.Unlock()
+		if done != nil {
+			close(done)
+			<-exited
+		}
+		s.mu.Lock()
+		s.cached = nil
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"sync"
+	"time"
+)
+
+// OperationStats summarises the invocations of one of a component's
+// operations, such as "Start" or "RPC/module/name", since the
+// component was loaded.
+type OperationStats struct {
+	Count         uint64
+	Failures      uint64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+}
+
+type opStats struct {
+	mu         sync.Mutex
+	operations map[string]*OperationStats
+	onRecord   func(operation string, d time.Duration, err error)
+}
+
+// OnScriptFinished registers a function to be called after each of
+// the component's scripts finishes, with the operation it was run
+// for, how long it took and the error it failed with, if any. It is
+// how those keeping their own statistics, such as ones that outlive
+// the component, learn of each invocation.
+func OnScriptFinished(
+	fn func(*Component, string, time.Duration, error),
+) Opt {
+	return func(c *Component) {
+		c.stats.onRecord = func(
+			operation string,
+			d time.Duration,
+			err error,
+		) {
+			fn(c, operation, d, err)
+		}
+	}
+}
+
+func (s *opStats) record(operation string, d time.Duration, err error) {
+	if s.onRecord != nil {
+		defer s.onRecord(operation, d, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.operations == nil {
+		s.operations = make(map[string]*OperationStats)
+	}
+	st, ok := s.operations[operation]
+	if !ok {
+		st = &OperationStats{}
+		s.operations[operation] = st
+	}
+	st.Count++
+	if err != nil {
+		st.Failures++
+	}
+	st.TotalDuration += d
+	if d > st.MaxDuration {
+		st.MaxDuration = d
+	}
+}
+
+func (s *opStats) get() map[string]OperationStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]OperationStats, len(s.operations))
+	for operation, st := range s.operations {
+		out[operation] = *st
+	}
+	return out
+}
+
+// finished accounts for an invocation of a script, successful or not.
+func (r *runner) finished(
+	modelName, operation string,
+	begin time.Time,
+	in, out, errOut []byte,
+	err error,
+) {
+	r.stats.record(operation, time.Since(begin), err)
+	r.trace.record(modelName, operation, begin, in, out, errOut, err)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/danos/mgmterror"
+	"github.com/go-ini/ini"
+)
+
+// Timeouts bound how long each kind of script may run before it, and
+// any processes it started, are killed. A zero duration means no
+// limit.
+type Timeouts struct {
+	// Start bounds Start, OnActive and OnStandby scripts.
+	Start time.Duration
+	Stop  time.Duration
+	// Set bounds Config/Set and Config/Check scripts.
+	Set time.Duration
+	// Get bounds Config/Get and State/Get scripts.
+	Get time.Duration
+	RPC time.Duration
+}
+
+var defaultTimeouts = struct {
+	mu       sync.Mutex
+	timeouts Timeouts
+}{}
+
+// SetDefaultTimeouts sets the timeouts applied to subsequent script
+// invocations of components whose instance files don't set their own.
+func SetDefaultTimeouts(timeouts Timeouts) {
+	defaultTimeouts.mu.Lock()
+	defaultTimeouts.timeouts = timeouts
+	defaultTimeouts.mu.Unlock()
+}
+
+func getDefaultTimeouts() Timeouts {
+	defaultTimeouts.mu.Lock()
+	defer defaultTimeouts.mu.Unlock()
+	return defaultTimeouts.timeouts
+}
+
+func parseTimeouts(section *ini.Section) Timeouts {
+	return Timeouts{
+		Start: section.Key("StartTimeout").MustDuration(0),
+		Stop:  section.Key("StopTimeout").MustDuration(0),
+		Set:   section.Key("SetTimeout").MustDuration(0),
+		Get:   section.Key("GetTimeout").MustDuration(0),
+		RPC:   section.Key("RPCTimeout").MustDuration(0),
+	}
+}
+
+// parseOperationTimeouts reads a model's per operation timeouts, from
+// keys such as Config/Set/Timeout or RPC/module/name/Timeout, keyed by
+// operation.
+func parseOperationTimeouts(section *ini.Section) map[string]time.Duration {
+	timeouts := make(map[string]time.Duration)
+	for _, key := range section.Keys() {
+		operation := strings.TrimSuffix(key.Name(), "/Timeout")
+		if operation == key.Name() {
+			continue
+		}
+		switch {
+		case operation == "Config/Get", operation == "Config/Set",
+			operation == "Config/Check", operation == "State/Get",
+			strings.HasPrefix(operation, "RPC/") &&
+				strings.Count(operation, "/") == 2:
+			timeouts[operation] = key.MustDuration(0)
+		}
+	}
+	return timeouts
+}
+
+func equalTimeouts(a, b map[string]time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for operation, timeout := range a {
+		if b[operation] != timeout {
+			return false
+		}
+	}
+	return true
+}
+
+// timeout returns how long operation of modelName may run, preferring
+// the model's own timeout for the operation, then the component's and
+// lastly ephemerad's default.
+func (r *runner) timeout(modelName, operation string) time.Duration {
+	if timeout := r.modelTimeouts[modelName][operation]; timeout > 0 {
+		return timeout
+	}
+	return r.timeouts.orDefaults(getDefaultTimeouts()).
+		forOperation(operation)
+}
+
+// orDefaults replaces unset timeouts with those from defaults.
+func (t Timeouts) orDefaults(defaults Timeouts) Timeouts {
+	pick := func(d, def time.Duration) time.Duration {
+		if d == 0 {
+			return def
+		}
+		return d
+	}
+	return Timeouts{
+		Start: pick(t.Start, defaults.Start),
+		Stop:  pick(t.Stop, defaults.Stop),
+		Set:   pick(t.Set, defaults.Set),
+		Get:   pick(t.Get, defaults.Get),
+		RPC:   pick(t.RPC, defaults.RPC),
+	}
+}
+
+func (t Timeouts) forOperation(operation string) time.Duration {
+	switch {
+	case operation == "Start", operation == "OnActive",
+		operation == "OnStandby":
+		return t.Start
+	case operation == "Stop":
+		return t.Stop
+	case operation == "Config/Set", operation == "Config/Check":
+		return t.Set
+	case operation == "Config/Get", operation == "State/Get":
+		return t.Get
+	case strings.HasPrefix(operation, "RPC/"):
+		return t.RPC
+	default:
+		return 0
+	}
+}
+
+// killAfter kills the process group led by the started cmd if it is
+// still running after timeout. The returned function must be called
+// once cmd has been waited for and reports whether it was killed.
+func killAfter(cmd *exec.Cmd, timeout time.Duration) func() bool {
+	if timeout <= 0 {
+		return func() bool { return false }
+	}
+	var fired int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&fired, 1)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	return func() bool {
+		timer.Stop()
+		return atomic.LoadInt32(&fired) != 0
+	}
+}
+
+// KillAfter kills the process group led by the started cmd, which
+// must have been started with Setpgid, if it is still running after
+// timeout, as is done to scripts that time out. The returned function
+// must be called once cmd has been waited for and reports whether it
+// was killed.
+func KillAfter(cmd *exec.Cmd, timeout time.Duration) func() bool {
+	return killAfter(cmd, timeout)
+}
+
+// AppTagTimeout is the error-app-tag of the error an operation fails
+// with when its script timed out.
+const AppTagTimeout = "timeout"
+
+func timeoutError(operation string, timeout time.Duration) error {
+	err := mgmterror.NewOperationFailedApplicationError()
+	err.AppTag = AppTagTimeout
+	err.Message = message(MsgScriptTimeout,
+		"operation", operation, "timeout", timeout.String())
+	return err
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// scriptTempDir creates a private directory for the temporary files
+// of a single script invocation, exported to the script as
+// EPHEMERA_TMPDIR. Each invocation gets its own so that concurrent
+// invocations of the same script can't collide, and the directory is
+// removed once the script exits so that nothing is left behind. It
+// is owned by the user the script runs as.
+func scriptTempDir(compName string, owner *credentials) (string, error) {
+	dir, err := ioutil.TempDir("", "ephemera-"+compName+".")
+	if err != nil {
+		return "", err
+	}
+	err = owner.chown(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultTraceSize = 100
+
+// TraceEntry records a single script invocation made while tracing
+// was enabled for a component.
+type TraceEntry struct {
+	Time      time.Time
+	Model     string
+	Operation string
+	Duration  time.Duration
+	Input     string
+	Output    string
+	Stderr    string
+	Error     string
+}
+
+// tracer keeps the most recent invocations of a component's scripts,
+// with their full input and output, while it is enabled.
+type tracer struct {
+	size int
+
+	mu      sync.Mutex
+	enabled bool
+	entries []TraceEntry
+}
+
+func (t *tracer) setEnabled(enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enabled = enabled
+	if !enabled {
+		t.entries = nil
+	}
+}
+
+func (t *tracer) isEnabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.enabled
+}
+
+func (t *tracer) record(
+	modelName, operation string,
+	begin time.Time,
+	in, out, errOut []byte,
+	err error,
+) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.enabled {
+		return
+	}
+	entry := TraceEntry{
+		Time:      begin,
+		Model:     modelName,
+		Operation: operation,
+		Duration:  time.Since(begin),
+		Input:     string(in),
+		Output:    string(out),
+		Stderr:    string(errOut),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	t.entries = append(t.entries, entry)
+	if len(t.entries) > t.size {
+		t.entries = t.entries[len(t.entries)-t.size:]
+	}
+}
+
+func (t *tracer) get() []TraceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceEntry(nil), t.entries...)
+}
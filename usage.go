@@ -0,0 +1,58 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ScriptUsage accounts for the resources used by a component's
+// scripts since the component was loaded, as reported by the kernel
+// when each script exits.
+type ScriptUsage struct {
+	// PeakRSS is the largest resident set size reached by any of
+	// the scripts, in bytes.
+	PeakRSS uint64
+	// TotalRSS is the sum of each script's peak resident set
+	// size, in bytes.
+	TotalRSS uint64
+	// UserTime and SystemTime are the CPU time spent by the
+	// scripts in user and kernel mode.
+	UserTime   time.Duration
+	SystemTime time.Duration
+}
+
+type scriptUsage struct {
+	mu    sync.Mutex
+	usage ScriptUsage
+}
+
+func (u *scriptUsage) record(state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	// ru_maxrss is in kilobytes on Linux.
+	rss := uint64(rusage.Maxrss) * 1024
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.usage.TotalRSS += rss
+	if rss > u.usage.PeakRSS {
+		u.usage.PeakRSS = rss
+	}
+	u.usage.UserTime += state.UserTime()
+	u.usage.SystemTime += state.SystemTime()
+}
+
+func (u *scriptUsage) get() ScriptUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.usage
+}
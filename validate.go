@@ -0,0 +1,158 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// ValidationErrors are all of the problems found in a component's
+// definition, so that they can be fixed together.
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ResolveCommands makes New check that the program run by each of the
+// component's commands exists and is executable, rather than leaving
+// it to fail when the command is first run.
+func ResolveCommands() Opt {
+	return func(c *Component) {
+		c.resolveCommands = true
+	}
+}
+
+// validate checks the instantiated component, collecting every
+// problem found.
+func (c *Component) validate(cfg *ini.File) error {
+	var errs ValidationErrors
+	if c.name == "" {
+		errs = append(errs, errors.New("[Component] has no Name"))
+	}
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "Model ") {
+			continue
+		}
+		errs = append(errs, validateModelSection(section)...)
+	}
+	commands := c.commands()
+	labels := make([]string, 0, len(commands))
+	for label := range commands {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		err := c.validateCommand(commands[label])
+		if err != nil {
+			errs = append(errs, errors.New(label+": "+err.Error()))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+var cacheTTLSchema = InstanceSchema.section("Model x").key("RPC/m/n/CacheTTL")
+
+func validateModelSection(section *ini.Section) []error {
+	var errs []error
+	fields := strings.Fields(section.Name())
+	if len(fields) != 2 || fields[1] != strings.TrimPrefix(
+		section.Name(), "Model ") {
+		errs = append(errs, errors.New("malformed model section ["+
+			section.Name()+"], expected [Model <name>]"))
+	}
+	for _, key := range section.Keys() {
+		if !strings.HasPrefix(key.Name(), "RPC/") {
+			continue
+		}
+		parts := strings.Split(key.Name(), "/")
+		if (len(parts) != 3 && len(parts) != 4) ||
+			parts[1] == "" || parts[2] == "" {
+			errs = append(errs, errors.New("["+section.Name()+"] "+
+				"malformed RPC key "+key.Name()+
+				", expected RPC/<module>/<name>"))
+			continue
+		}
+		// A malformed TTL would otherwise silently disable the
+		// cache.
+		if len(parts) == 4 && parts[3] == "CacheTTL" {
+			err := cacheTTLSchema.check(key.String())
+			if err != nil {
+				errs = append(errs, errors.New("["+
+					section.Name()+"] "+key.Name()+": "+
+					err.Error()))
+			}
+		}
+	}
+	return errs
+}
+
+// commands returns each of the component's commands, labelled by
+// where it is defined.
+func (c *Component) commands() map[string]string {
+	out := make(map[string]string)
+	add := func(label, command string) {
+		if command != "" {
+			out[label] = command
+		}
+	}
+	add("Start", c.start)
+	add("Stop", c.stop)
+	add("OnActive", c.onActive)
+	add("OnStandby", c.onStandby)
+	for name, m := range c.models {
+		prefix := "[Model " + name + "] "
+		if m.config != nil {
+			add(prefix+"Config/Get", m.config.get)
+			add(prefix+"Config/Set", m.config.set)
+			add(prefix+"Config/Check", m.config.check)
+		}
+		if m.state != nil {
+			add(prefix+"State/Get", m.state.get)
+		}
+		if m.rpc != nil {
+			for module, rpcs := range m.rpc.modules {
+				for rpcName, command := range rpcs {
+					add(prefix+"RPC/"+module+"/"+rpcName, command)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func (c *Component) validateCommand(command string) error {
+	args, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || !c.resolveCommands {
+		return nil
+	}
+	if !strings.Contains(args[0], "/") {
+		_, err = exec.LookPath(args[0])
+		return err
+	}
+	fi, err := os.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() || fi.Mode()&0111 == 0 {
+		return errors.New(args[0] + " is not executable")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, AT&T Intellectual Property. All rights reseved.
+//
+// SPDX-License-Identifier: GPL-2.0-only
+package ephemera
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/danos/mgmterror"
+)
+
+// splitCommand splits a command from an instance file into words the
+// way a POSIX shell would, without expansions: words are separated by
+// unquoted blanks, single quotes preserve everything up to the next
+// single quote, and within double quotes a backslash only escapes
+// '"', '\', '$' and '`'. Outside quotes a backslash escapes any
+// character.
+func splitCommand(command string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+	)
+	for _, ch := range command {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`", ch) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(ch)
+			escaped = false
+		case quote == '\'':
+			if ch == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(ch)
+			}
+		case ch == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if ch == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inWord = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(ch)
+			inWord = true
+		}
+	}
+	switch {
+	case escaped:
+		return nil, errors.New("command ends with an escape: " + command)
+	case quote != 0:
+		return nil, errors.New("unterminated quote in command: " + command)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return words, nil
+}
+
+// SplitCommand splits command into words as the commands in instance
+// files are, so that other commands are quoted alike.
+func SplitCommand(command string) ([]string, error) {
+	return splitCommand(command)
+}
+
+func commandError(operation string, err error) error {
+	merr := mgmterror.NewOperationFailedApplicationError()
+	merr.Message = operation + ": " + err.Error()
+	return merr
+}